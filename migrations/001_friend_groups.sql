@@ -0,0 +1,21 @@
+-- Named lists of friends that can be invited to an album in one go
+CREATE TABLE IF NOT EXISTS friend_groups
+(
+    group_id   uuid        NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    owner_id   uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    group_name text        NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE INDEX IF NOT EXISTS friend_groups_owner_id_idx ON friend_groups (owner_id);
+
+CREATE TABLE IF NOT EXISTS friend_group_members
+(
+    group_id uuid        NOT NULL REFERENCES friend_groups (group_id) ON DELETE CASCADE,
+    user_id  uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    added_at timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+    PRIMARY KEY (group_id, user_id)
+);
+
+-- Bulk invites dedupe against this pair
+CREATE INDEX IF NOT EXISTS album_requests_album_id_invited_id_idx ON album_requests (album_id, invited_id);
@@ -0,0 +1,22 @@
+-- Reusable album setups that can recreate the same album on a schedule
+CREATE TABLE IF NOT EXISTS album_templates
+(
+    template_id         uuid        NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    owner_id            uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    name_pattern        text        NOT NULL,
+    visibility          text        NOT NULL,
+    guest_ids           uuid[]      NOT NULL DEFAULT '{}',
+    group_ids           uuid[]      NOT NULL DEFAULT '{}',
+    reveal_offset_hours integer     NOT NULL CHECK (reveal_offset_hours > 0),
+    recurrence          text        NOT NULL DEFAULT 'none'
+        CHECK (recurrence IN ('none', 'daily', 'weekly', 'biweekly', 'monthly')),
+    next_run_at         timestamptz,
+    last_album_id       uuid        REFERENCES albums (album_id) ON DELETE SET NULL,
+    run_count           integer     NOT NULL DEFAULT 0,
+    active              boolean     NOT NULL DEFAULT true,
+    created_at          timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE INDEX IF NOT EXISTS album_templates_owner_id_idx ON album_templates (owner_id);
+CREATE INDEX IF NOT EXISTS album_templates_due_idx ON album_templates (next_run_at)
+    WHERE active AND recurrence != 'none';
@@ -0,0 +1,9 @@
+-- Deleted albums are kept for the restore window before being purged, archived albums are hidden from feeds only
+ALTER TABLE albums
+    ADD COLUMN IF NOT EXISTS deleted_at  timestamptz,
+    ADD COLUMN IF NOT EXISTS archived_at timestamptz;
+
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS albums_deleted_at_idx ON albums (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,18 @@
+-- Side effects (Redis publishes and push notifications) written in the same transaction as the change that caused
+-- them and delivered afterwards by the outbox dispatcher
+CREATE TABLE IF NOT EXISTS outbox
+(
+    event_id        uuid        NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    idempotency_key text        NOT NULL UNIQUE,
+    destination     text        NOT NULL CHECK (destination IN ('redis', 'fcm')),
+    channel         text        NOT NULL,
+    payload         bytea       NOT NULL,
+    attempts        integer     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+    delivered_at    timestamptz,
+    last_error      text,
+    created_at      timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at, created_at)
+    WHERE delivered_at IS NULL;
@@ -0,0 +1,3 @@
+-- Outbox entries now carry domain events that are published on the event bus
+ALTER TABLE outbox DROP CONSTRAINT IF EXISTS outbox_destination_check;
+ALTER TABLE outbox ADD CONSTRAINT outbox_destination_check CHECK (destination IN ('event', 'redis', 'fcm'));
@@ -0,0 +1,104 @@
+-- One inbox for every kind of notification. type is the event type that created the row, payload is the event's
+-- notification as the clients already know it and subject_id is the image, comment or request it is about.
+CREATE TABLE IF NOT EXISTS user_notifications
+(
+    notification_id uuid        NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    recipient_id    uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    actor_id        uuid        REFERENCES users (user_id) ON DELETE SET NULL,
+    type            text        NOT NULL,
+    album_id        uuid        REFERENCES albums (album_id) ON DELETE CASCADE,
+    subject_id      text        NOT NULL,
+    source_key      text        NOT NULL UNIQUE,
+    payload         jsonb       NOT NULL,
+    read_at         timestamptz,
+    created_at      timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE INDEX IF NOT EXISTS user_notifications_inbox_idx
+    ON user_notifications (recipient_id, created_at DESC, notification_id DESC);
+CREATE INDEX IF NOT EXISTS user_notifications_unread_idx
+    ON user_notifications (recipient_id, type) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS user_notifications_subject_idx ON user_notifications (subject_id, type);
+
+-- Backfill from the tables the legacy endpoint reads
+INSERT INTO user_notifications (recipient_id, actor_id, type, album_id, subject_id, source_key, payload, read_at, created_at)
+SELECT n.receiver_id,
+       n.sender_id,
+       CASE n.type WHEN 'liked' THEN 'image.liked' ELSE 'image.upvoted' END,
+       n.album_id,
+       n.media_id::text,
+       'backfill:notifications:' || n.notification_uid,
+       jsonb_build_object('notification_id', n.notification_uid, 'image_id', n.media_id, 'album_id', n.album_id,
+                          'album_name', a.album_name, 'receiver_id', n.receiver_id, 'notifier_id', n.sender_id,
+                          'notifier_first', u.first_name, 'notifier_last', u.last_name,
+                          'notification_seen', n.seen, 'notification_type', n.type, 'received_at', n.received_at),
+       CASE WHEN n.seen THEN n.received_at END,
+       n.received_at
+FROM notifications n
+JOIN albums a ON a.album_id = n.album_id
+JOIN users u ON u.user_id = n.sender_id
+WHERE n.type IN ('liked', 'upvote')
+ON CONFLICT (source_key) DO NOTHING;
+
+INSERT INTO user_notifications (recipient_id, actor_id, type, album_id, subject_id, source_key, payload, read_at, created_at)
+SELECT i.image_owner,
+       c.commenter_id,
+       'comment.added',
+       a.album_id,
+       c.id::text,
+       'backfill:comments:' || c.id,
+       jsonb_build_object('id', c.id, 'image_id', c.image_id, 'image_owner', i.image_owner, 'album_id', a.album_id,
+                          'album_name', a.album_name, 'user_id', c.commenter_id, 'first_name', u.first_name,
+                          'last_name', u.last_name, 'comment', c.comment_text, 'created_at', c.created_at,
+                          'updated_at', c.updated_at, 'seen', c.seen),
+       CASE WHEN c.seen THEN c.created_at END,
+       c.created_at
+FROM comments c
+JOIN images i ON i.image_id = c.image_id
+JOIN imagealbum ia ON ia.image_id = i.image_id
+JOIN albums a ON a.album_id = ia.album_id
+JOIN users u ON u.user_id = c.commenter_id
+WHERE c.commenter_id != i.image_owner
+ON CONFLICT (source_key) DO NOTHING;
+
+INSERT INTO user_notifications (recipient_id, actor_id, type, subject_id, source_key, payload, read_at, created_at)
+SELECT CASE fr.status WHEN 'pending' THEN fr.receiver_id ELSE fr.sender_id END,
+       CASE fr.status WHEN 'pending' THEN fr.sender_id ELSE fr.receiver_id END,
+       CASE fr.status WHEN 'pending' THEN 'friend_request.sent' ELSE 'friend_request.accepted' END,
+       fr.request_id::text,
+       'backfill:friend_requests:' || fr.request_id,
+       jsonb_build_object('request_id', fr.request_id, 'received_at', fr.updated_at, 'sender_id', fr.sender_id,
+                          'receiver_id', fr.receiver_id, 'first_name', u.first_name, 'last_name', u.last_name,
+                          'status', fr.status, 'request_seen', fr.seen),
+       CASE WHEN fr.seen THEN fr.updated_at END,
+       fr.updated_at
+FROM friend_requests fr
+JOIN users u ON u.user_id = CASE fr.status WHEN 'pending' THEN fr.sender_id ELSE fr.receiver_id END
+WHERE fr.status IN ('pending', 'accepted')
+ON CONFLICT (source_key) DO NOTHING;
+
+INSERT INTO user_notifications (recipient_id, actor_id, type, album_id, subject_id, source_key, payload, read_at, created_at)
+SELECT CASE ar.status WHEN 'pending' THEN ar.invited_id ELSE a.album_owner END,
+       CASE ar.status WHEN 'pending' THEN a.album_owner ELSE ar.invited_id END,
+       CASE ar.status WHEN 'pending' THEN 'album_invite.sent' ELSE 'album_invite.accepted' END,
+       a.album_id,
+       ar.request_id::text,
+       'backfill:album_requests:' || ar.request_id,
+       jsonb_build_object('request_id', ar.request_id, 'album_id', a.album_id, 'album_name', a.album_name,
+                          'album_cover_id', a.album_cover_id, 'album_owner', a.album_owner,
+                          'owner_first', owner.first_name, 'owner_last', owner.last_name, 'guest_id', ar.invited_id,
+                          'guest_first', guest.first_name, 'guest_last', guest.last_name, 'status', ar.status,
+                          'invite_seen', ar.invite_seen, 'response_seen', ar.response_seen,
+                          'received_at', ar.updated_at, 'revealed_at', a.revealed_at),
+       CASE
+           WHEN ar.status = 'pending' AND ar.invite_seen THEN ar.updated_at
+           WHEN ar.status = 'accepted' AND ar.response_seen THEN ar.updated_at
+           END,
+       ar.updated_at
+FROM album_requests ar
+JOIN albums a ON a.album_id = ar.album_id
+JOIN users owner ON owner.user_id = a.album_owner
+JOIN users guest ON guest.user_id = ar.invited_id
+WHERE ar.status IN ('pending', 'accepted')
+AND ar.invited_id != a.album_owner
+ON CONFLICT (source_key) DO NOTHING;
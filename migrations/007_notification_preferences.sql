@@ -0,0 +1,31 @@
+-- Opt-outs per notification type and channel, a missing row means the notification is enabled
+CREATE TABLE IF NOT EXISTS notification_preferences
+(
+    user_id    uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    type       text        NOT NULL,
+    channel    text        NOT NULL CHECK (channel IN ('in_app', 'push', 'email')),
+    enabled    boolean     NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+    PRIMARY KEY (user_id, type, channel)
+);
+
+-- Pushes are not sent between quiet_hours_start and quiet_hours_end in the user's timezone, the range can wrap past
+-- midnight
+CREATE TABLE IF NOT EXISTS user_notification_settings
+(
+    user_id           uuid        NOT NULL PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    timezone          text        NOT NULL DEFAULT 'UTC',
+    quiet_hours_start time,
+    quiet_hours_end   time,
+    updated_at        timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+-- A muted album sends no notifications until muted_until, or until it is unmuted when muted_until is null
+CREATE TABLE IF NOT EXISTS album_mutes
+(
+    user_id     uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    album_id    uuid        NOT NULL REFERENCES albums (album_id) ON DELETE CASCADE,
+    muted_until timestamptz,
+    created_at  timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+    PRIMARY KEY (user_id, album_id)
+);
@@ -0,0 +1,17 @@
+-- Pushes are rendered in the user's language, locales without templates fall back to English
+ALTER TABLE user_notification_settings
+    ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT 'en';
+
+-- Set once the members of the album were told it is revealed
+ALTER TABLE albums
+    ADD COLUMN IF NOT EXISTS reveal_notified_at timestamptz;
+
+-- Albums revealed before this migration are not announced again
+UPDATE albums
+SET reveal_notified_at = revealed_at
+WHERE revealed_at <= (now() AT TIME ZONE 'utc'::text)
+  AND reveal_notified_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS albums_reveal_pending_idx
+    ON albums (revealed_at)
+    WHERE reveal_notified_at IS NULL AND deleted_at IS NULL;
@@ -0,0 +1,3 @@
+-- Tokens are sent through the provider they were issued by, apns tokens are sent to APNs directly
+ALTER TABLE firebase_tokens
+    ADD COLUMN IF NOT EXISTS provider text NOT NULL DEFAULT 'fcm' CHECK (provider IN ('fcm', 'apns'));
@@ -0,0 +1,7 @@
+-- When each user was last sent the weekly digest, a row is claimed before the digest is sent so every user gets one
+-- digest a week even with several instances running the job
+CREATE TABLE IF NOT EXISTS email_digests
+(
+    user_id      uuid        NOT NULL PRIMARY KEY REFERENCES users (user_id) ON DELETE CASCADE,
+    last_sent_at timestamptz NOT NULL
+);
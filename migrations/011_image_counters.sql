@@ -0,0 +1,57 @@
+-- Like and upvote counts are kept on the image by triggers, so album responses don't count them per image. Likes and
+-- upvotes are locked until the triggers are in place and the counts backfilled, so none is missed or counted twice.
+BEGIN;
+
+LOCK TABLE likes, upvotes IN SHARE ROW EXCLUSIVE MODE;
+
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS like_count   integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS upvote_count integer NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION count_image_likes() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE images SET like_count = like_count + 1 WHERE image_id = NEW.image_id;
+    ELSE
+        UPDATE images SET like_count = greatest(like_count - 1, 0) WHERE image_id = OLD.image_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION count_image_upvotes() RETURNS trigger AS
+$$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE images SET upvote_count = upvote_count + 1 WHERE image_id = NEW.image_id;
+    ELSE
+        UPDATE images SET upvote_count = greatest(upvote_count - 1, 0) WHERE image_id = OLD.image_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS likes_count_trigger ON likes;
+CREATE TRIGGER likes_count_trigger
+    AFTER INSERT OR DELETE
+    ON likes
+    FOR EACH ROW
+EXECUTE FUNCTION count_image_likes();
+
+DROP TRIGGER IF EXISTS upvotes_count_trigger ON upvotes;
+CREATE TRIGGER upvotes_count_trigger
+    AFTER INSERT OR DELETE
+    ON upvotes
+    FOR EACH ROW
+EXECUTE FUNCTION count_image_upvotes();
+
+UPDATE images i
+SET like_count   = (SELECT count(*) FROM likes l WHERE l.image_id = i.image_id),
+    upvote_count = (SELECT count(*) FROM upvotes up WHERE up.image_id = i.image_id);
+
+-- Album pages look up the images and guests of all their albums at once
+CREATE INDEX IF NOT EXISTS imagealbum_album_idx ON imagealbum (album_id);
+CREATE INDEX IF NOT EXISTS album_requests_album_idx ON album_requests (album_id);
+
+COMMIT;
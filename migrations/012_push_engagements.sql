@@ -0,0 +1,17 @@
+-- The engagement the push subscriber summarizes, written from the events themselves so pushes don't depend on the
+-- in-app inbox. Rows are only needed for the current summary window and are pruned as new ones arrive.
+CREATE TABLE IF NOT EXISTS push_engagements
+(
+    source_key   text        NOT NULL PRIMARY KEY,
+    recipient_id uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    actor_id     uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    type         text        NOT NULL,
+    album_id     uuid        NOT NULL REFERENCES albums (album_id) ON DELETE CASCADE,
+    image_id     uuid        NOT NULL,
+    subject_id   text        NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text)
+);
+
+CREATE INDEX IF NOT EXISTS push_engagements_summary_idx
+    ON push_engagements (recipient_id, album_id, type, created_at);
+CREATE INDEX IF NOT EXISTS push_engagements_subject_idx ON push_engagements (subject_id, type);
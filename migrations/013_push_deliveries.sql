@@ -0,0 +1,11 @@
+-- The members an album push already reached, so a fan-out that failed for some of them only retries those when the
+-- event is redelivered. Rows are only needed while the event can be redelivered and are pruned after a day.
+CREATE TABLE IF NOT EXISTS push_deliveries
+(
+    source_key   text        NOT NULL,
+    recipient_id uuid        NOT NULL REFERENCES users (user_id) ON DELETE CASCADE,
+    delivered_at timestamptz NOT NULL DEFAULT (now() AT TIME ZONE 'utc'::text),
+    PRIMARY KEY (source_key, recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS push_deliveries_delivered_idx ON push_deliveries (delivered_at);
@@ -0,0 +1,3 @@
+-- Signing out everywhere revokes every token issued before it, WebSockets and event streams are refused for them
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS sessions_revoked_at timestamptz;
@@ -0,0 +1,160 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is a rendered email with a plain text and an HTML body
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// SMTPConfig is the server emails are sent through. Username may be empty for servers without authentication, like a
+// local SMTP sink.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender sends emails through an SMTP server
+type SMTPSender struct {
+	config SMTPConfig
+
+	// tlsConfig replaces the STARTTLS configuration, tests set it to trust their own certificate
+	tlsConfig *tls.Config
+}
+
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	return &SMTPSender{config: config}
+}
+
+// Send delivers the message, net/smtp has no context support so the context only bounds the connection attempt
+func (sender *SMTPSender) Send(ctx context.Context, message Message) error {
+	body, err := encode(sender.config.From, message)
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(sender.config.Host, strconv.Itoa(sender.config.Port))
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, sender.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(sender.clientTLSConfig())
+		if err != nil {
+			return err
+		}
+	}
+
+	if sender.config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", sender.config.Username, sender.config.Password, sender.config.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(sender.config.From)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(message.To)
+	if err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(body)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// clientTLSConfig verifies the server certificate against the configured host
+func (sender *SMTPSender) clientTLSConfig() *tls.Config {
+	if sender.tlsConfig != nil {
+		return sender.tlsConfig
+	}
+
+	return &tls.Config{ServerName: sender.config.Host}
+}
+
+// encode builds the MIME message with the text and HTML bodies as alternatives
+func encode(from string, message Message) ([]byte, error) {
+	var buffer bytes.Buffer
+	var parts bytes.Buffer
+
+	writer := multipart.NewWriter(&parts)
+
+	for _, alternative := range []struct {
+		contentType string
+		body        string
+	}{{"text/plain; charset=UTF-8", message.Text}, {"text/html; charset=UTF-8", message.HTML}} {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {alternative.contentType}})
+		if err != nil {
+			return nil, err
+		}
+		_, err = part.Write([]byte(alternative.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(&buffer, "From: %v\r\n", from)
+	fmt.Fprintf(&buffer, "To: %v\r\n", message.To)
+	fmt.Fprintf(&buffer, "Subject: %v\r\n", mime.QEncoding.Encode("UTF-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %v\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&buffer, "Message-ID: <%v@lastweekend>\r\n", uuid.NewString())
+	fmt.Fprintf(&buffer, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buffer, "Content-Type: multipart/alternative; boundary=%v\r\n\r\n", writer.Boundary())
+	buffer.Write(parts.Bytes())
+
+	return buffer.Bytes(), nil
+}
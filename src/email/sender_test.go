@@ -0,0 +1,288 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sinkMessage is an email received by the sink
+type sinkMessage struct {
+	from string
+	to   []string
+	auth string
+	tls  bool
+	data []byte
+}
+
+// smtpSink is a minimal SMTP server that keeps the messages it receives. With a TLS config it offers STARTTLS and
+// refuses mail until the connection is upgraded.
+type smtpSink struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	messages  chan sinkMessage
+}
+
+func newSMTPSink(t *testing.T, tlsConfig *tls.Config) *smtpSink {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	sink := &smtpSink{listener: listener, tlsConfig: tlsConfig, messages: make(chan sinkMessage, 1)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+
+	return sink
+}
+
+func (sink *smtpSink) config() SMTPConfig {
+	host, port, _ := net.SplitHostPort(sink.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+
+	return SMTPConfig{Host: host, Port: portNumber, Username: "user", Password: "secret", From: "noreply@lastweekend.app"}
+}
+
+func (sink *smtpSink) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	var message sinkMessage
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 sink ready")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			if sink.tlsConfig != nil && !message.tls {
+				text.PrintfLine("250-sink\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			} else {
+				text.PrintfLine("250-sink\r\n250 AUTH PLAIN")
+			}
+		case command == "STARTTLS":
+			text.PrintfLine("220 ready to start TLS")
+
+			tlsConn := tls.Server(conn, sink.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			message.tls = true
+		case strings.HasPrefix(command, "AUTH PLAIN"):
+			message.auth = strings.TrimSpace(line[len("AUTH PLAIN"):])
+			text.PrintfLine("235 authenticated")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			if sink.tlsConfig != nil && !message.tls {
+				text.PrintfLine("530 must issue STARTTLS first")
+				continue
+			}
+			message.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			text.PrintfLine("250 ok")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.to = append(message.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			text.PrintfLine("250 ok")
+		case command == "DATA":
+			text.PrintfLine("354 end with .")
+
+			message.data, err = text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			text.PrintfLine("250 queued")
+			sink.messages <- message
+		case command == "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (sink *smtpSink) receive(t *testing.T) sinkMessage {
+	t.Helper()
+
+	select {
+	case message := <-sink.messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sink received no message")
+		return sinkMessage{}
+	}
+}
+
+// selfSignedTLS returns a server certificate for 127.0.0.1 and a client config that trusts it
+func selfSignedTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sink"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{ServerName: "127.0.0.1", RootCAs: roots}
+	return server, client
+}
+
+// readParts parses the received email and returns its headers and its parts by content type
+func readParts(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+
+	received, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(received.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type = %q, %v", received.Header.Get("Content-Type"), err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(received.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+
+		body, err := io.ReadAll(bufio.NewReader(part))
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(body)
+	}
+
+	return received.Header, parts
+}
+
+func TestSMTPSenderSend(t *testing.T) {
+	sink := newSMTPSink(t, nil)
+	sender := NewSMTPSender(sink.config())
+
+	message, err := RenderFriendRequest("ana@example.com", FriendRequest{
+		RecipientName: "Ana",
+		SenderName:    "Jesús",
+		Link:          "https://lastweekend.app/friends",
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+
+	err = sender.Send(context.Background(), message)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	received := sink.receive(t)
+	if received.from != "noreply@lastweekend.app" {
+		t.Errorf("from = %q", received.from)
+	}
+	if len(received.to) != 1 || received.to[0] != "ana@example.com" {
+		t.Errorf("to = %q", received.to)
+	}
+
+	credentials, err := base64.StdEncoding.DecodeString(received.auth)
+	if err != nil || string(credentials) != "\x00user\x00secret" {
+		t.Errorf("auth = %q, %v", credentials, err)
+	}
+
+	header, parts := readParts(t, received.data)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil || subject != "Jesús sent you a friend request" {
+		t.Errorf("subject = %q, %v", subject, err)
+	}
+	if !strings.Contains(parts["text/plain; charset=UTF-8"], "https://lastweekend.app/friends") {
+		t.Errorf("text part = %q", parts["text/plain; charset=UTF-8"])
+	}
+	if !strings.Contains(parts["text/html; charset=UTF-8"], `<a href="https://lastweekend.app/friends">`) {
+		t.Errorf("html part = %q", parts["text/html; charset=UTF-8"])
+	}
+}
+
+func TestSMTPSenderStartTLS(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	sink := newSMTPSink(t, serverTLS)
+
+	sender := NewSMTPSender(sink.config())
+	sender.tlsConfig = clientTLS
+
+	err := sender.Send(context.Background(), Message{To: "ana@example.com", Subject: "Hi", Text: "Hi", HTML: "<p>Hi</p>"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	received := sink.receive(t)
+	if !received.tls {
+		t.Error("the message was not sent over TLS")
+	}
+}
+
+func TestSMTPSenderDefaultTLSConfig(t *testing.T) {
+	sender := NewSMTPSender(SMTPConfig{Host: "smtp.example.com", Port: 587})
+
+	if serverName := sender.clientTLSConfig().ServerName; serverName != "smtp.example.com" {
+		t.Errorf("server name = %q, want the configured host", serverName)
+	}
+}
@@ -0,0 +1,143 @@
+package email
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// AlbumInvite is the email to a user invited to an album
+type AlbumInvite struct {
+	RecipientName string
+	OwnerName     string
+	AlbumName     string
+	Link          string
+}
+
+// FriendRequest is the email to a user who was sent a friend request
+type FriendRequest struct {
+	RecipientName string
+	SenderName    string
+	Link          string
+}
+
+// Digest is the weekly email of the activity in the recipient's albums
+type Digest struct {
+	RecipientName string
+	Albums        []DigestAlbum
+	Unread        int
+	Link          string
+}
+
+// DigestAlbum is the activity in one album, the engagement counts are on the recipient's own photos
+type DigestAlbum struct {
+	AlbumName string
+	NewPhotos int
+	Likes     int
+	Upvotes   int
+	Comments  int
+	Link      string
+}
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+func newTemplate(subject string, text string, html string) emailTemplate {
+	return emailTemplate{
+		subject: texttemplate.Must(texttemplate.New("subject").Parse(subject)),
+		text:    texttemplate.Must(texttemplate.New("text").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New("html").Parse(html)),
+	}
+}
+
+var (
+	albumInviteTemplate = newTemplate(
+		`{{.OwnerName}} invited you to {{.AlbumName}}`,
+		`Hi {{.RecipientName}},
+
+{{.OwnerName}} invited you to share photos in {{.AlbumName}} on Last Weekend.
+
+Accept the invite: {{.Link}}
+`,
+		`<p>Hi {{.RecipientName}},</p>
+<p>{{.OwnerName}} invited you to share photos in <strong>{{.AlbumName}}</strong> on Last Weekend.</p>
+<p><a href="{{.Link}}">Accept the invite</a></p>
+`)
+
+	friendRequestTemplate = newTemplate(
+		`{{.SenderName}} sent you a friend request`,
+		`Hi {{.RecipientName}},
+
+{{.SenderName}} wants to be friends on Last Weekend.
+
+See the request: {{.Link}}
+`,
+		`<p>Hi {{.RecipientName}},</p>
+<p>{{.SenderName}} wants to be friends on Last Weekend.</p>
+<p><a href="{{.Link}}">See the request</a></p>
+`)
+
+	digestTemplate = newTemplate(
+		`Your week on Last Weekend`,
+		`Hi {{.RecipientName}},
+
+Here is what happened in your albums this week:
+{{range .Albums}}
+{{.AlbumName}}{{if .NewPhotos}}
+  {{.NewPhotos}} new photos{{end}}{{if .Likes}}
+  {{.Likes}} likes on your photos{{end}}{{if .Upvotes}}
+  {{.Upvotes}} upvotes on your photos{{end}}{{if .Comments}}
+  {{.Comments}} comments on your photos{{end}}
+  {{.Link}}
+{{end}}{{if .Unread}}
+You have {{.Unread}} unread notifications.
+{{end}}
+Open Last Weekend: {{.Link}}
+`,
+		`<p>Hi {{.RecipientName}},</p>
+<p>Here is what happened in your albums this week:</p>
+{{range .Albums}}<h3><a href="{{.Link}}">{{.AlbumName}}</a></h3>
+<ul>{{if .NewPhotos}}
+<li>{{.NewPhotos}} new photos</li>{{end}}{{if .Likes}}
+<li>{{.Likes}} likes on your photos</li>{{end}}{{if .Upvotes}}
+<li>{{.Upvotes}} upvotes on your photos</li>{{end}}{{if .Comments}}
+<li>{{.Comments}} comments on your photos</li>{{end}}
+</ul>
+{{end}}{{if .Unread}}<p>You have {{.Unread}} unread notifications.</p>
+{{end}}<p><a href="{{.Link}}">Open Last Weekend</a></p>
+`)
+)
+
+func RenderAlbumInvite(to string, invite AlbumInvite) (Message, error) {
+	return albumInviteTemplate.render(to, invite)
+}
+
+func RenderFriendRequest(to string, request FriendRequest) (Message, error) {
+	return friendRequestTemplate.render(to, request)
+}
+
+func RenderDigest(to string, digest Digest) (Message, error) {
+	return digestTemplate.render(to, digest)
+}
+
+func (template emailTemplate) render(to string, data interface{}) (Message, error) {
+	var subject, text, html bytes.Buffer
+
+	err := template.subject.Execute(&subject, data)
+	if err != nil {
+		return Message{}, err
+	}
+	err = template.text.Execute(&text, data)
+	if err != nil {
+		return Message{}, err
+	}
+	err = template.html.Execute(&html, data)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
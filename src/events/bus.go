@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Handler reacts to a single event, returning an error if the event should be redelivered
+type Handler func(ctx context.Context, message Message) error
+
+type Publisher interface {
+	Publish(ctx context.Context, message Message) error
+}
+
+type Subscriber interface {
+	// Subscribe registers handler for the given event types, or for every event when no type is given
+	Subscribe(name string, handler Handler, types ...Type)
+}
+
+type Bus interface {
+	Publisher
+	Subscriber
+}
+
+type subscription struct {
+	name    string
+	handler Handler
+	types   map[Type]bool
+}
+
+// MemoryBus delivers events to its subscribers synchronously within the process
+type MemoryBus struct {
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (bus *MemoryBus) Subscribe(name string, handler Handler, types ...Type) {
+	sub := subscription{name: name, handler: handler}
+
+	if len(types) > 0 {
+		sub.types = make(map[Type]bool, len(types))
+		for _, eventType := range types {
+			sub.types[eventType] = true
+		}
+	}
+
+	bus.mu.Lock()
+	bus.subscriptions = append(bus.subscriptions, sub)
+	bus.mu.Unlock()
+}
+
+// Publish runs every subscriber of the event, a failing subscriber does not stop the others from running
+func (bus *MemoryBus) Publish(ctx context.Context, message Message) error {
+	var errs []error
+
+	bus.mu.RLock()
+	subscriptions := bus.subscriptions
+	bus.mu.RUnlock()
+
+	for _, sub := range subscriptions {
+		if sub.types != nil && !sub.types[message.Event.EventType()] {
+			continue
+		}
+
+		err := sub.handler(ctx, message)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v subscriber: %w", sub.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
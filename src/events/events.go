@@ -0,0 +1,221 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	m "last_weekend_services/src/models"
+	"time"
+)
+
+type Type string
+
+const (
+	ImageLikedType            Type = "image.liked"
+	ImageUnlikedType          Type = "image.unliked"
+	ImageUpvotedType          Type = "image.upvoted"
+	ImageUpvoteRemovedType    Type = "image.upvote_removed"
+	CommentAddedType          Type = "comment.added"
+	CommentEditedType         Type = "comment.edited"
+	CommentDeletedType        Type = "comment.deleted"
+	FriendRequestSentType     Type = "friend_request.sent"
+	FriendRequestAcceptedType Type = "friend_request.accepted"
+	AlbumInviteSentType       Type = "album_invite.sent"
+	AlbumInviteAcceptedType   Type = "album_invite.accepted"
+	AlbumInviteDeniedType     Type = "album_invite.denied"
+	AlbumAccessChangedType    Type = "album.access_changed"
+	NotificationsReadType     Type = "notifications.read"
+	ImageUploadedType         Type = "image.uploaded"
+	AlbumRevealedType         Type = "album.revealed"
+)
+
+// Event is a change to the domain that other parts of the service can react to
+type Event interface {
+	EventType() Type
+}
+
+type ImageLiked struct {
+	Notification m.EngagementNotification `json:"notification"`
+}
+
+type ImageUnliked struct {
+	Notification m.EngagementNotification `json:"notification"`
+}
+
+type ImageUpvoted struct {
+	Notification m.EngagementNotification `json:"notification"`
+}
+
+type ImageUpvoteRemoved struct {
+	Notification m.EngagementNotification `json:"notification"`
+}
+
+type CommentAdded struct {
+	Comment m.Comment `json:"comment"`
+}
+
+type CommentEdited struct {
+	Comment m.Comment `json:"comment"`
+}
+
+type CommentDeleted struct {
+	Comment m.Comment `json:"comment"`
+}
+
+type FriendRequestSent struct {
+	Request m.FriendRequestNotification `json:"request"`
+}
+
+type FriendRequestAccepted struct {
+	Request m.FriendRequestNotification `json:"request"`
+}
+
+type AlbumInviteSent struct {
+	Request m.AlbumRequestNotification `json:"request"`
+}
+
+// AlbumInviteAccepted is sent to the guests that had already accepted an invite to the album
+type AlbumInviteAccepted struct {
+	Request  m.AlbumRequestNotification `json:"request"`
+	GuestIDs []string                   `json:"guest_ids"`
+}
+
+// AlbumInviteDenied is sent to the guests that had already accepted an invite to the album
+type AlbumInviteDenied struct {
+	Request  m.AlbumRequestNotification `json:"request"`
+	GuestIDs []string                   `json:"guest_ids"`
+}
+
+// AlbumAccessChanged is sent when users may have lost access to an album, e.g. a guest left or the visibility changed
+type AlbumAccessChanged struct {
+	AlbumID string `json:"album_id"`
+}
+
+// NotificationsRead syncs the read state to the user's other devices
+type NotificationsRead struct {
+	UserID string                  `json:"user_id"`
+	Filter m.MarkReadRequest       `json:"filter"`
+	State  m.NotificationReadState `json:"state"`
+}
+
+// ImageUploaded is sent when a photo is added to an album, MemberIDs are the album's members at the time
+type ImageUploaded struct {
+	Image     m.Image  `json:"image"`
+	AlbumID   string   `json:"album_id"`
+	AlbumName string   `json:"album_name"`
+	MemberIDs []string `json:"member_ids"`
+}
+
+// AlbumRevealed is sent once the reveal date of an album has passed
+type AlbumRevealed struct {
+	AlbumID   string   `json:"album_id"`
+	AlbumName string   `json:"album_name"`
+	MemberIDs []string `json:"member_ids"`
+}
+
+func (ImageLiked) EventType() Type            { return ImageLikedType }
+func (ImageUnliked) EventType() Type          { return ImageUnlikedType }
+func (ImageUpvoted) EventType() Type          { return ImageUpvotedType }
+func (ImageUpvoteRemoved) EventType() Type    { return ImageUpvoteRemovedType }
+func (CommentAdded) EventType() Type          { return CommentAddedType }
+func (CommentEdited) EventType() Type         { return CommentEditedType }
+func (CommentDeleted) EventType() Type        { return CommentDeletedType }
+func (FriendRequestSent) EventType() Type     { return FriendRequestSentType }
+func (FriendRequestAccepted) EventType() Type { return FriendRequestAcceptedType }
+func (AlbumInviteSent) EventType() Type       { return AlbumInviteSentType }
+func (AlbumInviteAccepted) EventType() Type   { return AlbumInviteAcceptedType }
+func (AlbumInviteDenied) EventType() Type     { return AlbumInviteDeniedType }
+func (AlbumAccessChanged) EventType() Type    { return AlbumAccessChangedType }
+func (NotificationsRead) EventType() Type     { return NotificationsReadType }
+func (ImageUploaded) EventType() Type         { return ImageUploadedType }
+func (AlbumRevealed) EventType() Type         { return AlbumRevealedType }
+
+// decoders maps every event type to a function that decodes its data, a new event has to be added here before it can
+// cross a process boundary
+var decoders = map[Type]func(data []byte) (Event, error){
+	ImageLikedType:            decodeInto[ImageLiked],
+	ImageUnlikedType:          decodeInto[ImageUnliked],
+	ImageUpvotedType:          decodeInto[ImageUpvoted],
+	ImageUpvoteRemovedType:    decodeInto[ImageUpvoteRemoved],
+	CommentAddedType:          decodeInto[CommentAdded],
+	CommentEditedType:         decodeInto[CommentEdited],
+	CommentDeletedType:        decodeInto[CommentDeleted],
+	FriendRequestSentType:     decodeInto[FriendRequestSent],
+	FriendRequestAcceptedType: decodeInto[FriendRequestAccepted],
+	AlbumInviteSentType:       decodeInto[AlbumInviteSent],
+	AlbumInviteAcceptedType:   decodeInto[AlbumInviteAccepted],
+	AlbumInviteDeniedType:     decodeInto[AlbumInviteDenied],
+	AlbumAccessChangedType:    decodeInto[AlbumAccessChanged],
+	NotificationsReadType:     decodeInto[NotificationsRead],
+	ImageUploadedType:         decodeInto[ImageUploaded],
+	AlbumRevealedType:         decodeInto[AlbumRevealed],
+}
+
+func decodeInto[T Event](data []byte) (Event, error) {
+	var event T
+
+	err := json.Unmarshal(data, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// Message is an event together with its ID. The ID is stable across redeliveries so subscribers can use it to ignore
+// an event they have already handled.
+type Message struct {
+	ID         string
+	OccurredAt time.Time
+	Event      Event
+}
+
+type envelope struct {
+	ID         string          `json:"id"`
+	Type       Type            `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+func NewMessage(id string, event Event) Message {
+	return Message{
+		ID:         id,
+		OccurredAt: time.Now().UTC(),
+		Event:      event,
+	}
+}
+
+// Encode serializes the message so it can be stored in the outbox or sent through Redis
+func Encode(message Message) ([]byte, error) {
+	data, err := json.Marshal(message.Event)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		ID:         message.ID,
+		Type:       message.Event.EventType(),
+		OccurredAt: message.OccurredAt,
+		Data:       data,
+	})
+}
+
+func Decode(encoded []byte) (Message, error) {
+	var env envelope
+
+	err := json.Unmarshal(encoded, &env)
+	if err != nil {
+		return Message{}, err
+	}
+
+	decode, ok := decoders[env.Type]
+	if !ok {
+		return Message{}, fmt.Errorf("unknown event type: %v", env.Type)
+	}
+
+	event, err := decode(env.Data)
+	if err != nil {
+		return Message{}, fmt.Errorf("decoding %v: %w", env.Type, err)
+	}
+
+	return Message{ID: env.ID, OccurredAt: env.OccurredAt, Event: event}, nil
+}
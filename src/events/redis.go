@@ -0,0 +1,306 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	redisStreamKey     = "events:stream"
+	redisDeadLetterKey = "events:dead"
+	redisConsumerGroup = "subscribers"
+	redisEventField    = "event"
+	redisHandledPrefix = "events:handled:"
+	redisHandledTTL    = 24 * time.Hour
+	redisReadTimeout   = 5 * time.Second
+	redisReadCount     = 10
+
+	// An event that is not acknowledged within redisRedeliverAfter, because a subscriber failed or the instance
+	// handling it died, is delivered again. After redisMaxDeliveries it is moved to the dead letter stream.
+	redisRedeliverAfter = time.Minute
+	redisMaxDeliveries  = 5
+	redisDeadLetterLen  = 10000
+)
+
+// RedisBus queues events in a Redis stream shared by every instance of the service. The instances read the stream as
+// one consumer group, so each event is handed to the subscribers registered on one instance, and subscribers have to
+// be registered the same way on every instance. An event is acknowledged once every subscriber handled it, until then
+// it is redelivered.
+type RedisBus struct {
+	rdb      *redis.Client
+	local    *MemoryBus
+	consumer string
+}
+
+func NewRedisBus(rdb *redis.Client) *RedisBus {
+	hostname, _ := os.Hostname()
+
+	return &RedisBus{rdb: rdb, local: NewMemoryBus(), consumer: hostname + "-" + uuid.NewString()}
+}
+
+func (bus *RedisBus) Subscribe(name string, handler Handler, types ...Type) {
+	bus.local.Subscribe(name, handler, types...)
+}
+
+func (bus *RedisBus) Publish(ctx context.Context, message Message) error {
+	encoded, err := Encode(message)
+	if err != nil {
+		return err
+	}
+
+	return bus.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisStreamKey,
+		Values: map[string]interface{}{redisEventField: encoded},
+	}).Err()
+}
+
+// Run takes events off the stream and delivers them to the local subscribers until the context is cancelled. Events
+// left unacknowledged by any instance are claimed and delivered again.
+func (bus *RedisBus) Run(ctx context.Context) {
+	var lastReclaim time.Time
+
+	for ctx.Err() == nil {
+		err := bus.createGroup(ctx)
+		if err == nil {
+			break
+		}
+		log.Printf("Event bus: unable to create the consumer group: %v", err)
+		sleep(ctx, time.Second)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(lastReclaim) >= redisRedeliverAfter/2 {
+			err := bus.reclaim(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Event bus: unable to reclaim pending events: %v", err)
+			}
+			lastReclaim = time.Now()
+		}
+
+		err := bus.read(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Event bus: %v", err)
+			sleep(ctx, time.Second)
+		}
+	}
+}
+
+// read waits up to redisReadTimeout for new events and delivers them
+func (bus *RedisBus) read(ctx context.Context) error {
+	streams, err := bus.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    redisConsumerGroup,
+		Consumer: bus.consumer,
+		Streams:  []string{redisStreamKey, ">"},
+		Count:    redisReadCount,
+		Block:    redisReadTimeout,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			bus.deliver(ctx, entry)
+		}
+	}
+
+	return nil
+}
+
+func (bus *RedisBus) createGroup(ctx context.Context) error {
+	err := bus.rdb.XGroupCreateMkStream(ctx, redisStreamKey, redisConsumerGroup, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// deliver hands the entry to the local subscribers and acknowledges it if they all succeed. A failed entry stays
+// pending and is redelivered by reclaim, subscribers that already handled it skip it through Idempotent.
+func (bus *RedisBus) deliver(ctx context.Context, entry redis.XMessage) {
+	encoded, ok := entry.Values[redisEventField].(string)
+	if !ok {
+		bus.deadLetter(ctx, entry, "the entry has no event")
+		return
+	}
+
+	message, err := Decode([]byte(encoded))
+	if err != nil {
+		bus.deadLetter(ctx, entry, fmt.Sprintf("undecodable event: %v", err))
+		return
+	}
+
+	err = bus.local.Publish(ctx, message)
+	if err != nil {
+		log.Printf("Event bus: %v failed, it will be redelivered: %v", message.ID, err)
+		return
+	}
+
+	err = bus.ack(ctx, entry.ID)
+	if err != nil {
+		log.Printf("Event bus: unable to acknowledge %v: %v", message.ID, err)
+	}
+}
+
+// reclaim claims the entries that were not acknowledged in time and delivers them again, or moves them to the dead
+// letter stream once they were delivered redisMaxDeliveries times.
+func (bus *RedisBus) reclaim(ctx context.Context) error {
+	pending, err := bus.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: redisStreamKey,
+		Group:  redisConsumerGroup,
+		Idle:   redisRedeliverAfter,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range pending {
+		claimed, err := bus.rdb.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   redisStreamKey,
+			Group:    redisConsumerGroup,
+			Consumer: bus.consumer,
+			MinIdle:  redisRedeliverAfter,
+			Messages: []string{entry.ID},
+		}).Result()
+		if err != nil {
+			return err
+		}
+		// Another instance claimed it first
+		if len(claimed) == 0 {
+			continue
+		}
+
+		if entry.RetryCount >= redisMaxDeliveries {
+			bus.deadLetter(ctx, claimed[0], fmt.Sprintf("not handled after %d deliveries", entry.RetryCount))
+			continue
+		}
+
+		bus.deliver(ctx, claimed[0])
+	}
+
+	return nil
+}
+
+// deadLetter moves the entry to the dead letter stream, where it stays until it is requeued
+func (bus *RedisBus) deadLetter(ctx context.Context, entry redis.XMessage, reason string) {
+	log.Printf("Event bus: moving %v to the dead letter stream: %v", entry.ID, reason)
+
+	values := map[string]interface{}{"stream_id": entry.ID, "reason": reason}
+	if encoded, ok := entry.Values[redisEventField].(string); ok {
+		values[redisEventField] = encoded
+	}
+
+	err := bus.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisDeadLetterKey,
+		MaxLen: redisDeadLetterLen,
+		Approx: true,
+		Values: values,
+	}).Err()
+	if err != nil {
+		log.Printf("Event bus: unable to dead letter %v, it stays pending: %v", entry.ID, err)
+		return
+	}
+
+	err = bus.ack(ctx, entry.ID)
+	if err != nil {
+		log.Printf("Event bus: unable to acknowledge %v: %v", entry.ID, err)
+	}
+}
+
+// ack acknowledges the entry and removes it, so the stream only holds events that are not handled yet
+func (bus *RedisBus) ack(ctx context.Context, id string) error {
+	_, err := bus.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, redisStreamKey, redisConsumerGroup, id)
+		pipe.XDel(ctx, redisStreamKey, id)
+		return nil
+	})
+	return err
+}
+
+// RequeueDeadLetters puts up to count dead lettered events back on the stream, oldest first, and returns how many were
+// requeued. Entries without an event cannot be delivered and are dropped.
+func RequeueDeadLetters(ctx context.Context, rdb *redis.Client, count int64) (int, error) {
+	entries, err := rdb.XRangeN(ctx, redisDeadLetterKey, "-", "+", count).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, entry := range entries {
+		encoded, ok := entry.Values[redisEventField].(string)
+
+		_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if ok {
+				pipe.XAdd(ctx, &redis.XAddArgs{
+					Stream: redisStreamKey,
+					Values: map[string]interface{}{redisEventField: encoded},
+				})
+			}
+			pipe.XDel(ctx, redisDeadLetterKey, entry.ID)
+			return nil
+		})
+		if err != nil {
+			return requeued, err
+		}
+		if ok {
+			requeued++
+		}
+	}
+
+	return requeued, nil
+}
+
+func sleep(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
+
+// Idempotent wraps handler so it runs at most once per message ID. The marker is only written once the handler
+// succeeds, so a failed attempt can be retried when the message is redelivered.
+func Idempotent(rdb *redis.Client, name string, handler Handler) Handler {
+	return func(ctx context.Context, message Message) error {
+		handledKey := redisHandledPrefix + name + ":" + message.ID
+
+		handled, err := rdb.Exists(ctx, handledKey).Result()
+		if err != nil {
+			return err
+		}
+		if handled > 0 {
+			return nil
+		}
+
+		err = handler(ctx, message)
+		if err != nil {
+			return err
+		}
+
+		err = rdb.Set(ctx, handledKey, 1, redisHandledTTL).Err()
+		if err != nil {
+			log.Printf("Unable to mark event %v as handled by %v: %v", message.ID, name, err)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,306 @@
+package events
+
+import (
+	"context"
+	"errors"
+	m "last_weekend_services/src/models"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestBus returns a bus on a fresh server and a function that moves the clock of the server past the redelivery
+// timeout
+func newTestBus(t *testing.T) (*RedisBus, func()) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	now := time.Now()
+	server.SetTime(now)
+
+	advance := func() {
+		now = now.Add(redisRedeliverAfter + time.Second)
+		server.SetTime(now)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	bus := NewRedisBus(rdb)
+	err := bus.createGroup(context.Background())
+	if err != nil {
+		t.Fatalf("create group: %v", err)
+	}
+
+	return bus, advance
+}
+
+func testMessage(id string) Message {
+	return NewMessage(id, FriendRequestSent{Request: m.FriendRequestNotification{ReceiverID: "receiver", FirstName: "Ana"}})
+}
+
+func pendingCount(t *testing.T, bus *RedisBus) int64 {
+	t.Helper()
+
+	pending, err := bus.rdb.XPending(context.Background(), redisStreamKey, redisConsumerGroup).Result()
+	if err != nil {
+		t.Fatalf("pending: %v", err)
+	}
+	return pending.Count
+}
+
+func streamLength(t *testing.T, bus *RedisBus, stream string) int64 {
+	t.Helper()
+
+	length, err := bus.rdb.XLen(context.Background(), stream).Result()
+	if err != nil {
+		t.Fatalf("length of %v: %v", stream, err)
+	}
+	return length
+}
+
+func TestRedisBusAcknowledgesHandledEvents(t *testing.T) {
+	ctx := context.Background()
+	bus, _ := newTestBus(t)
+
+	var handled []string
+	bus.Subscribe("test", func(ctx context.Context, message Message) error {
+		handled = append(handled, message.ID)
+		return nil
+	})
+
+	err := bus.Publish(ctx, testMessage("event-1"))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if len(handled) != 1 || handled[0] != "event-1" {
+		t.Fatalf("handled = %v, want [event-1]", handled)
+	}
+	if count := pendingCount(t, bus); count != 0 {
+		t.Errorf("%d events pending, want 0", count)
+	}
+	if length := streamLength(t, bus, redisStreamKey); length != 0 {
+		t.Errorf("%d events left on the stream, want 0", length)
+	}
+}
+
+func TestRedisBusRedeliversFailedEvents(t *testing.T) {
+	ctx := context.Background()
+	bus, advance := newTestBus(t)
+
+	attempts := 0
+	bus.Subscribe("test", func(ctx context.Context, message Message) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	err := bus.Publish(ctx, testMessage("event-1"))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if count := pendingCount(t, bus); count != 1 {
+		t.Fatalf("%d events pending after the failure, want 1", count)
+	}
+
+	// Not idle long enough yet
+	err = bus.reclaim(ctx)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("the event was redelivered before the timeout")
+	}
+
+	advance()
+	err = bus.reclaim(ctx)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if count := pendingCount(t, bus); count != 0 {
+		t.Errorf("%d events pending after the retry, want 0", count)
+	}
+}
+
+func TestRedisBusRedeliversEventsOfLostInstances(t *testing.T) {
+	ctx := context.Background()
+	bus, advance := newTestBus(t)
+
+	var handled []string
+	bus.Subscribe("test", func(ctx context.Context, message Message) error {
+		handled = append(handled, message.ID)
+		return nil
+	})
+
+	err := bus.Publish(ctx, testMessage("event-1"))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	// Another instance takes the event and crashes before acknowledging it
+	_, err = bus.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    redisConsumerGroup,
+		Consumer: "crashed",
+		Streams:  []string{redisStreamKey, ">"},
+		Count:    1,
+	}).Result()
+	if err != nil {
+		t.Fatalf("read by the crashed instance: %v", err)
+	}
+
+	advance()
+	err = bus.reclaim(ctx)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+
+	if len(handled) != 1 || handled[0] != "event-1" {
+		t.Fatalf("handled = %v, want [event-1]", handled)
+	}
+	if count := pendingCount(t, bus); count != 0 {
+		t.Errorf("%d events pending, want 0", count)
+	}
+}
+
+func TestRedisBusOnlyRetriesFailedSubscribers(t *testing.T) {
+	ctx := context.Background()
+	bus, advance := newTestBus(t)
+
+	succeeded, failing := 0, 0
+	bus.Subscribe("succeeds", Idempotent(bus.rdb, "succeeds", func(ctx context.Context, message Message) error {
+		succeeded++
+		return nil
+	}))
+	bus.Subscribe("fails once", Idempotent(bus.rdb, "fails once", func(ctx context.Context, message Message) error {
+		failing++
+		if failing == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}))
+
+	err := bus.Publish(ctx, testMessage("event-1"))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	advance()
+	err = bus.reclaim(ctx)
+	if err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+
+	if succeeded != 1 {
+		t.Errorf("the succeeding subscriber ran %d times, want 1", succeeded)
+	}
+	if failing != 2 {
+		t.Errorf("the failing subscriber ran %d times, want 2", failing)
+	}
+}
+
+func TestRedisBusDeadLettersEvents(t *testing.T) {
+	ctx := context.Background()
+	bus, advance := newTestBus(t)
+
+	attempts := 0
+	bus.Subscribe("test", func(ctx context.Context, message Message) error {
+		attempts++
+		return errors.New("unavailable")
+	})
+
+	err := bus.Publish(ctx, testMessage("event-1"))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	for i := 0; i < redisMaxDeliveries; i++ {
+		advance()
+		err = bus.reclaim(ctx)
+		if err != nil {
+			t.Fatalf("reclaim: %v", err)
+		}
+	}
+
+	if attempts != redisMaxDeliveries {
+		t.Errorf("attempts = %d, want %d", attempts, redisMaxDeliveries)
+	}
+	if count := pendingCount(t, bus); count != 0 {
+		t.Errorf("%d events pending, want 0", count)
+	}
+	if length := streamLength(t, bus, redisDeadLetterKey); length != 1 {
+		t.Fatalf("%d dead letters, want 1", length)
+	}
+
+	requeued, err := RequeueDeadLetters(ctx, bus.rdb, 10)
+	if err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if requeued != 1 {
+		t.Errorf("requeued = %d, want 1", requeued)
+	}
+	if length := streamLength(t, bus, redisDeadLetterKey); length != 0 {
+		t.Errorf("%d dead letters after the requeue, want 0", length)
+	}
+
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if attempts != redisMaxDeliveries+1 {
+		t.Errorf("the requeued event was not delivered")
+	}
+}
+
+func TestRedisBusDeadLettersUndecodableEvents(t *testing.T) {
+	ctx := context.Background()
+	bus, _ := newTestBus(t)
+
+	bus.Subscribe("test", func(ctx context.Context, message Message) error {
+		t.Errorf("the undecodable event was delivered")
+		return nil
+	})
+
+	err := bus.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisStreamKey,
+		Values: map[string]interface{}{redisEventField: `{"type":"unknown"}`},
+	}).Err()
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	err = bus.read(ctx)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if count := pendingCount(t, bus); count != 0 {
+		t.Errorf("%d events pending, want 0", count)
+	}
+	if length := streamLength(t, bus, redisDeadLetterKey); length != 1 {
+		t.Errorf("%d dead letters, want 1", length)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"last_weekend_services/src/events"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AdminEndpointHandler serves the operational endpoints, the routes are wrapped with middleware.RequireScope
+func AdminEndpointHandler(ctx context.Context, rdb *redis.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			switch r.URL.Path {
+			case "/admin/connections":
+				GETConnectionStats(ctx, w, rdb)
+			case "/admin/connections/user":
+				GETUserConnections(ctx, w, r, rdb)
+			}
+		case http.MethodPost:
+			switch r.URL.Path {
+			case "/admin/events/dead-letters/requeue":
+				POSTRequeueDeadLetters(ctx, w, r, rdb)
+			}
+		case http.MethodDelete:
+			switch r.URL.Path {
+			case "/admin/connections/user":
+				DELETEUserConnections(ctx, w, r, rdb)
+			}
+		}
+	})
+}
+
+func GETConnectionStats(ctx context.Context, w http.ResponseWriter, rdb *redis.Client) {
+	stats, err := QueryConnectionStats(ctx, rdb)
+	if err != nil {
+		log.Printf("Unable to query connection stats: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query connection stats")
+		return
+	}
+
+	writeAdminJSON(w, stats)
+}
+
+func GETUserConnections(ctx context.Context, w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	connections, err := QueryUserConnections(ctx, rdb, userID)
+	if err != nil {
+		log.Printf("Unable to query user connections: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query user connections")
+		return
+	}
+
+	writeAdminJSON(w, connections)
+}
+
+// DELETEUserConnections closes every connection of the user. Nodes act on it asynchronously, so the response only
+// confirms that the request was sent.
+func DELETEUserConnections(ctx context.Context, w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "disconnected by an administrator"
+	}
+
+	err := DisconnectUser(ctx, rdb, userID, reason)
+	if err != nil {
+		log.Printf("Unable to disconnect user: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to disconnect user")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusAccepted, "User is being disconnected")
+}
+
+// POSTRequeueDeadLetters puts up to count dead lettered events back on the event bus, once whatever made their
+// subscribers fail is fixed
+func POSTRequeueDeadLetters(ctx context.Context, w http.ResponseWriter, r *http.Request, rdb *redis.Client) {
+	count := int64(100)
+
+	if countParam := r.URL.Query().Get("count"); countParam != "" {
+		parsedCount, err := strconv.ParseInt(countParam, 10, 64)
+		if err != nil || parsedCount < 1 {
+			WriteResponseWithCode(w, http.StatusBadRequest, "count has to be a positive number")
+			return
+		}
+		count = parsedCount
+	}
+
+	requeued, err := events.RequeueDeadLetters(ctx, rdb, count)
+	if err != nil {
+		log.Printf("Unable to requeue dead letters: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to requeue dead letters")
+		return
+	}
+
+	writeAdminJSON(w, map[string]int{"requeued": requeued})
+}
+
+func writeAdminJSON(w http.ResponseWriter, response interface{}) {
+	responseBytes, err := json.MarshalIndent(response, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal response: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to marshal response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(responseBytes)
+	if err != nil {
+		log.Printf("Failed to Write: %v", err)
+	}
+}
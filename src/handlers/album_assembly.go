@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	m "last_weekend_services/src/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// albumImagesQuery returns the images of a set of albums newest first, with the viewer's engagement. The counts are
+// kept on the image by triggers.
+const albumImagesQuery = `WITH viewer AS (SELECT user_id FROM users WHERE auth_zero_id = $2)
+							SELECT ia.album_id, i.image_id, i.image_owner, u.first_name, u.last_name, i.caption,
+								i.upload_type, i.like_count, i.upvote_count,
+								EXISTS (SELECT 1 FROM likes l WHERE l.image_id = i.image_id AND l.user_id = (SELECT user_id FROM viewer)),
+								EXISTS (SELECT 1 FROM upvotes up WHERE up.image_id = i.image_id AND up.user_id = (SELECT user_id FROM viewer)),
+								i.created_at
+							FROM imagealbum ia
+							JOIN images i ON i.image_id = ia.image_id
+							JOIN users u ON u.user_id = i.image_owner
+							WHERE ia.album_id = ANY($1::uuid[])
+							AND i.deleted_at IS NULL
+							ORDER BY i.created_at DESC, i.image_id DESC`
+
+const albumGuestsQuery = `SELECT ar.album_id, ar.invited_id, u.first_name, u.last_name, ar.status
+							FROM album_requests ar
+							JOIN users u ON u.user_id = ar.invited_id
+							WHERE ar.album_id = ANY($1::uuid[])`
+
+// assembleAlbums fills in the images, guests and phase of a page of albums. The images and guests of all albums are
+// fetched in one batch, so the number of queries does not grow with the page. Guests are only looked up when
+// withGuests is set.
+func assembleAlbums(ctx context.Context, connPool *m.PGPool, albums []m.Album, uid string, withGuests bool) error {
+	if len(albums) == 0 {
+		return nil
+	}
+
+	albumIDs := make([]string, len(albums))
+	albumIndex := make(map[string]int, len(albums))
+	for i := range albums {
+		albumIDs[i] = albums[i].AlbumID
+		albumIndex[albums[i].AlbumID] = i
+		albums[i].Images = []m.Image{}
+		albums[i].PhaseCalculation()
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(albumImagesQuery, albumIDs, uid)
+	if withGuests {
+		batch.Queue(albumGuestsQuery, albumIDs)
+	}
+	batchResults := connPool.Pool.SendBatch(ctx, batch)
+	defer batchResults.Close()
+
+	imageRows, err := batchResults.Query()
+	if err != nil {
+		return err
+	}
+	for imageRows.Next() {
+		var albumID string
+		var image m.Image
+
+		err = imageRows.Scan(&albumID, &image.ID, &image.ImageOwner, &image.FirstName, &image.LastName, &image.Caption,
+			&image.UploadType, &image.Likes, &image.Upvotes, &image.UserLiked, &image.UserUpvoted, &image.CreatedAt)
+		if err != nil {
+			imageRows.Close()
+			return err
+		}
+		// Clients read the upload time from captured_at
+		image.CapturedAt = image.CreatedAt
+
+		album := &albums[albumIndex[albumID]]
+		album.Images = append(album.Images, image)
+	}
+	imageRows.Close()
+	if imageRows.Err() != nil {
+		return imageRows.Err()
+	}
+
+	if !withGuests {
+		return nil
+	}
+
+	guestRows, err := batchResults.Query()
+	if err != nil {
+		return err
+	}
+	defer guestRows.Close()
+
+	for guestRows.Next() {
+		var albumID string
+		var guest m.Guest
+
+		err = guestRows.Scan(&albumID, &guest.ID, &guest.FirstName, &guest.LastName, &guest.Status)
+		if err != nil {
+			return err
+		}
+
+		album := &albums[albumIndex[albumID]]
+		album.InviteList = append(album.InviteList, guest)
+	}
+
+	return guestRows.Err()
+}
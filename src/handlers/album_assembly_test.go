@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	m "last_weekend_services/src/models"
+	"strconv"
+	"testing"
+)
+
+// seedAlbums inserts albums of ownerID with images, some of them liked by the guests, and invites the guests to all of
+// them. It returns the albums without their images and guests, as the album queries scan them.
+func seedAlbums(tb testing.TB, connPool *m.PGPool, ownerID string, guestIDs []string, albumCount int, imagesPerAlbum int) []m.Album {
+	tb.Helper()
+	ctx := context.Background()
+
+	var albums []m.Album
+	for a := 0; a < albumCount; a++ {
+		var album m.Album
+
+		err := connPool.Pool.QueryRow(ctx, `WITH cover AS (INSERT INTO images (image_owner, caption, upload_type)
+							VALUES ($1, $2, 'album_cover') RETURNING image_id)
+						INSERT INTO albums (album_name, album_owner, album_cover_id, revealed_at, visibility)
+						SELECT $2, $1, image_id, now() - interval '1 day', 'friends' FROM cover
+						RETURNING album_id, album_name, album_owner, album_cover_id, created_at, revealed_at, visibility`,
+			ownerID, "Album "+strconv.Itoa(a)).Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner,
+			&album.AlbumCoverID, &album.CreatedAt, &album.RevealedAt, &album.Visibility)
+		if err != nil {
+			tb.Fatalf("seed album: %v", err)
+		}
+
+		_, err = connPool.Pool.Exec(ctx, `INSERT INTO albumuser (album_id, user_id) VALUES ($1, $2)`, album.AlbumID, ownerID)
+		if err != nil {
+			tb.Fatalf("seed album owner: %v", err)
+		}
+		_, err = connPool.Pool.Exec(ctx, `INSERT INTO album_requests (album_id, invited_id, status)
+						SELECT $1, guest_id, 'accepted' FROM unnest($2::uuid[]) AS guest_id`, album.AlbumID, guestIDs)
+		if err != nil {
+			tb.Fatalf("seed album guests: %v", err)
+		}
+
+		_, err = connPool.Pool.Exec(ctx, `WITH uploaded AS (INSERT INTO images (image_owner, caption, upload_type)
+							SELECT $2, 'Image ' || n, 'image' FROM generate_series(1, $3::int) AS n RETURNING image_id),
+						added AS (INSERT INTO imagealbum (image_id, album_id) SELECT image_id, $1 FROM uploaded)
+						INSERT INTO likes (user_id, image_id)
+						SELECT guest_id, image_id FROM uploaded, unnest($4::uuid[]) AS guest_id`,
+			album.AlbumID, ownerID, imagesPerAlbum, guestIDs)
+		if err != nil {
+			tb.Fatalf("seed images: %v", err)
+		}
+
+		albums = append(albums, album)
+	}
+
+	return albums
+}
+
+// BenchmarkAlbumAssembly compares filling in a page of albums with a query per album for the images and the guests,
+// the way the album lists used to, with assembleAlbums.
+func BenchmarkAlbumAssembly(b *testing.B) {
+	ctx := context.Background()
+	connPool := testPool(b)
+
+	ownerID, ownerAuthZeroID := seedUser(b, connPool, "Owner")
+	var guestIDs []string
+	for g := 0; g < 5; g++ {
+		guestID, _ := seedUser(b, connPool, "Guest"+strconv.Itoa(g))
+		guestIDs = append(guestIDs, guestID)
+	}
+	seeded := seedAlbums(b, connPool, ownerID, guestIDs, defaultAlbumListLimit, 30)
+
+	guestQuery := `SELECT u.user_id, u.first_name, u.last_name, ar.status
+					FROM users u
+					JOIN album_requests ar
+					ON u.user_id = ar.invited_id
+					WHERE ar.album_id = $1`
+
+	b.Run("per album", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			albums := append([]m.Album(nil), seeded...)
+
+			for i := range albums {
+				QueryImagesData(ctx, connPool, &albums[i], ownerAuthZeroID)
+
+				guestRows, err := connPool.Pool.Query(ctx, guestQuery, albums[i].AlbumID)
+				if err != nil {
+					b.Fatalf("query guests: %v", err)
+				}
+				for guestRows.Next() {
+					var guest m.Guest
+					err = guestRows.Scan(&guest.ID, &guest.FirstName, &guest.LastName, &guest.Status)
+					if err != nil {
+						b.Fatalf("scan guest: %v", err)
+					}
+					albums[i].InviteList = append(albums[i].InviteList, guest)
+				}
+				guestRows.Close()
+
+				albums[i].PhaseCalculation()
+			}
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			albums := append([]m.Album(nil), seeded...)
+
+			err := assembleAlbums(ctx, connPool, albums, ownerAuthZeroID, true)
+			if err != nil {
+				b.Fatalf("assemble: %v", err)
+			}
+		}
+	})
+}
+
+func TestAssembleAlbums(t *testing.T) {
+	ctx := context.Background()
+	connPool := testPool(t)
+
+	ownerID, _ := seedUser(t, connPool, "Owner")
+	guestID, guestAuthZeroID := seedUser(t, connPool, "Guest")
+	albums := seedAlbums(t, connPool, ownerID, []string{guestID}, 3, 2)
+
+	err := assembleAlbums(ctx, connPool, albums, guestAuthZeroID, true)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+
+	for _, album := range albums {
+		if len(album.Images) != 2 {
+			t.Errorf("album %v has %d images, want 2", album.AlbumName, len(album.Images))
+		}
+		for _, image := range album.Images {
+			if image.Likes != 1 || !image.UserLiked {
+				t.Errorf("image %v has %d likes, liked by the viewer %v, want 1 and true", image.ID, image.Likes, image.UserLiked)
+			}
+		}
+		if len(album.InviteList) != 1 || album.InviteList[0].ID != guestID {
+			t.Errorf("album %v has guests %+v, want only the guest", album.AlbumName, album.InviteList)
+		}
+	}
+}
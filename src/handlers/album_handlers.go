@@ -5,20 +5,27 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"firebase.google.com/go/v4/messaging"
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"io"
+	"last_weekend_services/src/events"
 	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
-func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Context, messagingClient *messaging.Client, gcpStorage storage.Client, liveBucket string) http.Handler {
+// AlbumRetentionWindow is how long a deleted album can be restored before it is purged
+const AlbumRetentionWindow = 30 * 24 * time.Hour
+
+func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Context, pushSenders push.Senders, gcpStorage storage.Client, liveBucket string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
 		if !ok {
@@ -35,6 +42,8 @@ func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Con
 				GETAlbumByAlbumID(w, r, connPool, ctx, claims.RegisteredClaims.Subject)
 			case "/album/images":
 				GETAlbumImagesByID(w, r, connPool, ctx, claims.RegisteredClaims.Subject)
+			case "/album/presence":
+				GETAlbumPresence(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject)
 				//case "/album/revealed":
 				//	GETRevealedAlbumsByAlbumID(w, r, connPool, ctx)
 				//case "/album/guests":
@@ -44,11 +53,15 @@ func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Con
 		case http.MethodPost:
 			switch r.URL.Path {
 			case "/album":
-				POSTNewAlbum(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, messagingClient)
+				POSTNewAlbum(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, pushSenders)
 			case "/album/guests":
-				InviteUserToAlbum(ctx, w, r, rdb, connPool, messagingClient)
+				InviteUserToAlbum(ctx, w, r, connPool)
+			case "/album/invite":
+				POSTBulkAlbumInvite(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 			case "/album/revealed":
-				GETRevealedAlbumsByAlbumID(w, r, connPool, ctx)
+				GETRevealedAlbumsByAlbumID(w, r, connPool, ctx, claims.RegisteredClaims.Subject)
+			case "/album/restore":
+				POSTRestoreAlbum(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 			}
 		case http.MethodPatch:
 			switch r.URL.Path {
@@ -58,11 +71,13 @@ func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Con
 				PATCHAlbumVisibility(ctx, w, r, connPool)
 			case "/album/timeline":
 				PATCHAlbumTimeline(ctx, w, r, connPool)
+			case "/album/archive":
+				PATCHAlbumArchive(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 			}
 		case http.MethodDelete:
 			switch r.URL.Path {
 			case "/album":
-				DELETEAlbum(ctx, w, r, connPool, claims.RegisteredClaims.Subject, gcpStorage, liveBucket)
+				DELETEAlbum(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 			case "/user/album":
 				DELETEUserFromAlbum(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 			}
@@ -72,31 +87,12 @@ func AlbumEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Con
 }
 
 func GETAlbumByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, ctx context.Context, authZeroID string) {
-	var hasAccess bool
 	var album m.Album
 	var guests []m.Guest
 	batch := &pgx.Batch{}
 	albumID := r.URL.Query().Get("album_id")
 
-	accessQuery := `SELECT EXISTS (
-						SELECT 1
-						FROM albums a
-						JOIN albumuser au ON a.album_id = au.album_id
-						WHERE a.album_id = $1
-						AND (
-							a.visibility = 'public'
-							OR (a.visibility = 'friends' AND EXISTS (
-								SELECT 1
-								FROM friends f
-								WHERE (f.user1_id = au.user_id AND f.user2_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
-								   OR (f.user2_id = au.user_id AND f.user1_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
-								   OR (au.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
-							))
-							OR (a.visibility = 'private' AND au.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
-						)
-					) AS has_access`
-
-	err := connPool.Pool.QueryRow(ctx, accessQuery, albumID, authZeroID).Scan(&hasAccess)
+	hasAccess, err := userHasAlbumAccess(ctx, connPool, albumID, authZeroID)
 	if err != nil {
 		log.Printf("Error getting access to album: %v", err)
 		WriteResponseWithCode(w, http.StatusNotFound, "Error getting access to album")
@@ -109,11 +105,12 @@ func GETAlbumByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPoo
 		return
 	}
 
-	albumQuery := `SELECT a.album_id, album_name, album_owner, u.first_name, u.last_name, a.created_at, revealed_at, album_cover_id, visibility
+	albumQuery := `SELECT a.album_id, album_name, album_owner, u.first_name, u.last_name, a.created_at, revealed_at, album_cover_id,
+       					visibility, a.archived_at IS NOT NULL
 					  FROM albums a
 					  JOIN users u
 					  ON a.album_owner=u.user_id
-					  WHERE a.album_id=$1`
+					  WHERE a.album_id=$1 AND a.deleted_at IS NULL`
 
 	guestQuery := `SELECT u.user_id, u.first_name, u.last_name, ar.status
 					FROM users u
@@ -133,7 +130,7 @@ func GETAlbumByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPoo
 	}()
 
 	err = batchResults.QueryRow().Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
-		&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility)
+		&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility, &album.Archived)
 	if err != nil {
 
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -188,16 +185,62 @@ func GETAlbumByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPoo
 	}
 }
 
+// userHasAlbumAccess reports whether the user can see the album based on its visibility and the user's relationship to
+// its guests
+func userHasAlbumAccess(ctx context.Context, connPool *m.PGPool, albumID string, authZeroID string) (bool, error) {
+	var hasAccess bool
+
+	accessQuery := `SELECT EXISTS (
+						SELECT 1
+						FROM albums a
+						JOIN albumuser au ON a.album_id = au.album_id
+						WHERE a.album_id = $1
+						AND a.deleted_at IS NULL
+						AND (
+							a.visibility = 'public'
+							OR (a.visibility = 'friends' AND EXISTS (
+								SELECT 1
+								FROM friends f
+								WHERE (f.user1_id = au.user_id AND f.user2_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
+								   OR (f.user2_id = au.user_id AND f.user1_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
+								   OR (au.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
+							))
+							OR (a.visibility = 'private' AND au.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2))
+						)
+					) AS has_access`
+
+	err := connPool.Pool.QueryRow(ctx, accessQuery, albumID, authZeroID).Scan(&hasAccess)
+	return hasAccess, err
+}
+
+const (
+	defaultAlbumListLimit  = 20
+	defaultAlbumImageLimit = 50
+)
+
+// GETAlbumImagesByID returns the album's images newest first, as a page when limit or cursor is given
 func GETAlbumImagesByID(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, ctx context.Context, authZeroID string) {
-	var album m.Album
+	albumID := r.URL.Query().Get("album_id")
 
-	album.AlbumID = r.URL.Query().Get("album_id")
+	page, err := parseListRequest(r, defaultAlbumImageLimit)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	QueryImagesData(ctx, connPool, &album, authZeroID)
+	images, err := queryAlbumImages(ctx, connPool, albumID, authZeroID, page)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query album images")
+		log.Printf("Unable to query album images: %v", err)
+		return
+	}
 
-	responseBytes, err := json.MarshalIndent(album.Images, "", "\t")
+	responseBytes, err := json.MarshalIndent(listBody(page.paginated, newPage(images, page, func(image m.Image) (time.Time, string) {
+		return image.CreatedAt, image.ID
+	})), "", "\t")
 	if err != nil {
-		log.Panic(err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query album images")
+		log.Printf("Unable to marshal album images: %v", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -205,8 +248,8 @@ func GETAlbumImagesByID(w http.ResponseWriter, r *http.Request, connPool *m.PGPo
 
 }
 
-func GETRevealedAlbumsByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, ctx context.Context) {
-	var albums []m.Album
+func GETRevealedAlbumsByAlbumID(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, ctx context.Context, uid string) {
+	albums := []m.Album{}
 	var albumIDs []string
 
 	err := json.NewDecoder(r.Body).Decode(&albumIDs)
@@ -215,90 +258,89 @@ func GETRevealedAlbumsByAlbumID(w http.ResponseWriter, r *http.Request, connPool
 		log.Printf("Unable to decode albumIDs: %v", err)
 		return
 	}
+	for _, albumID := range albumIDs {
+		if _, err := uuid.Parse(albumID); err != nil {
+			WriteResponseWithCode(w, http.StatusBadRequest, "Invalid album ID: "+albumID)
+			return
+		}
+	}
 
+	// The albums come back in the order they were requested, unknown and unrevealed albums are left out
 	albumQuery := `SELECT a.album_id, album_name, album_owner, u.first_name, u.last_name, a.created_at, revealed_at, album_cover_id, visibility
 					  FROM albums a
 					  JOIN users u
 					  ON a.album_owner=u.user_id
-					  WHERE a.album_id=$1 AND a.revealed_at < CURRENT_DATE`
+					  WHERE a.album_id = ANY($1::uuid[]) AND a.revealed_at < CURRENT_DATE AND a.deleted_at IS NULL
+					  ORDER BY array_position($1::uuid[], a.album_id)`
 
-	guestQuery := `SELECT u.user_id, u.first_name, u.last_name, ar.status
-					FROM users u
-					JOIN album_requests ar
-					ON u.user_id = ar.invited_id
-					WHERE ar.album_id = $1`
+	response, err := connPool.Pool.Query(ctx, albumQuery, albumIDs)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to query revealed albums: %v", err)
+		return
+	}
 
-	for _, id := range albumIDs {
+	for response.Next() {
 		var album m.Album
-		var guests []m.Guest
 
-		err = connPool.Pool.QueryRow(ctx, albumQuery, id).Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
+		err = response.Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
 			&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility)
 		if err != nil {
-			log.Print(err)
-		}
-		QueryImagesData(ctx, connPool, &album, id)
-
-		guestResponse, err := connPool.Pool.Query(ctx, guestQuery, id)
-		if err != nil {
-			log.Print(err)
-		}
-
-		// Deprecating because the owner is now being added into the original album_request query
-		//guest := m.Guest{
-		//	ID:        album.AlbumOwner,
-		//	FirstName: album.OwnerFirst,
-		//	LastName:  album.OwnerLast,
-		//	Status:    "accepted",
-		//}
-		//guests = append(guests, guest)
-
-		for guestResponse.Next() {
-
-			var guest m.Guest
-			err = guestResponse.Scan(&guest.ID, &guest.FirstName, &guest.LastName, &guest.Status)
-			if err != nil {
-				log.Print(err)
-			}
-
-			guests = append(guests, guest)
-			album.InviteList = guests
-
-			if id == "4ae4216a-5305-4d74-ba45-3af385a5d630" {
-				log.Printf("Guest: %v", len(guests))
-			}
-		}
-
-		err = album.PhaseCalculation()
-		if err != nil {
-			log.Print(err)
+			response.Close()
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+			log.Printf("Unable to scan revealed album: %v", err)
+			return
 		}
 
 		albums = append(albums, album)
-		log.Printf("appended")
+	}
+	response.Close()
+	if response.Err() != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to query revealed albums: %v", response.Err())
+		return
 	}
 
-	var responseBytes []byte
+	err = assembleAlbums(ctx, connPool, albums, uid, true)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to assemble revealed albums: %v", err)
+		return
+	}
 
-	responseBytes, err = json.MarshalIndent(albums, "", "\t")
+	responseBytes, err := json.MarshalIndent(albums, "", "\t")
 	if err != nil {
-		log.Panic(err)
+		log.Printf("Unable to marshal revealed albums: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(responseBytes)
 }
 
+// GETAlbumsByUserID returns the user's albums newest first, as a page when limit or cursor is given
 func GETAlbumsByUserID(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string, ctx context.Context) {
-	var albums []m.Album
+	albums := []m.Album{}
 
-	albumQuery := `SELECT a.album_id, album_name, album_owner, u.first_name, u.last_name, a.created_at, revealed_at, album_cover_id, visibility
+	page, err := parseListRequest(r, defaultAlbumListLimit)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Archived albums are still returned here since they are only hidden from the feed
+	albumQuery := `SELECT a.album_id, album_name, album_owner, u.first_name, u.last_name, a.created_at, revealed_at, album_cover_id,
+       				visibility, a.archived_at IS NOT NULL
 				   FROM albums a
 				   JOIN albumuser au
 				   ON au.album_id=a.album_id
 				   JOIN users u
 				   ON a.album_owner=u.user_id
-				   WHERE au.user_id=(SELECT user_id FROM users WHERE auth_zero_id=$1)`
+				   WHERE au.user_id=(SELECT user_id FROM users WHERE auth_zero_id=$1)
+				   AND a.deleted_at IS NULL
+				   AND ($2::timestamptz IS NULL OR (a.created_at, a.album_id) < ($2, $3::uuid))
+				   ORDER BY a.created_at DESC, a.album_id DESC
+				   LIMIT $4`
 
 	// Original guest query before conversion to just album_requests
 	//guestQuery := `SELECT au.user_id, u.first_name, u.last_name, 'accepted' AS status
@@ -311,74 +353,57 @@ func GETAlbumsByUserID(w http.ResponseWriter, r *http.Request, connPool *m.PGPoo
 	//				JOIN users u ON u.user_id = ar.invited_id
 	//				WHERE ar.album_id = $1 AND ar.status IN ('pending', 'denied')`
 
-	// This query fetches all albums for a user and thus getting all guests within every album - may need to remove
-	// this in the future since it can be fetched upon opening the album.
-	guestQuery := `SELECT au.invited_id, u.first_name, u.last_name, au.status
-					FROM album_requests au
-					JOIN users u
-					ON u.user_id = au.invited_id
-					WHERE au.album_id = $1`
-
-	response, err := connPool.Pool.Query(ctx, albumQuery, uid)
+	response, err := connPool.Pool.Query(ctx, albumQuery, uid, page.cursorTime, page.cursorID, page.queryLimit())
 	if err != nil {
-		log.Print(err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to query albums: %v", err)
+		return
 	}
 
 	for response.Next() {
 		var album m.Album
-		var guests []m.Guest
 
 		// Create Album Object
 		err := response.Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
-			&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility)
-		if err != nil {
-			log.Print(err)
-		}
-
-		// Fetch Albums Images
-		QueryImagesData(ctx, connPool, &album, uid)
-
-		guestResponse, err := connPool.Pool.Query(ctx, guestQuery, album.AlbumID)
+			&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility, &album.Archived)
 		if err != nil {
-			log.Print(err)
-		}
-		//guest := m.Guest{
-		//	ID:        album.AlbumOwner,
-		//	FirstName: album.OwnerFirst,
-		//	LastName:  album.OwnerLast,
-		//	Status:    "accepted",
-		//}
-		//guests = append(guests, guest)
-
-		for guestResponse.Next() {
-			var guest m.Guest
-
-			err := guestResponse.Scan(&guest.ID, &guest.FirstName, &guest.LastName, &guest.Status)
-			if err != nil {
-				log.Print(err)
-			}
-
-			guests = append(guests, guest)
-			album.InviteList = guests
+			response.Close()
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+			log.Printf("Unable to scan album: %v", err)
+			return
 		}
 
-		err = album.PhaseCalculation()
-
 		albums = append(albums, album)
 	}
+	response.Close()
+	if response.Err() != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to query albums: %v", response.Err())
+		return
+	}
 
-	var responseBytes []byte
+	albumPage := newPage(albums, page, func(album m.Album) (time.Time, string) {
+		return album.CreatedAt, album.AlbumID
+	})
 
-	responseBytes, err = json.MarshalIndent(albums, "", "\t")
+	err = assembleAlbums(ctx, connPool, albumPage.Items, uid, true)
 	if err != nil {
-		log.Panic(err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to assemble albums: %v", err)
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(listBody(page.paginated, albumPage), "", "\t")
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query albums")
+		log.Printf("Unable to marshal albums: %v", err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(responseBytes)
 }
 
-func POSTNewAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, uid string, messagingClient *messaging.Client) {
+func POSTNewAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, uid string, pushSenders push.Senders) {
 	album := m.Album{}
 
 	bytes, err := io.ReadAll(r.Body)
@@ -396,81 +421,102 @@ func POSTNewAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 		return
 	}
 
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error starting transaction")
+		log.Printf("Error starting transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = CreateAlbum(ctx, tx, uid, &album)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create new album")
+		log.Printf("Unable to create new album: %v", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create new album")
+		log.Printf("Error committing new album: %v", err)
+		return
+	}
+
+	insertResponse, err := json.MarshalIndent(album, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	responseBytes := []byte(insertResponse)
+
+	w.Header().Set("Content-Type", "application/json") // add content length number of bytes
+	w.Write(responseBytes)
+}
+
+// CreateAlbum inserts a new album owned by the user with the provided auth zero ID, adds the owner as an accepted
+// guest and sends the invites for album.InviteList and album.InviteGroups, all within tx. The album is updated in place
+// with the stored values.
+func CreateAlbum(ctx context.Context, tx pgx.Tx, uid string, album *m.Album) error {
 	newImageQuery := `INSERT INTO images
 					  (image_owner, caption, upload_type)
 					  VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2, 'album_cover') RETURNING image_id`
 
-	err = connPool.Pool.QueryRow(ctx, newImageQuery, uid, album.AlbumName).Scan(&album.AlbumCoverID)
+	err := tx.QueryRow(ctx, newImageQuery, uid, album.AlbumName).Scan(&album.AlbumCoverID)
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create entry in image table for album cover")
-		log.Printf("Unable to create entry in image table for album cover: %v", err)
-		return
+		return fmt.Errorf("unable to create entry in image table for album cover: %w", err)
 	}
 
 	createAlbumQuery := `INSERT INTO albums
 						  (album_name, album_owner, album_cover_id, revealed_at, visibility)
 						  VALUES ($1, (SELECT user_id FROM users WHERE auth_zero_id=$2), $3, $4, $5) RETURNING album_id, created_at, album_owner`
 
-	err = connPool.Pool.QueryRow(ctx, createAlbumQuery,
+	err = tx.QueryRow(ctx, createAlbumQuery,
 		album.AlbumName, uid, album.AlbumCoverID, album.RevealedAt, album.Visibility).Scan(&album.AlbumID, &album.CreatedAt, &album.AlbumOwner)
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create entry in albums table for new album - transaction cancelled")
-		log.Printf("Unable to create entry in albums table for new album: %v", err)
-		return
+		return fmt.Errorf("unable to create entry in albums table for new album: %w", err)
 	}
 
 	albumRequestOwnerQuery := `INSERT INTO album_requests 
     							(album_id, invited_id, invite_seen, status, response_seen)
     							VALUES ($1, (SELECT user_id FROM users WHERE auth_zero_id=$2), true, 'accepted', true)`
 
-	_, err = connPool.Pool.Exec(ctx, albumRequestOwnerQuery, album.AlbumID, uid)
+	_, err = tx.Exec(ctx, albumRequestOwnerQuery, album.AlbumID, uid)
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to add owner album request entry to new album")
-		log.Printf("Unable to add owner album request entry to new album: %v", err)
-		return
+		return fmt.Errorf("unable to add owner album request entry to new album: %w", err)
 	}
 
 	updateAlbumUserQuery := `INSERT INTO albumuser
 						(album_id, user_id)
 						VALUES ($1, (SELECT user_id FROM users WHERE auth_zero_id=$2))`
 
-	_, err = connPool.Pool.Exec(ctx, updateAlbumUserQuery, album.AlbumID, uid)
+	_, err = tx.Exec(ctx, updateAlbumUserQuery, album.AlbumID, uid)
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to associate album owner to the new album")
-		log.Printf("Unable to associate album owner to the new album: %v", err)
-		return
+		return fmt.Errorf("unable to associate album owner to the new album: %w", err)
 	}
 
 	getOwnerDetailsQuery := `SELECT first_name, last_name FROM users WHERE auth_zero_id=$1`
-	err = connPool.Pool.QueryRow(ctx, getOwnerDetailsQuery, uid).Scan(&album.OwnerFirst, &album.OwnerLast)
+	err = tx.QueryRow(ctx, getOwnerDetailsQuery, uid).Scan(&album.OwnerFirst, &album.OwnerLast)
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create entry in albums table for new album - transaction cancelled")
-		log.Printf("Unable to create entry in albums table for new album: %v", err)
-		return
+		return fmt.Errorf("unable to look up album owner details: %w", err)
 	}
 
 	err = album.PhaseCalculation()
 	if err != nil {
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to calculate phase")
-		log.Printf("Unable to calculate phase %v", err)
-		return
+		return fmt.Errorf("unable to calculate phase: %w", err)
 	}
 
-	err = SendAlbumRequests(ctx, &album, album.InviteList, rdb, connPool, messagingClient)
-	if err != nil {
-		log.Printf("Sending album requests failed with error: %v", err)
-		return
+	var guestIDs []string
+	for _, guest := range album.InviteList {
+		guestIDs = append(guestIDs, guest.ID)
 	}
 
-	insertResponse, err := json.MarshalIndent(album, "", "\t")
+	_, err = insertAlbumRequests(ctx, tx, album, guestIDs, album.InviteGroups)
 	if err != nil {
-		log.Print(err)
-		return
+		return fmt.Errorf("sending album requests failed: %w", err)
 	}
-	responseBytes := []byte(insertResponse)
 
-	w.Header().Set("Content-Type", "application/json") // add content length number of bytes
-	w.Write(responseBytes)
+	return nil
 }
 
 //func GETAlbumGuests(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, ctx context.Context) {
@@ -585,7 +631,17 @@ func PATCHAlbumVisibility(ctx context.Context, w http.ResponseWriter, r *http.Re
 					SET visibility = $1
 					WHERE album_id = $2`
 
-	rowsEdited, err := connPool.Pool.Exec(ctx, updateQuery, visibility, albumID)
+	// Live album connections re-check their access once the visibility change is committed
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rowsEdited, err := tx.Exec(ctx, updateQuery, visibility, albumID)
 	if err != nil {
 		log.Print(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -601,6 +657,22 @@ func PATCHAlbumVisibility(ctx context.Context, w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.AlbumAccessChanged{AlbumID: albumID})
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Print(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	w.Write([]byte("Album visibility updated"))
 }
 
@@ -622,8 +694,13 @@ func PATCHAlbumTimeline(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// An album moved back before its reveal is announced again when the new date passes
 	updateQuery := `UPDATE albums
-					SET revealed_at = $1
+					SET revealed_at = $1,
+						reveal_notified_at = CASE
+												WHEN $1 > (now() AT TIME ZONE 'utc'::text) THEN NULL
+												ELSE reveal_notified_at
+											 END
 					WHERE album_id = $2`
 
 	rows, err := connPool.Pool.Exec(ctx, updateQuery, album.RevealedAt, album.AlbumID)
@@ -643,9 +720,10 @@ func PATCHAlbumTimeline(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	return
 }
 
-func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string, gcpStorage storage.Client, bucket string) {
-	var isOwner bool
-	var images []string
+// DELETEAlbum soft deletes the album and its images. The album can be restored by the owner until the retention window
+// passes, after which AlbumPurgeJob removes it for good.
+func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	var deletedAt time.Time
 	albumID := r.URL.Query().Get("album_id")
 	if albumID == "" {
 		log.Print("New event ID not provided")
@@ -653,23 +731,107 @@ func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 		return
 	}
 
-	// i. Check that the user is the owner
-	ownerQuery := `SELECT EXISTS(SELECT 1 FROM albums 
-                    WHERE album_id = $1 
-                    AND album_owner = (SELECT user_id FROM users WHERE auth_zero_id = $2))`
-	err := connPool.Pool.QueryRow(ctx, ownerQuery, albumID, uid).Scan(&isOwner)
+	albumDeleteQuery := `UPDATE albums
+						SET deleted_at = (now() AT TIME ZONE 'utc'::text)
+						WHERE album_id = $1
+						AND deleted_at IS NULL
+						AND album_owner = (SELECT user_id FROM users WHERE auth_zero_id = $2)
+						RETURNING deleted_at`
+
+	imageDeleteQuery := `UPDATE images i
+						SET deleted_at = $2
+						FROM imagealbum ia
+						WHERE ia.album_id = $1
+						AND i.image_id = ia.image_id
+						AND i.deleted_at IS NULL`
+
+	coverDeleteQuery := `UPDATE images
+						SET deleted_at = $2
+						WHERE image_id = (SELECT album_cover_id FROM albums WHERE album_id = $1)`
+
+	tx, err := connPool.Pool.Begin(ctx)
 	if err != nil {
-		log.Print("Error querying album owner")
-		WriteResponseWithCode(w, http.StatusBadRequest, "Error querying album owner")
+		log.Printf("Error starting transaction: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error starting transaction")
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	if isOwner == false {
-		log.Print("Requester not current album owner")
-		WriteResponseWithCode(w, http.StatusBadRequest, "Requester not current album owner")
+	// The owner check is part of the update so a missing row covers both a bad ID and a non-owner
+	err = tx.QueryRow(ctx, albumDeleteQuery, albumID, uid).Scan(&deletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Print("Requester not current album owner or event already deleted")
+			WriteResponseWithCode(w, http.StatusBadRequest, "Requester not current album owner or event already deleted")
+			return
+		}
+		log.Printf("Error executing event delete: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error executing event delete")
 		return
 	}
 
+	_, err = tx.Exec(ctx, imageDeleteQuery, albumID, deletedAt)
+	if err != nil {
+		log.Printf("Error deleting images: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error deleting images")
+		return
+	}
+
+	_, err = tx.Exec(ctx, coverDeleteQuery, albumID, deletedAt)
+	if err != nil {
+		log.Printf("Error deleting album cover: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error deleting album cover")
+		return
+	}
+
+	// Close the live connections to the album
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.AlbumAccessChanged{AlbumID: albumID})
+	if err != nil {
+		log.Printf("Error queueing album access change: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error deleting event")
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error commit transaction to delete the event: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error commit transaction to delete the event")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, fmt.Sprintf("Success deleting event - it can be restored until %v",
+		deletedAt.Add(AlbumRetentionWindow).Format(time.RFC3339)))
+}
+
+func POSTRestoreAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	var deletedAt time.Time
+	albumID := r.URL.Query().Get("album_id")
+	if albumID == "" {
+		log.Print("New event ID not provided")
+		WriteResponseWithCode(w, http.StatusNotFound, "Event ID not provided")
+		return
+	}
+
+	albumRestoreQuery := `UPDATE albums a
+						SET deleted_at = NULL
+						FROM (SELECT album_id, deleted_at FROM albums WHERE album_id = $1) old
+						WHERE a.album_id = old.album_id
+						AND a.deleted_at > $3
+						AND a.album_owner = (SELECT user_id FROM users WHERE auth_zero_id = $2)
+						RETURNING old.deleted_at`
+
+	// Only the images removed together with the album are brought back
+	imageRestoreQuery := `UPDATE images i
+						SET deleted_at = NULL
+						FROM imagealbum ia
+						WHERE ia.album_id = $1
+						AND i.image_id = ia.image_id
+						AND i.deleted_at = $2`
+
+	coverRestoreQuery := `UPDATE images
+						SET deleted_at = NULL
+						WHERE image_id = (SELECT album_cover_id FROM albums WHERE album_id = $1)`
+
 	tx, err := connPool.Pool.Begin(ctx)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
@@ -678,31 +840,222 @@ func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 	}
 	defer tx.Rollback(ctx)
 
-	// Items that need to be removed
+	err = tx.QueryRow(ctx, albumRestoreQuery, albumID, uid, time.Now().UTC().Add(-AlbumRetentionWindow)).Scan(&deletedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Print("Event not found, not deleted or past the restore window")
+			WriteResponseWithCode(w, http.StatusNotFound, "Event not found, not deleted or past the restore window")
+			return
+		}
+		log.Printf("Error restoring event: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error restoring event")
+		return
+	}
+
+	_, err = tx.Exec(ctx, imageRestoreQuery, albumID, deletedAt)
+	if err != nil {
+		log.Printf("Error restoring images: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error restoring images")
+		return
+	}
+
+	_, err = tx.Exec(ctx, coverRestoreQuery, albumID)
+	if err != nil {
+		log.Printf("Error restoring album cover: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error restoring album cover")
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing transaction to restore the event: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error committing transaction to restore the event")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Event restored")
+}
+
+// PATCHAlbumArchive hides an album from feeds while keeping it available to its members
+func PATCHAlbumArchive(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	albumID := r.URL.Query().Get("album_id")
+	if albumID == "" {
+		log.Print("New event ID not provided")
+		WriteResponseWithCode(w, http.StatusNotFound, "Event ID not provided")
+		return
+	}
+
+	archived, err := strconv.ParseBool(r.URL.Query().Get("archived"))
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: archived must be true or false")
+		return
+	}
+
+	archiveQuery := `UPDATE albums
+					SET archived_at = CASE WHEN $3 THEN COALESCE(archived_at, now() AT TIME ZONE 'utc'::text) END
+					WHERE album_id = $1
+					AND deleted_at IS NULL
+					AND album_owner = (SELECT user_id FROM users WHERE auth_zero_id = $2)`
+
+	tag, err := connPool.Pool.Exec(ctx, archiveQuery, albumID, uid, archived)
+	if err != nil {
+		log.Printf("Error updating event archive state: %v", err)
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error updating event archive state")
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		log.Print("Requester not current album owner")
+		WriteResponseWithCode(w, http.StatusBadRequest, "Requester not current album owner")
+		return
+	}
+
+	if archived {
+		WriteResponseWithCode(w, http.StatusOK, "Event archived")
+		return
+	}
+	WriteResponseWithCode(w, http.StatusOK, "Event unarchived")
+}
+
+// AlbumPurgeJob hard deletes every album that has been soft deleted for longer than the retention window, checking
+// every interval until the context is cancelled.
+func AlbumPurgeJob(ctx context.Context, connPool *m.PGPool, gcpStorage storage.Client, bucket string, interval time.Duration) {
+	expiredQuery := `SELECT album_id FROM albums WHERE deleted_at < $1`
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var albumIDs []string
+
+			rows, err := connPool.Pool.Query(ctx, expiredQuery, time.Now().UTC().Add(-AlbumRetentionWindow))
+			if err != nil {
+				log.Printf("Album purge: unable to query expired albums: %v", err)
+				continue
+			}
+			for rows.Next() {
+				var albumID string
+				err = rows.Scan(&albumID)
+				if err != nil {
+					log.Printf("Album purge: unable to scan album: %v", err)
+					continue
+				}
+				albumIDs = append(albumIDs, albumID)
+			}
+			rows.Close()
+
+			for _, albumID := range albumIDs {
+				err = PurgeAlbum(ctx, connPool, gcpStorage, bucket, albumID)
+				if err != nil {
+					log.Printf("Album purge: unable to purge album %v: %v", albumID, err)
+				}
+			}
+		}
+	}
+}
+
+// AlbumRevealJob announces every album whose reveal date has passed to its members, checking every interval until
+// the context is cancelled.
+func AlbumRevealJob(ctx context.Context, connPool *m.PGPool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := announceRevealedAlbums(ctx, connPool)
+			if err != nil {
+				log.Printf("Album reveal: unable to announce revealed albums: %v", err)
+			}
+		}
+	}
+}
+
+// announceRevealedAlbums marks the revealed albums as announced and enqueues their events in the same transaction, so
+// an album is announced once even with several instances running the job
+func announceRevealedAlbums(ctx context.Context, connPool *m.PGPool) error {
+	var revealed []events.AlbumRevealed
+	var revealedAt []time.Time
+
+	revealedQuery := `UPDATE albums a
+						SET reveal_notified_at = (now() AT TIME ZONE 'utc'::text)
+						WHERE a.album_id IN (SELECT album_id
+											 FROM albums
+											 WHERE reveal_notified_at IS NULL
+											 AND deleted_at IS NULL
+											 AND revealed_at <= (now() AT TIME ZONE 'utc'::text)
+											 LIMIT 100
+											 FOR UPDATE SKIP LOCKED)
+						RETURNING a.album_id, a.album_name, a.revealed_at,
+							ARRAY(SELECT au.user_id FROM albumuser au WHERE au.album_id = a.album_id)`
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, revealedQuery)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var album events.AlbumRevealed
+		var at time.Time
+
+		err = rows.Scan(&album.AlbumID, &album.AlbumName, &at, &album.MemberIDs)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		revealed = append(revealed, album)
+		revealedAt = append(revealedAt, at)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	for i, album := range revealed {
+		// The reveal date is part of the ID, an album that is moved and revealed again is announced again
+		err = EnqueueEvent(ctx, tx, fmt.Sprintf("album:%v:revealed:%v", album.AlbumID, revealedAt[i].Unix()), album)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// PurgeAlbum removes the album, its requests, members and images from the database and then deletes the image data
+// from the bucket.
+func PurgeAlbum(ctx context.Context, connPool *m.PGPool, gcpStorage storage.Client, bucket string, albumID string) error {
+	var images []string
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
 	// 1. Remove the requests from the album_request table
 	arRemoveQuery := `DELETE FROM album_requests WHERE album_id = $1`
 	_, err = tx.Exec(ctx, arRemoveQuery, albumID)
 	if err != nil {
-		log.Printf("Error executing album requests query: %v", err)
-		WriteResponseWithCode(w, http.StatusBadRequest, "Error executing album requests query")
-		return
+		return fmt.Errorf("error executing album requests query: %w", err)
 	}
 
-	// May not be necessary as it could be possible that there are no requests
-	//if arRows.RowsAffected() == 0 {
-	//	log.Printf("No album requests deleted: %v", err)
-	//	WriteResponseWithCode(w, http.StatusBadRequest, "No album requests deleted")
-	//	return
-	//}
-
 	// 2. Remove the entries from the albumuser table
 	auRemoveQuery := `DELETE FROM albumuser WHERE album_id = $1`
 	_, err = tx.Exec(ctx, auRemoveQuery, albumID)
 	if err != nil {
-		log.Printf("Error executing albumuser query: %v", err)
-		WriteResponseWithCode(w, http.StatusBadRequest, "Error executing albumuser query")
-		return
+		return fmt.Errorf("error executing albumuser query: %w", err)
 	}
 
 	// 3. Remove the images related from the images
@@ -713,32 +1066,21 @@ func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 					RETURNING i.image_id`
 	imageIDs, err := tx.Query(ctx, imageQuery, albumID)
 	if err != nil {
-		log.Printf("Error deleting images: %v", err)
-		WriteResponseWithCode(w, http.StatusBadRequest, "Error deleting images")
-		return
+		return fmt.Errorf("error deleting images: %w", err)
 	}
 	for imageIDs.Next() {
 		var image string
 		err = imageIDs.Scan(&image)
 		if err != nil {
-			log.Printf("Error scanning imageID: %v", err)
-			WriteResponseWithCode(w, http.StatusBadRequest, "Error scanning imageID")
-			return
+			imageIDs.Close()
+			return fmt.Errorf("error scanning imageID: %w", err)
 		}
 
 		images = append(images, image)
 	}
+	imageIDs.Close()
 
-	//4. Remove the images from related from the imagealbum table
-	//iaQuery := `DELETE FROM imagealbum WHERE album_id = $1`
-	//_, err = tx.Exec(ctx, iaQuery, albumID)
-	//if err != nil {
-	//	log.Printf("Error executing imagealbum query: %v for album: %v", err, albumID)
-	//	WriteResponseWithCode(w, http.StatusBadRequest, "Error executing imagealbum query")
-	//	return
-	//}
-
-	// 5. Remove the album from the albums table - primary key cannot be violated
+	// 4. Remove the album from the albums table - primary key cannot be violated
 	var albumCoverID string
 	albumDeleteQuery := `DELETE FROM albums WHERE album_id = $1 RETURNING album_cover_id`
 	err = tx.QueryRow(ctx, albumDeleteQuery, albumID).Scan(&albumCoverID)
@@ -746,16 +1088,18 @@ func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 		if errors.Is(err, pgx.ErrNoRows) {
 			log.Printf("Could not query album cover ID: %v", err)
 		} else {
-			log.Printf("Error executing event delete: %v", err)
-			WriteResponseWithCode(w, http.StatusBadRequest, "Error executing event delete")
-			return
+			return fmt.Errorf("error executing event delete: %w", err)
 		}
 	}
 
 	images = append(images, albumCoverID)
 
-	// 6. Remove the image data from the cloud database (could be down through a unique function - but may need to rely
-	// on the success of the transaction first).
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("error commit transaction to delete the event: %w", err)
+	}
+
+	// 5. Remove the image data from the bucket once the database no longer references it
 	for _, image := range images {
 		smallImageID := fmt.Sprintf("%s_%d", image, 540)
 		largeImageID := fmt.Sprintf("%s_%d", image, 1080)
@@ -774,13 +1118,7 @@ func DELETEAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, co
 		}
 	}
 
-	err = tx.Commit(ctx)
-	if err != nil {
-		log.Printf("Error commit transaction to delete the event: %v", err)
-		WriteResponseWithCode(w, http.StatusInternalServerError, "Error commit transaction to delete the event")
-		return
-	}
-	WriteResponseWithCode(w, http.StatusOK, "Success deleting event")
+	return nil
 }
 
 func DELETEUserFromAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
@@ -853,6 +1191,14 @@ func DELETEUserFromAlbum(ctx context.Context, w http.ResponseWriter, r *http.Req
 	//	WriteResponseWithCode(w, http.StatusBadRequest, "Error abandoning images in event")
 	//}
 
+	// The user may still be able to see the album through its visibility, their live connections re-check
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.AlbumAccessChanged{AlbumID: albumID})
+	if err != nil {
+		log.Printf("Error queueing album access change: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error removing user from event")
+		return
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		log.Printf("Error committing transaction to remove user from event: %v", err)
@@ -890,68 +1236,246 @@ func WriteResponseWithCode(w http.ResponseWriter, code int, message string) {
 	w.Write(responseBytes)
 }
 
-func SendAlbumRequests(ctx context.Context, album *m.Album, invited []m.Guest, rdb *redis.Client, connPool *m.PGPool, messagingClient *messaging.Client) error {
-	query := `INSERT INTO album_requests (album_id, invited_id) 
-										VALUES ($1, $2) RETURNING request_id, updated_at, invite_seen, response_seen, status`
-	var albumRequest = m.AlbumRequestNotification{
-		AlbumID:      album.AlbumID,
-		AlbumName:    album.AlbumName,
-		AlbumCoverID: album.AlbumCoverID,
-		AlbumOwner:   album.AlbumOwner,
-		OwnerFirst:   album.OwnerFirst,
-		OwnerLast:    album.OwnerLast,
-		RevealedAt:   album.RevealedAt,
+// SendAlbumRequests creates album requests for every guest and every member of the provided friend groups in a single
+// transaction. Users that already have a request for the album are skipped.
+func SendAlbumRequests(ctx context.Context, album *m.Album, guestIDs []string, groupIDs []string, connPool *m.PGPool) ([]m.AlbumRequestNotification, error) {
+	if len(guestIDs) == 0 && len(groupIDs) == 0 {
+		return nil, nil
+	}
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	var fcmNotification = m.FirebaseNotification{
-		NotificationID: album.AlbumID,
-		ContentName:    album.AlbumName,
-		RequesterID:    album.AlbumOwner,
-		RequesterName:  album.OwnerFirst,
-		Type:           "album-invite",
+	albumRequests, err := insertAlbumRequests(ctx, tx, album, guestIDs, groupIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, user := range invited {
-		var wsPayload WebSocketPayload
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		albumRequest.GuestID = user.ID
-		albumRequest.GuestFirst = user.FirstName
-		albumRequest.GuestLast = user.LastName
+	return albumRequests, nil
+}
 
-		err := connPool.Pool.QueryRow(ctx, query, album.AlbumID, user.ID).Scan(&albumRequest.RequestID,
-			&albumRequest.ReceivedAt, &albumRequest.InviteSeen, &albumRequest.ResponseSeen, &albumRequest.Status)
-		if err != nil {
-			log.Printf("Failed to add user to album request table: %v", err)
-			return err
+// insertAlbumRequests invites the guests who are friends of the owner and the members of the owner's groups to the
+// album within tx, skipping anyone who was already invited, and queues an event for each new request
+func insertAlbumRequests(ctx context.Context, tx pgx.Tx, album *m.Album, guestIDs []string, groupIDs []string) ([]m.AlbumRequestNotification, error) {
+	var albumRequests []m.AlbumRequestNotification
+
+	if len(guestIDs) == 0 && len(groupIDs) == 0 {
+		return albumRequests, nil
+	}
+
+	// Locking the album row serializes concurrent invites to the same album so the NOT EXISTS check can be trusted
+	lockAlbumQuery := `SELECT album_id FROM albums WHERE album_id = $1 FOR UPDATE`
+
+	insertRequestsQuery := `WITH candidates AS (
+								SELECT f.friend_id AS user_id
+								FROM (SELECT CASE WHEN user1_id = $4 THEN user2_id ELSE user1_id END AS friend_id
+									FROM friends
+									WHERE user1_id = $4 OR user2_id = $4) f
+								WHERE f.friend_id::text = ANY($2::text[])
+								UNION
+								SELECT fgm.user_id
+								FROM friend_group_members fgm
+								JOIN friend_groups fg ON fg.group_id = fgm.group_id
+								WHERE fgm.group_id::text = ANY($3::text[])
+								AND fg.owner_id = $4
+							), inserted AS (
+								INSERT INTO album_requests (album_id, invited_id)
+								SELECT $1, c.user_id
+								FROM candidates c
+								WHERE NOT EXISTS (
+									SELECT 1 FROM album_requests ar
+									WHERE ar.album_id = $1 AND ar.invited_id = c.user_id)
+								RETURNING request_id, invited_id, updated_at, invite_seen, response_seen, status
+							)
+							SELECT i.request_id, i.invited_id, u.first_name, u.last_name, i.updated_at,
+								i.invite_seen, i.response_seen, i.status
+							FROM inserted i
+							JOIN users u ON u.user_id = i.invited_id`
+
+	_, err := tx.Exec(ctx, lockAlbumQuery, album.AlbumID)
+	if err != nil {
+		return nil, err
+	}
+
+	if guestIDs == nil {
+		guestIDs = []string{}
+	}
+	if groupIDs == nil {
+		groupIDs = []string{}
+	}
+
+	rows, err := tx.Query(ctx, insertRequestsQuery, album.AlbumID, guestIDs, groupIDs, album.AlbumOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var albumRequest = m.AlbumRequestNotification{
+			AlbumID:      album.AlbumID,
+			AlbumName:    album.AlbumName,
+			AlbumCoverID: album.AlbumCoverID,
+			AlbumOwner:   album.AlbumOwner,
+			OwnerFirst:   album.OwnerFirst,
+			OwnerLast:    album.OwnerLast,
+			RevealedAt:   album.RevealedAt,
 		}
-		wsPayload.Operation = "REQUEST"
-		wsPayload.Type = "album-invite"
-		wsPayload.UserID = user.ID
-		wsPayload.Payload = albumRequest
 
-		jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
+		err = rows.Scan(&albumRequest.RequestID, &albumRequest.GuestID, &albumRequest.GuestFirst, &albumRequest.GuestLast,
+			&albumRequest.ReceivedAt, &albumRequest.InviteSeen, &albumRequest.ResponseSeen, &albumRequest.Status)
 		if err != nil {
-			log.Print(err)
+			rows.Close()
+			return nil, err
 		}
 
-		err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+		albumRequests = append(albumRequests, albumRequest)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	err = enqueueAlbumRequests(ctx, tx, albumRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return albumRequests, nil
+}
+
+// ownsInvitees reports whether every guest is a friend of the owner and every group belongs to the owner. Malformed
+// IDs are reported as not owned rather than left for the database to reject.
+func ownsInvitees(ctx context.Context, connPool *m.PGPool, ownerAuthZeroID string, guestIDs []string, groupIDs []string) (bool, error) {
+	var owned bool
+
+	if len(guestIDs) == 0 && len(groupIDs) == 0 {
+		return true, nil
+	}
+
+	for _, id := range append(append([]string{}, guestIDs...), groupIDs...) {
+		_, err := uuid.Parse(id)
 		if err != nil {
-			log.Print(err)
+			return false, nil
 		}
+	}
 
-		fcmNotification.RecipientID = user.ID
-		//fcmNotification.Payload = albumRequest
+	if guestIDs == nil {
+		guestIDs = []string{}
+	}
+	if groupIDs == nil {
+		groupIDs = []string{}
+	}
 
-		err = SendFirebaseMessageToUID(ctx, connPool, messagingClient, fcmNotification)
+	ownedQuery := `SELECT NOT EXISTS (
+						SELECT 1 FROM unnest($2::text[]::uuid[]) AS g(user_id)
+						WHERE NOT EXISTS (
+							SELECT 1 FROM friends f
+							WHERE (f.user1_id = o.user_id AND f.user2_id = g.user_id)
+							   OR (f.user2_id = o.user_id AND f.user1_id = g.user_id)))
+					AND NOT EXISTS (
+						SELECT 1 FROM unnest($3::text[]::uuid[]) AS g(group_id)
+						WHERE NOT EXISTS (
+							SELECT 1 FROM friend_groups fg
+							WHERE fg.group_id = g.group_id AND fg.owner_id = o.user_id))
+					FROM users o
+					WHERE o.auth_zero_id = $1`
+
+	err := connPool.Pool.QueryRow(ctx, ownedQuery, ownerAuthZeroID, guestIDs, groupIDs).Scan(&owned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return owned, err
+}
+
+// enqueueAlbumRequests queues an event for each new request, keyed by the request ID so a request is never announced
+// twice
+func enqueueAlbumRequests(ctx context.Context, tx pgx.Tx, albumRequests []m.AlbumRequestNotification) error {
+	for _, albumRequest := range albumRequests {
+		err := EnqueueEvent(ctx, tx, albumRequest.RequestID, events.AlbumInviteSent{Request: albumRequest})
 		if err != nil {
-			log.Print(err)
+			return err
 		}
 	}
 
 	return nil
 }
 
-func InviteUserToAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, rdb *redis.Client, connPool *m.PGPool, messagingClient *messaging.Client) {
+func POSTBulkAlbumInvite(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var invite m.BulkAlbumInvite
+	var album m.Album
+
+	err := json.NewDecoder(r.Body).Decode(&invite)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Invalid request body - could not be mapped to object")
+		log.Printf("Unable to decode bulk invite: %v", err)
+		return
+	}
+
+	if invite.AlbumID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Event ID not provided")
+		return
+	}
+
+	// Only the owner can invite guests so the album is only found when the requester owns it
+	albumQuery := `SELECT a.album_id, a.album_name, a.album_owner, u.first_name, u.last_name, a.album_cover_id, a.revealed_at
+					FROM albums a
+					JOIN users u
+					ON a.album_owner = u.user_id
+					WHERE a.album_id = $1
+					AND u.auth_zero_id = $2`
+
+	err = connPool.Pool.QueryRow(ctx, albumQuery, invite.AlbumID, authZeroID).Scan(&album.AlbumID, &album.AlbumName,
+		&album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast, &album.AlbumCoverID, &album.RevealedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Event not found or requester not the owner")
+			return
+		}
+		log.Printf("Unable to look up event for invite: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to look up event for invite")
+		return
+	}
+
+	owned, err := ownsInvitees(ctx, connPool, authZeroID, invite.GuestIDs, invite.GroupIDs)
+	if err != nil {
+		log.Printf("Unable to check invited guests: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to check invited guests")
+		return
+	}
+	if !owned {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Guests must be valid IDs of friends and groups must be your own")
+		return
+	}
+
+	albumRequests, err := SendAlbumRequests(ctx, &album, invite.GuestIDs, invite.GroupIDs, connPool)
+	if err != nil {
+		log.Printf("Sending album requests failed with error: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Sending album requests failed")
+		return
+	}
+
+	if albumRequests == nil {
+		albumRequests = []m.AlbumRequestNotification{}
+	}
+
+	responseBytes, err := json.MarshalIndent(albumRequests, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func InviteUserToAlbum(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool) {
 	var albumRequest m.AlbumRequestNotification
 
 	// Get Information from Request
@@ -978,6 +1502,7 @@ func InviteUserToAlbum(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	err := batchResults.QueryRow().Scan(&albumRequest.AlbumName, &albumRequest.AlbumCoverID, &albumRequest.RevealedAt)
 	err = batchResults.QueryRow().Scan(&albumRequest.GuestID, &albumRequest.GuestFirst, &albumRequest.GuestLast)
 	err = batchResults.QueryRow().Scan(&albumRequest.OwnerFirst, &albumRequest.OwnerFirst)
+	batchResults.Close()
 	if err != nil {
 		log.Printf("Failure in batch: %v", err)
 		responseBytes := []byte(err.Error())
@@ -987,65 +1512,39 @@ func InviteUserToAlbum(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		w.Write(responseBytes)
 	}
 
-	// Execute Album Request Query
-	err = connPool.Pool.QueryRow(ctx, insertInviteQuery, albumRequest.AlbumID, albumRequest.GuestID).Scan(&albumRequest.RequestID,
-		&albumRequest.ReceivedAt, &albumRequest.InviteSeen, &albumRequest.ResponseSeen, &albumRequest.Status)
+	// The request and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
 	if err != nil {
-		log.Printf("Failed to add user to album request table: %v", err)
-		responseBytes := []byte(err.Error())
-
-		w.WriteHeader(401)
-		w.Header().Set("Content-Type", "application/json") // add content length number of bytes
-		w.Write(responseBytes)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error: Could not start transaction")
+		log.Printf("Could not start transaction: %v", err)
+		return
 	}
+	defer tx.Rollback(ctx)
 
-	// Assemble WS Payload Information
-	var wsPayload WebSocketPayload
-	wsPayload.Operation = "REQUEST"
-	wsPayload.Type = "album-invite"
-	wsPayload.UserID = albumRequest.GuestID
-	wsPayload.Payload = albumRequest
-
-	jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
+	// Execute Album Request Query
+	err = tx.QueryRow(ctx, insertInviteQuery, albumRequest.AlbumID, albumRequest.GuestID).Scan(&albumRequest.RequestID,
+		&albumRequest.ReceivedAt, &albumRequest.InviteSeen, &albumRequest.ResponseSeen, &albumRequest.Status)
 	if err != nil {
-		log.Print(err)
-
+		log.Printf("Failed to add user to album request table: %v", err)
 		responseBytes := []byte(err.Error())
 
 		w.WriteHeader(401)
 		w.Header().Set("Content-Type", "application/json") // add content length number of bytes
 		w.Write(responseBytes)
+		return
 	}
 
-	// Send to Notification Redis Channel
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = EnqueueEvent(ctx, tx, albumRequest.RequestID, events.AlbumInviteSent{Request: albumRequest})
 	if err != nil {
 		log.Print(err)
-		responseBytes := []byte(err.Error())
-
-		w.WriteHeader(401)
-		w.Header().Set("Content-Type", "application/json") // add content length number of bytes
-		w.Write(responseBytes)
-	}
-
-	// Prepare Firebase Notification
-	var fcmNotification = m.FirebaseNotification{
-		RecipientID:    albumRequest.GuestID,
-		NotificationID: albumRequest.AlbumID,
-		ContentName:    albumRequest.AlbumName,
-		RequesterID:    albumRequest.AlbumOwner,
-		RequesterName:  albumRequest.OwnerFirst,
-		Type:           "album-invite",
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error: Could not queue album invite")
+		return
 	}
 
-	// Send Firebase Notification
-	err = SendFirebaseMessageToUID(ctx, connPool, messagingClient, fcmNotification)
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
-		responseBytes := []byte(err.Error())
-
-		w.WriteHeader(401)
-		w.Header().Set("Content-Type", "application/json") // add content length number of bytes
-		w.Write(responseBytes)
+		log.Printf("Could not commit album request: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error: Could not commit album request")
+		return
 	}
 }
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkAlbumInviteRejectsStrangers(t *testing.T) {
+	ctx := context.Background()
+	connPool := testPool(t)
+
+	ownerID, ownerAuthZeroID := seedUser(t, connPool, "Ana")
+	friendID, _ := seedUser(t, connPool, "Ben")
+	strangerID, _ := seedUser(t, connPool, "Cleo")
+	seedFriends(t, connPool, ownerID, friendID)
+	album := seedAlbums(t, connPool, ownerID, nil, 1, 0)[0]
+
+	guestLists := map[string]string{
+		"malformed id": `["` + friendID + `", "not-a-uuid"]`,
+		"stranger":     `["` + friendID + `", "` + strangerID + `"]`,
+	}
+
+	for name, guestIDs := range guestLists {
+		t.Run(name, func(t *testing.T) {
+			body := `{"album_id": "` + album.AlbumID + `", "guest_ids": ` + guestIDs + `}`
+			w := httptest.NewRecorder()
+			POSTBulkAlbumInvite(ctx, w, httptest.NewRequest(http.MethodPost, "/album/invite", strings.NewReader(body)),
+				connPool, ownerAuthZeroID)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d: %s, want %d", w.Code, w.Body, http.StatusBadRequest)
+			}
+		})
+	}
+
+	var invited int
+	err := connPool.Pool.QueryRow(ctx, `SELECT count(*) FROM album_requests WHERE album_id = $1 AND invited_id != $2`,
+		album.AlbumID, ownerID).Scan(&invited)
+	if err != nil {
+		t.Fatalf("count invites: %v", err)
+	}
+	if invited != 0 {
+		t.Errorf("%d guests were invited, want none", invited)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/redis/go-redis/v9"
+	"last_weekend_services/src/events"
 	m "last_weekend_services/src/models"
 	"log"
 	"net/http"
@@ -37,12 +38,7 @@ func PUTAcceptAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 		Status: `accepted`,
 	}
 
-	wsPayload := WebSocketPayload{
-		Operation: "ACCEPTED",
-		Type:      "album-invite",
-	}
-
-	var guests []m.Guest
+	var guestIDs []string
 
 	notification.RequestID = r.URL.Query().Get("request_id")
 
@@ -73,13 +69,21 @@ func PUTAcceptAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 						AND status = 'accepted'
 						AND invited_id != (SELECT user_id FROM users WHERE users.auth_zero_id = $2));`
 
-	err := connPool.Pool.QueryRow(ctx, updateReqToAccepted, notification.RequestID).Scan(&notification.AlbumID, &notification.ReceivedAt)
+	// The response and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Could not start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, updateReqToAccepted, notification.RequestID).Scan(&notification.AlbumID, &notification.ReceivedAt)
 	if err != nil {
 		log.Printf("Update Request Error: %v", err)
 		return
 	}
 
-	_, err = connPool.Pool.Exec(ctx, addUserToAlbumUser, notification.AlbumID, authZeroID)
+	_, err = tx.Exec(ctx, addUserToAlbumUser, notification.AlbumID, authZeroID)
 	if err != nil {
 		log.Printf("Add User to AU Error: %v", err)
 		return
@@ -89,14 +93,7 @@ func PUTAcceptAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 	batch.Queue(acceptsInfoQuery, authZeroID)
 	batch.Queue(albumInfoQuery, notification.AlbumID)
 	batch.Queue(getGuestsIDsAR, notification.AlbumID, authZeroID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	batchResults := tx.SendBatch(ctx, batch)
 
 	err = batchResults.QueryRow().Scan(&notification.GuestID, &notification.GuestFirst, &notification.GuestLast)
 	if err != nil {
@@ -118,32 +115,34 @@ func PUTAcceptAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	for rows.Next() {
-		var guest m.Guest
+		var guestID string
 
-		err = rows.Scan(&guest.ID)
+		err = rows.Scan(&guestID)
 		if err != nil {
 			log.Print(err)
 		}
 
-		guests = append(guests, guest)
+		guestIDs = append(guestIDs, guestID)
 	}
+	rows.Close()
 
-	wsPayload.Payload = notification
-
-	for _, guest := range guests {
-		wsPayload.UserID = guest.ID
-
-		// Send payload to WebSocket
-		jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
-		if err != nil {
-			log.Print(err)
-		}
+	// The batch has to be closed before the transaction can be used again
+	err = batchResults.Close()
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-		err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
-		if err != nil {
-			log.Print(err)
-		}
+	err = EnqueueEvent(ctx, tx, notification.RequestID+":accepted", events.AlbumInviteAccepted{Request: notification, GuestIDs: guestIDs})
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Could not commit album request accepted: %v", err)
+		return
 	}
 
 	//Respond to the calling user that the action was successful
@@ -162,12 +161,8 @@ func DELETEDenyAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	notification := m.AlbumRequestNotification{
 		Status: `denied`,
 	}
-	wsPayload := WebSocketPayload{
-		Operation: "DENIED",
-		Type:      "album-invite",
-	}
 	requestID := r.URL.Query().Get("request_id")
-	var guests []m.Guest
+	var guestIDs []string
 
 	// Prepare SQL statements
 	denyRequestQuery := `UPDATE album_requests 
@@ -187,7 +182,15 @@ func DELETEDenyAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.
 						AND invited_id != (SELECT user_id FROM users WHERE users.auth_zero_id = $2));`
 
 	// Execute the delete outside of the batch since the albumOwnerIDQuery is reliant on the notification of this request
-	err := connPool.Pool.QueryRow(ctx, denyRequestQuery, requestID).Scan(&notification.AlbumID, &notification.ReceivedAt)
+	// The response and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Could not start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, denyRequestQuery, requestID).Scan(&notification.AlbumID, &notification.ReceivedAt)
 	if err != nil {
 		log.Print(err)
 		return
@@ -198,16 +201,9 @@ func DELETEDenyAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	batch.Queue(albumOwnerIDQuery, &notification.AlbumID)
 	batch.Queue(userInfoQuery, authZeroID)
 	batch.Queue(getGuestsIDsAR, notification.AlbumID, authZeroID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	batchResults := tx.SendBatch(ctx, batch)
 
-	err = batchResults.QueryRow().Scan(&wsPayload.UserID)
+	err = batchResults.QueryRow().Scan(&notification.AlbumOwner)
 	if err != nil {
 		log.Print(err)
 		return
@@ -225,34 +221,34 @@ func DELETEDenyAlbumRequest(ctx context.Context, w http.ResponseWriter, r *http.
 	}
 
 	for rows.Next() {
-		var guest m.Guest
+		var guestID string
 
-		err = rows.Scan(&guest.ID)
+		err = rows.Scan(&guestID)
 		if err != nil {
 			log.Print(err)
 		}
 
-		guests = append(guests, guest)
+		guestIDs = append(guestIDs, guestID)
 	}
+	rows.Close()
 
-	// Add the notification struct to the wsPayload
-	wsPayload.Payload = notification
-
-	// Send payload to WebSocket
-	for _, guest := range guests {
-		wsPayload.UserID = guest.ID
-
-		// Send payload to WebSocket
-		jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
-		if err != nil {
-			log.Print(err)
-		}
+	// The batch has to be closed before the transaction can be used again
+	err = batchResults.Close()
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-		err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
-		if err != nil {
-			log.Print(err)
-		}
+	err = EnqueueEvent(ctx, tx, requestID+":denied", events.AlbumInviteDenied{Request: notification, GuestIDs: guestIDs})
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Could not commit album request denied: %v", err)
+		return
 	}
 
 	//Respond to the calling user that the action was successful
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
+	"log"
+	"net/http"
+	"time"
+)
+
+const albumTemplateColumns = `t.template_id, t.owner_id, t.name_pattern, t.visibility, t.guest_ids::text[], t.group_ids::text[],
+							t.reveal_offset_hours, t.recurrence, t.next_run_at, t.last_album_id, t.run_count, t.active, t.created_at`
+
+func AlbumTemplateEndpointHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, pushSenders push.Senders) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		if !ok {
+			log.Printf("Failed to get validated claims")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			GETAlbumTemplates(ctx, w, connPool, claims.RegisteredClaims.Subject)
+		case http.MethodPost:
+			switch r.URL.Path {
+			case "/album/template":
+				POSTNewAlbumTemplate(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			case "/album/template/run":
+				POSTRunAlbumTemplate(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, pushSenders)
+			}
+		case http.MethodPatch:
+			PATCHAlbumTemplate(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+		case http.MethodDelete:
+			DELETEAlbumTemplate(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+		}
+	})
+}
+
+func GETAlbumTemplates(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, authZeroID string) {
+	templates := []m.AlbumTemplate{}
+
+	query := `SELECT ` + albumTemplateColumns + `
+				FROM album_templates t
+				WHERE t.owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)
+				ORDER BY t.created_at`
+
+	rows, err := connPool.Pool.Query(ctx, query, authZeroID)
+	if err != nil {
+		log.Printf("Unable to query album templates: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query album templates")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := scanAlbumTemplate(rows)
+		if err != nil {
+			log.Printf("Unable to scan album template: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to scan album template")
+			return
+		}
+
+		templates = append(templates, template)
+	}
+
+	responseBytes, err := json.MarshalIndent(templates, "", "\t")
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func POSTNewAlbumTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var template m.AlbumTemplate
+
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Invalid request body - could not be mapped to object")
+		log.Printf("Unable to decode album template: %v", err)
+		return
+	}
+
+	status, err := validateAlbumTemplate(ctx, connPool, authZeroID, &template)
+	if err != nil {
+		WriteResponseWithCode(w, status, err.Error())
+		return
+	}
+
+	insertQuery := `INSERT INTO album_templates AS t
+					(owner_id, name_pattern, visibility, guest_ids, group_ids, reveal_offset_hours, recurrence, next_run_at, active)
+					VALUES ((SELECT user_id FROM users WHERE auth_zero_id = $1), $2, $3, $4::text[]::uuid[], $5::text[]::uuid[],
+					        $6, $7, $8, true)
+					RETURNING ` + albumTemplateColumns
+
+	template, err = scanAlbumTemplate(connPool.Pool.QueryRow(ctx, insertQuery, authZeroID, template.NamePattern,
+		template.Visibility, template.GuestIDs, template.GroupIDs, template.RevealOffsetHours, template.Recurrence,
+		template.NextRunAt))
+	if err != nil {
+		log.Printf("Unable to create album template: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create album template")
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(template, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func PATCHAlbumTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var template m.AlbumTemplate
+
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Invalid request body - could not be mapped to object")
+		log.Printf("Unable to decode album template: %v", err)
+		return
+	}
+
+	status, err := validateAlbumTemplate(ctx, connPool, authZeroID, &template)
+	if err != nil {
+		WriteResponseWithCode(w, status, err.Error())
+		return
+	}
+
+	updateQuery := `UPDATE album_templates AS t
+					SET name_pattern = $3, visibility = $4, guest_ids = $5::text[]::uuid[], group_ids = $6::text[]::uuid[],
+						reveal_offset_hours = $7, recurrence = $8, next_run_at = $9, active = $10
+					WHERE t.template_id = $1
+					AND t.owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)
+					RETURNING ` + albumTemplateColumns
+
+	template, err = scanAlbumTemplate(connPool.Pool.QueryRow(ctx, updateQuery, template.TemplateID, authZeroID,
+		template.NamePattern, template.Visibility, template.GuestIDs, template.GroupIDs, template.RevealOffsetHours,
+		template.Recurrence, template.NextRunAt, template.Active))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Album template not found")
+			return
+		}
+		log.Printf("Unable to update album template: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update album template")
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(template, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func DELETEAlbumTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	templateID := r.URL.Query().Get("template_id")
+	if templateID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Template ID not provided")
+		return
+	}
+
+	deleteQuery := `DELETE FROM album_templates
+					WHERE template_id = $1
+					AND owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)`
+
+	tag, err := connPool.Pool.Exec(ctx, deleteQuery, templateID, authZeroID)
+	if err != nil {
+		log.Printf("Unable to delete album template: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to delete album template")
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		WriteResponseWithCode(w, http.StatusNotFound, "Album template not found")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Album template deleted")
+}
+
+// POSTRunAlbumTemplate creates an album from the template right away without moving its schedule
+func POSTRunAlbumTemplate(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, authZeroID string, pushSenders push.Senders) {
+	templateID := r.URL.Query().Get("template_id")
+	if templateID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Template ID not provided")
+		return
+	}
+
+	query := `SELECT ` + albumTemplateColumns + `
+				FROM album_templates t
+				WHERE t.template_id = $1
+				AND t.owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)`
+
+	template, err := scanAlbumTemplate(connPool.Pool.QueryRow(ctx, query, templateID, authZeroID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Album template not found")
+			return
+		}
+		log.Printf("Unable to look up album template: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to look up album template")
+		return
+	}
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error starting transaction")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	album, err := runAlbumTemplate(ctx, tx, template, authZeroID, time.Now().UTC())
+	if err != nil {
+		log.Printf("Unable to create album from template: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create album from template")
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing album template run: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error committing album template run")
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(album, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+// AlbumTemplateScheduler creates the next album for every recurring template that is due, checking every interval
+// until the context is cancelled.
+func AlbumTemplateScheduler(ctx context.Context, connPool *m.PGPool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				ran, err := runNextDueAlbumTemplate(ctx, connPool)
+				if err != nil {
+					log.Printf("Album template scheduler: %v", err)
+					break
+				}
+				if !ran {
+					break
+				}
+			}
+		}
+	}
+}
+
+// runNextDueAlbumTemplate runs a single due template and moves it to its next run. SKIP LOCKED lets several
+// instances of the service run the scheduler without creating the same album twice.
+func runNextDueAlbumTemplate(ctx context.Context, connPool *m.PGPool) (bool, error) {
+	var ownerAuthZeroID string
+
+	dueQuery := `SELECT ` + albumTemplateColumns + `, u.auth_zero_id
+				FROM album_templates t
+				JOIN users u ON u.user_id = t.owner_id
+				WHERE t.active = true
+				AND t.recurrence != 'none'
+				AND t.next_run_at <= now() AT TIME ZONE 'utc'
+				ORDER BY t.next_run_at
+				LIMIT 1
+				FOR UPDATE OF t SKIP LOCKED`
+
+	scheduleQuery := `UPDATE album_templates SET next_run_at = $2 WHERE template_id = $1`
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	template, err := scanAlbumTemplate(tx.QueryRow(ctx, dueQuery), &ownerAuthZeroID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	runAt := *template.NextRunAt
+
+	// Albums are created against the scheduled time so the reveal stays on the same cadence even if the run is late
+	_, err = runAlbumTemplate(ctx, tx, template, ownerAuthZeroID, runAt)
+	if err != nil {
+		return false, fmt.Errorf("template %v: %w", template.TemplateID, err)
+	}
+
+	// Skip any runs that were missed while the service was down rather than creating a backlog of albums
+	nextRun, err := template.NextRun(runAt)
+	for err == nil && !nextRun.After(time.Now().UTC()) {
+		nextRun, err = template.NextRun(nextRun)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, scheduleQuery, template.TemplateID, nextRun)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// runAlbumTemplate creates the album for a single run of the template and records the run on the template, all within
+// tx so a failed run leaves neither a partial album nor a moved schedule behind
+func runAlbumTemplate(ctx context.Context, tx pgx.Tx, template m.AlbumTemplate, ownerAuthZeroID string, runAt time.Time) (m.Album, error) {
+	runQuery := `UPDATE album_templates
+				SET run_count = run_count + 1, last_album_id = $2
+				WHERE template_id = $1`
+
+	album := template.Album(runAt, template.RunCount+1)
+
+	err := CreateAlbum(ctx, tx, ownerAuthZeroID, &album)
+	if err != nil {
+		return album, err
+	}
+
+	_, err = tx.Exec(ctx, runQuery, template.TemplateID, album.AlbumID)
+	if err != nil {
+		return album, err
+	}
+
+	return album, nil
+}
+
+// validateAlbumTemplate fills in the defaults of the template and checks it can be run by its owner, returning the
+// status to respond with when it cannot
+func validateAlbumTemplate(ctx context.Context, connPool *m.PGPool, authZeroID string, template *m.AlbumTemplate) (int, error) {
+	if template.NamePattern == "" {
+		return http.StatusBadRequest, errors.New("Error: Name pattern not provided")
+	}
+
+	switch template.Visibility {
+	case "public", "friends", "private":
+	default:
+		return http.StatusBadRequest, errors.New("Error: Visibility must be public, friends or private")
+	}
+
+	if template.RevealOffsetHours <= 0 {
+		return http.StatusBadRequest, errors.New("Error: Reveal offset must be a positive number of hours")
+	}
+
+	if template.Recurrence == "" {
+		template.Recurrence = "none"
+	}
+
+	if template.Recurrence == "none" {
+		template.NextRunAt = nil
+	} else {
+		nextRun, err := template.NextRun(time.Now().UTC())
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("Error: %v", err)
+		}
+		if template.NextRunAt == nil {
+			template.NextRunAt = &nextRun
+		}
+	}
+
+	if template.GuestIDs == nil {
+		template.GuestIDs = []string{}
+	}
+	if template.GroupIDs == nil {
+		template.GroupIDs = []string{}
+	}
+
+	owned, err := ownsInvitees(ctx, connPool, authZeroID, template.GuestIDs, template.GroupIDs)
+	if err != nil {
+		log.Printf("Unable to check album template guests: %v", err)
+		return http.StatusInternalServerError, errors.New("Unable to check album template guests")
+	}
+	if !owned {
+		return http.StatusBadRequest, errors.New("Error: Guests must be friends and groups must be your own")
+	}
+
+	return http.StatusOK, nil
+}
+
+func scanAlbumTemplate(row pgx.Row, extra ...any) (m.AlbumTemplate, error) {
+	var template m.AlbumTemplate
+
+	dest := []any{&template.TemplateID, &template.TemplateOwner, &template.NamePattern, &template.Visibility,
+		&template.GuestIDs, &template.GroupIDs, &template.RevealOffsetHours, &template.Recurrence, &template.NextRunAt,
+		&template.LastAlbumID, &template.RunCount, &template.Active, &template.CreatedAt}
+
+	err := row.Scan(append(dest, extra...)...)
+	return template, err
+}
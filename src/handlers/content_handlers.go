@@ -8,6 +8,7 @@ import (
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
 	image2 "image"
 	"io"
 	m "last_weekend_services/src/models"
@@ -29,7 +30,7 @@ func ContentEndpointHandler(ctx context.Context, connPool *m.PGPool, gcpStorage
 		case http.MethodGet:
 			switch r.URL.Path {
 			case "/image":
-				ServeImage(ctx, w, r, gcpStorage, liveBucket, stagingBucket)
+				ServeImage(ctx, w, r, connPool, gcpStorage, liveBucket, stagingBucket)
 			case "/upload":
 				GenerateAndSendSignedUrl(w, r, gcpStorage, stagingBucket)
 			}
@@ -71,9 +72,35 @@ func GenerateAndSendSignedUrl(w http.ResponseWriter, r *http.Request, gcpStorage
 	}
 }
 
-func ServeImage(ctx context.Context, w http.ResponseWriter, r *http.Request, gcpStorage storage.Client, liveBucket string, stagingBucket string) {
+func ServeImage(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, gcpStorage storage.Client, liveBucket string, stagingBucket string) {
 	imageId := r.URL.Query().Get("id")
 
+	// Deleted images and the images of deleted albums stay in the bucket until they are purged, so they are hidden
+	// here. IDs that are not images, like the placeholders, are served as before.
+	deletedQuery := `SELECT EXISTS (
+						SELECT 1 FROM images i
+						LEFT JOIN imagealbum ia ON ia.image_id = i.image_id
+						LEFT JOIN albums a ON a.album_id = ia.album_id
+						WHERE i.image_id = $1
+						AND (i.deleted_at IS NOT NULL
+							OR a.deleted_at IS NOT NULL
+							OR EXISTS (SELECT 1 FROM albums c WHERE c.album_cover_id = i.image_id AND c.deleted_at IS NOT NULL)))`
+
+	imageUUID, err := uuid.Parse(strings.Split(imageId, "_")[0])
+	if err == nil {
+		var deleted bool
+		err = connPool.Pool.QueryRow(ctx, deletedQuery, imageUUID).Scan(&deleted)
+		if err != nil {
+			log.Printf("Unable to look up image %v: %v", imageId, err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to look up image")
+			return
+		}
+		if deleted {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
+	}
+
 	obj := gcpStorage.Bucket(liveBucket).Object(imageId)
 	liveReader, err := obj.NewReader(ctx)
 	if err != nil {
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPool connects to the database in TEST_DATABASE_URL, which has to have the schema of the service. Tests that need
+// a database are skipped without one.
+func testPool(tb testing.TB) *m.PGPool {
+	tb.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	pool, err := pgxpool.New(context.Background(), databaseURL)
+	if err != nil {
+		tb.Fatalf("connect: %v", err)
+	}
+	tb.Cleanup(pool.Close)
+
+	return &m.PGPool{Pool: pool}
+}
+
+// seedUser inserts a user and removes it and everything it owns once the test is done. It returns the user ID and the
+// auth zero ID.
+func seedUser(tb testing.TB, connPool *m.PGPool, firstName string) (string, string) {
+	tb.Helper()
+	ctx := context.Background()
+
+	authZeroID := "test|" + uuid.NewString()
+	var userID string
+
+	err := connPool.Pool.QueryRow(ctx, `INSERT INTO users (first_name, last_name, auth_zero_id, email, tsv_fullname, tsv_email)
+					VALUES ($1, 'Test', $2, $2 || '@example.com', to_tsvector($1), to_tsvector($2)) RETURNING user_id`,
+		firstName, authZeroID).Scan(&userID)
+	if err != nil {
+		tb.Fatalf("seed user: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		cleanupQueries := []string{
+			`DELETE FROM firebase_tokens WHERE user_id = $1`,
+			`DELETE FROM friend_requests WHERE sender_id = $1 OR receiver_id = $1`,
+			`DELETE FROM friends WHERE user1_id = $1 OR user2_id = $1`,
+			`DELETE FROM likes WHERE user_id = $1 OR image_id IN (SELECT image_id FROM images WHERE image_owner = $1)`,
+			`DELETE FROM imagealbum WHERE image_id IN (SELECT image_id FROM images WHERE image_owner = $1)`,
+			`DELETE FROM album_requests WHERE invited_id = $1 OR album_id IN (SELECT album_id FROM albums WHERE album_owner = $1)`,
+			`DELETE FROM albumuser WHERE user_id = $1 OR album_id IN (SELECT album_id FROM albums WHERE album_owner = $1)`,
+			`DELETE FROM albums WHERE album_owner = $1`,
+			`DELETE FROM images WHERE image_owner = $1`,
+			`DELETE FROM users WHERE user_id = $1`,
+		}
+		for _, query := range cleanupQueries {
+			_, err := connPool.Pool.Exec(ctx, query, userID)
+			if err != nil {
+				tb.Errorf("clean up user %v: %v", userID, err)
+			}
+		}
+	})
+
+	return userID, authZeroID
+}
+
+// seedFriends makes the two users friends
+func seedFriends(tb testing.TB, connPool *m.PGPool, userID string, friendID string) {
+	tb.Helper()
+
+	_, err := connPool.Pool.Exec(context.Background(), `INSERT INTO friends (user1_id, user2_id) VALUES ($1, $2)`,
+		userID, friendID)
+	if err != nil {
+		tb.Fatalf("seed friendship: %v", err)
+	}
+}
+
+// seedPushToken registers a device of the user for FCM pushes
+func seedPushToken(tb testing.TB, connPool *m.PGPool, userID string) string {
+	tb.Helper()
+
+	token := "token-" + uuid.NewString()
+	_, err := connPool.Pool.Exec(context.Background(), `INSERT INTO firebase_tokens (user_id, token, device_id, provider)
+					VALUES ($1, $2, $3, 'fcm')`, userID, token, "device-"+uuid.NewString())
+	if err != nil {
+		tb.Fatalf("seed push token: %v", err)
+	}
+	return token
+}
+
+func TestNotificationAllowed(t *testing.T) {
+	ctx := context.Background()
+	connPool := testPool(t)
+
+	ownerID, _ := seedUser(t, connPool, "Ana")
+	albumID := seedAlbums(t, connPool, ownerID, nil, 1, 0)[0].AlbumID
+	likeType := string(events.ImageLikedType)
+
+	at := func(hour int, minute int) time.Time { return time.Date(2024, 5, 17, hour, minute, 0, 0, time.UTC) }
+	quietHours := func(timezone string) string {
+		return `INSERT INTO user_notification_settings (user_id, timezone, quiet_hours_start, quiet_hours_end)
+				VALUES ($1, '` + timezone + `', '22:00', '07:00')`
+	}
+	mute := func(until string) string {
+		return `INSERT INTO album_mutes (user_id, album_id, muted_until) VALUES ($1, $2, ` + until + `)`
+	}
+
+	notifications := []struct {
+		name    string
+		setup   string
+		channel string
+		albumID string
+		at      time.Time
+		allowed bool
+	}{
+		{"enabled by default", "", PushChannel, albumID, at(12, 0), true},
+		{"turned off", `INSERT INTO notification_preferences (user_id, type, channel, enabled)
+				VALUES ($1, '` + likeType + `', 'push', false)`, PushChannel, albumID, at(12, 0), false},
+		{"turned off on another channel", `INSERT INTO notification_preferences (user_id, type, channel, enabled)
+				VALUES ($1, '` + likeType + `', 'email', false)`, PushChannel, albumID, at(12, 0), true},
+		{"before quiet hours", quietHours("UTC"), PushChannel, albumID, at(21, 59), true},
+		{"quiet hours before midnight", quietHours("UTC"), PushChannel, albumID, at(23, 30), false},
+		{"quiet hours after midnight", quietHours("UTC"), PushChannel, albumID, at(6, 59), false},
+		{"after quiet hours", quietHours("UTC"), PushChannel, albumID, at(7, 0), true},
+		{"quiet hours only hold back pushes", quietHours("UTC"), InAppChannel, albumID, at(23, 30), true},
+		// 03:00 UTC is 23:00 and 23:30 UTC is 19:30 in New York
+		{"quiet hours in the timezone", quietHours("America/New_York"), PushChannel, albumID, at(3, 0), false},
+		{"outside quiet hours in the timezone", quietHours("America/New_York"), PushChannel, albumID, at(23, 30), true},
+		{"muted album", mute("NULL"), PushChannel, albumID, at(12, 0), false},
+		{"muted album on every channel", mute("NULL"), InAppChannel, albumID, at(12, 0), false},
+		{"mute not expired", mute("'2024-05-17 13:00:00+00'"), PushChannel, albumID, at(12, 0), false},
+		{"mute expired", mute("'2024-05-17 11:00:00+00'"), PushChannel, albumID, at(12, 0), true},
+		{"mute outside of the album", mute("NULL"), PushChannel, "", at(12, 0), true},
+	}
+
+	for _, notification := range notifications {
+		t.Run(notification.name, func(t *testing.T) {
+			userID, _ := seedUser(t, connPool, "Ben")
+			if notification.setup != "" {
+				// Only the mutes are of an album
+				args := []interface{}{userID}
+				if strings.Contains(notification.setup, "$2") {
+					args = append(args, albumID)
+				}
+
+				_, err := connPool.Pool.Exec(ctx, notification.setup, args...)
+				if err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+
+			allowed, err := notificationAllowedAt(ctx, connPool, userID, likeType, notification.channel,
+				notification.albumID, notification.at)
+			if err != nil {
+				t.Fatalf("allowed: %v", err)
+			}
+			if allowed != notification.allowed {
+				t.Errorf("allowed = %v, want %v", allowed, notification.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"last_weekend_services/src/email"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"log"
+	"time"
+)
+
+// WeeklyDigestType is the preference type of the weekly digest email, it is not an event
+const WeeklyDigestType events.Type = "digest.weekly"
+
+// Users are sent at most one digest per interval, covering the activity of the interval
+const digestInterval = 7 * 24 * time.Hour
+
+// emailRecipient is who an email goes to. Users with a registered device are reached by push instead.
+type emailRecipient struct {
+	email      string
+	firstName  string
+	hasDevices bool
+}
+
+func queryEmailRecipient(ctx context.Context, connPool *m.PGPool, userID string) (emailRecipient, error) {
+	var recipient emailRecipient
+
+	recipientQuery := `SELECT COALESCE(u.email, ''), u.first_name,
+							EXISTS (SELECT 1 FROM firebase_tokens t WHERE t.user_id = u.user_id)
+						FROM users u
+						WHERE u.user_id = $1`
+
+	err := connPool.Pool.QueryRow(ctx, recipientQuery, userID).Scan(&recipient.email, &recipient.firstName,
+		&recipient.hasDevices)
+	return recipient, err
+}
+
+// EmailEventSubscriber emails invites and friend requests to users who have not installed the app, so they learn
+// about them at all. Users with a device get the push instead.
+func EmailEventSubscriber(connPool *m.PGPool, emailSender email.Sender, appURL string) events.Handler {
+	send := func(ctx context.Context, userID string, notificationType events.Type, albumID string, render func(recipient emailRecipient) (email.Message, error)) error {
+		recipient, err := queryEmailRecipient(ctx, connPool, userID)
+		if err != nil {
+			return err
+		}
+		if recipient.email == "" || recipient.hasDevices {
+			return nil
+		}
+
+		allowed, err := NotificationAllowed(ctx, connPool, userID, string(notificationType), EmailChannel, albumID)
+		if err != nil || !allowed {
+			return err
+		}
+
+		message, err := render(recipient)
+		if err != nil {
+			return err
+		}
+
+		return emailSender.Send(ctx, message)
+	}
+
+	return func(ctx context.Context, message events.Message) error {
+		switch e := message.Event.(type) {
+		case events.AlbumInviteSent:
+			return send(ctx, e.Request.GuestID, events.AlbumInviteSentType, e.Request.AlbumID,
+				func(recipient emailRecipient) (email.Message, error) {
+					return email.RenderAlbumInvite(recipient.email, email.AlbumInvite{
+						RecipientName: recipient.firstName,
+						OwnerName:     e.Request.OwnerFirst,
+						AlbumName:     e.Request.AlbumName,
+						Link:          appURL + "/album/" + e.Request.AlbumID,
+					})
+				})
+		case events.FriendRequestSent:
+			return send(ctx, e.Request.ReceiverID, events.FriendRequestSentType, "",
+				func(recipient emailRecipient) (email.Message, error) {
+					return email.RenderFriendRequest(recipient.email, email.FriendRequest{
+						RecipientName: recipient.firstName,
+						SenderName:    e.Request.FirstName,
+						Link:          appURL + "/friends",
+					})
+				})
+		}
+
+		return nil
+	}
+}
+
+// WeeklyDigestJob emails every user whose last digest is older than digestInterval a digest of the activity in their
+// albums, checking every interval until the context is cancelled.
+func WeeklyDigestJob(ctx context.Context, connPool *m.PGPool, emailSender email.Sender, appURL string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := sendDueDigests(ctx, connPool, emailSender, appURL)
+			if err != nil {
+				log.Printf("Weekly digest: unable to send digests: %v", err)
+			}
+		}
+	}
+}
+
+// digestBatchSize is how many users are claimed at once
+const digestBatchSize = 100
+
+// sendDueDigests claims the users that are due in batches and sends their digests. A claimed user is not claimed again
+// for digestInterval, even when their digest could not be sent.
+func sendDueDigests(ctx context.Context, connPool *m.PGPool, emailSender email.Sender, appURL string) error {
+	claimQuery := `INSERT INTO email_digests (user_id, last_sent_at)
+					SELECT u.user_id, $1
+					FROM users u
+					LEFT JOIN email_digests d ON d.user_id = u.user_id
+					WHERE COALESCE(u.email, '') != ''
+					AND (d.last_sent_at IS NULL OR d.last_sent_at < $2)
+					LIMIT $3
+					ON CONFLICT (user_id) DO UPDATE
+					SET last_sent_at = EXCLUDED.last_sent_at
+					WHERE email_digests.last_sent_at < $2
+					RETURNING user_id`
+
+	for {
+		var userIDs []string
+		now := time.Now().UTC()
+
+		rows, err := connPool.Pool.Query(ctx, claimQuery, now, now.Add(-digestInterval), digestBatchSize)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var userID string
+
+			err = rows.Scan(&userID)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			userIDs = append(userIDs, userID)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+
+		for _, userID := range userIDs {
+			err = sendDigest(ctx, connPool, emailSender, appURL, userID, now.Add(-digestInterval))
+			if err != nil {
+				log.Printf("Weekly digest: unable to send digest to %v: %v", userID, err)
+			}
+		}
+
+		if len(userIDs) < digestBatchSize {
+			return nil
+		}
+	}
+}
+
+// sendDigest emails the user the activity in their albums since the given time, nothing is sent if there was none
+func sendDigest(ctx context.Context, connPool *m.PGPool, emailSender email.Sender, appURL string, userID string, since time.Time) error {
+	digest := email.Digest{Link: appURL}
+
+	allowed, err := NotificationAllowed(ctx, connPool, userID, string(WeeklyDigestType), EmailChannel, "")
+	if err != nil || !allowed {
+		return err
+	}
+
+	recipient, err := queryEmailRecipient(ctx, connPool, userID)
+	if err != nil {
+		return err
+	}
+	digest.RecipientName = recipient.firstName
+
+	activityQuery := `SELECT a.album_id, a.album_name,
+							(SELECT count(*)
+							 FROM imagealbum ia
+							 JOIN images i ON i.image_id = ia.image_id
+							 WHERE ia.album_id = a.album_id
+							 AND i.deleted_at IS NULL
+							 AND i.created_at >= $2
+							 AND i.image_owner != $1),
+							count(n.notification_id) FILTER (WHERE n.type = 'image.liked'),
+							count(n.notification_id) FILTER (WHERE n.type = 'image.upvoted'),
+							count(n.notification_id) FILTER (WHERE n.type = 'comment.added')
+						FROM albumuser au
+						JOIN albums a ON a.album_id = au.album_id AND a.deleted_at IS NULL
+						LEFT JOIN user_notifications n ON n.album_id = a.album_id
+							AND n.recipient_id = $1
+							AND n.created_at >= $2
+						WHERE au.user_id = $1
+						AND NOT EXISTS (SELECT 1
+										FROM album_mutes am
+										WHERE am.user_id = $1
+										AND am.album_id = a.album_id
+										AND (am.muted_until IS NULL OR am.muted_until > (now() AT TIME ZONE 'utc'::text)))
+						GROUP BY a.album_id, a.album_name
+						ORDER BY a.album_name`
+
+	rows, err := connPool.Pool.Query(ctx, activityQuery, userID, since)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var albumID string
+		var album email.DigestAlbum
+
+		err = rows.Scan(&albumID, &album.AlbumName, &album.NewPhotos, &album.Likes, &album.Upvotes, &album.Comments)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if album.NewPhotos+album.Likes+album.Upvotes+album.Comments == 0 {
+			continue
+		}
+		album.Link = appURL + "/album/" + albumID
+		digest.Albums = append(digest.Albums, album)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	if len(digest.Albums) == 0 {
+		return nil
+	}
+
+	digest.Unread, err = queryUnreadCount(ctx, connPool, userID)
+	if err != nil {
+		return err
+	}
+
+	message, err := email.RenderDigest(recipient.email, digest)
+	if err != nil {
+		return err
+	}
+
+	return emailSender.Send(ctx, message)
+}
@@ -0,0 +1,612 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"last_weekend_services/src/email"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
+	"time"
+)
+
+// RegisterEventSubscribers attaches the WebSocket, push notification, email, notification persistence and inbox
+// subscribers to the bus. Every subscriber is wrapped so it handles a given event at most once. Emails are only sent
+// when an email sender is configured.
+func RegisterEventSubscribers(bus events.Subscriber, connPool *m.PGPool, rdb *redis.Client, pushSenders push.Senders, emailSender email.Sender, appURL string) {
+	bus.Subscribe("websocket", events.Idempotent(rdb, "websocket", WebSocketEventSubscriber(connPool, rdb)))
+	bus.Subscribe("inbox", events.Idempotent(rdb, "inbox", InboxEventSubscriber(connPool)))
+
+	bus.Subscribe("push", events.Idempotent(rdb, "push", PushEventSubscriber(connPool, pushSenders)),
+		events.FriendRequestSentType, events.FriendRequestAcceptedType, events.AlbumInviteSentType,
+		events.AlbumInviteAcceptedType, events.ImageLikedType, events.ImageUnlikedType, events.ImageUpvotedType,
+		events.ImageUpvoteRemovedType, events.CommentAddedType, events.CommentDeletedType, events.ImageUploadedType,
+		events.AlbumRevealedType)
+
+	if emailSender != nil {
+		bus.Subscribe("email", events.Idempotent(rdb, "email", EmailEventSubscriber(connPool, emailSender, appURL)),
+			events.FriendRequestSentType, events.AlbumInviteSentType)
+	}
+
+	bus.Subscribe("notifications", events.Idempotent(rdb, "notifications", NotificationEventSubscriber(connPool)),
+		events.ImageLikedType, events.ImageUnlikedType, events.ImageUpvotedType, events.ImageUpvoteRemovedType)
+}
+
+type channelPayload struct {
+	channel string
+	payload WebSocketPayload
+	// notifies is set on the payloads that notify a user, they are only sent if the user wants in-app notifications
+	// of the event type from the album
+	notifies bool
+	albumID  string
+}
+
+// userNotification is a payload on the user's channel that notifies them of the event
+func userNotification(userID string, albumID string, payload WebSocketPayload) channelPayload {
+	return channelPayload{channel: UserChannel(userID), payload: payload, notifies: true, albumID: albumID}
+}
+
+// WebSocketEventSubscriber publishes events to the Redis channels the WebSocket connections listen on
+func WebSocketEventSubscriber(connPool *m.PGPool, rdb *redis.Client) events.Handler {
+	return func(ctx context.Context, message events.Message) error {
+		for _, out := range webSocketPayloadsForEvent(message.Event) {
+			out.payload.EventID = message.ID
+
+			if out.notifies {
+				allowed, err := NotificationAllowed(ctx, connPool, out.payload.UserID, string(message.Event.EventType()),
+					InAppChannel, out.albumID)
+				if err != nil {
+					return err
+				}
+				if !allowed {
+					continue
+				}
+			}
+
+			if _, isAccessChannel := albumIDFromAccessChannel(out.channel); isAccessChannel {
+				// Access changes only trigger a re-check on live connections, there is nothing to replay
+				err := PublishLiveWebSocketPayload(ctx, rdb, out.channel, out.payload)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			err := PublishWebSocketPayload(ctx, rdb, out.channel, out.payload)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func webSocketPayloadsForEvent(event events.Event) []channelPayload {
+	switch e := event.(type) {
+	case events.ImageLiked:
+		return engagementPayloads(`ADD`, `liked`, e.Notification, true)
+	case events.ImageUnliked:
+		return engagementPayloads(`REMOVE`, `liked`, e.Notification, false)
+	case events.ImageUpvoted:
+		return engagementPayloads(`ADD`, `upvote`, e.Notification, true)
+	case events.ImageUpvoteRemoved:
+		return engagementPayloads(`REMOVE`, `upvote`, e.Notification, false)
+	case events.CommentAdded:
+		return commentPayloads(`ADD`, e.Comment)
+	case events.CommentEdited:
+		return commentPayloads(`UPDATE`, e.Comment)
+	case events.CommentDeleted:
+		return commentPayloads(`REMOVE`, e.Comment)
+	case events.FriendRequestSent:
+		return []channelPayload{userNotification(e.Request.ReceiverID, "", WebSocketPayload{
+			Operation: "REQUEST",
+			Type:      "friend-request",
+			UserID:    e.Request.ReceiverID,
+			Payload:   e.Request,
+		})}
+	case events.FriendRequestAccepted:
+		return []channelPayload{userNotification(e.Request.SenderID, "", WebSocketPayload{
+			Operation: "ACCEPTED",
+			Type:      "friend-request",
+			UserID:    e.Request.SenderID,
+			Payload:   e.Request,
+		})}
+	case events.AlbumInviteSent:
+		return []channelPayload{userNotification(e.Request.GuestID, e.Request.AlbumID, WebSocketPayload{
+			Operation: "REQUEST",
+			Type:      "album-invite",
+			UserID:    e.Request.GuestID,
+			Payload:   e.Request,
+		})}
+	case events.AlbumInviteAccepted:
+		return albumResponsePayloads("ACCEPTED", e.Request, e.GuestIDs)
+	case events.AlbumInviteDenied:
+		return albumResponsePayloads("DENIED", e.Request, e.GuestIDs)
+	case events.NotificationsRead:
+		return []channelPayload{{channel: UserChannel(e.UserID), payload: WebSocketPayload{
+			Operation: "READ",
+			Type:      "notifications",
+			UserID:    e.UserID,
+			Payload:   e,
+		}}}
+	case events.AlbumAccessChanged:
+		return []channelPayload{{channel: AlbumAccessChannel(e.AlbumID), payload: WebSocketPayload{
+			Operation: "ACCESS_CHANGED",
+			Type:      "album",
+			AlbumID:   e.AlbumID,
+		}}}
+	}
+
+	return nil
+}
+
+// engagementPayloads builds the album update for an engagement, toOwner also sends it to the content owner
+func engagementPayloads(operation string, notificationType string, notification m.EngagementNotification, toOwner bool) []channelPayload {
+	payload := WebSocketPayload{
+		Operation: operation,
+		Type:      notificationType,
+		UserID:    notification.ReceiverID,
+		AlbumID:   notification.AlbumID,
+		Payload:   notification,
+	}
+
+	if toOwner {
+		return []channelPayload{userNotification(notification.ReceiverID, notification.AlbumID, payload),
+			{channel: notification.AlbumID, payload: payload}}
+	}
+	return []channelPayload{{channel: notification.AlbumID, payload: payload}}
+}
+
+// commentPayloads sends a comment change to the album and to the owner of the image, who is notified of new comments
+func commentPayloads(operation string, comment m.Comment) []channelPayload {
+	payload := WebSocketPayload{
+		Operation: operation,
+		Type:      `comment`,
+		UserID:    comment.ImageOwner,
+		AlbumID:   comment.AlbumID,
+		Payload:   comment,
+	}
+
+	toOwner := channelPayload{channel: UserChannel(payload.UserID), payload: payload}
+	if operation == `ADD` {
+		toOwner = userNotification(payload.UserID, comment.AlbumID, payload)
+	}
+
+	return []channelPayload{toOwner, {channel: comment.AlbumID, payload: payload}}
+}
+
+func albumResponsePayloads(operation string, request m.AlbumRequestNotification, guestIDs []string) []channelPayload {
+	var payloads []channelPayload
+
+	for _, guestID := range guestIDs {
+		payloads = append(payloads, userNotification(guestID, request.AlbumID, WebSocketPayload{
+			Operation: operation,
+			Type:      "album-invite",
+			UserID:    guestID,
+			Payload:   request,
+		}))
+	}
+
+	return payloads
+}
+
+// Engagement and upload pushes are collapsed per window, so a burst replaces itself on the device
+const pushSummaryWindow = time.Hour
+
+// PushEventSubscriber sends a push notification for the events a user should be alerted about
+func PushEventSubscriber(connPool *m.PGPool, pushSenders push.Senders) events.Handler {
+	engagement := func(notification m.EngagementNotification, notificationType events.Type) pushEngagement {
+		return pushEngagement{notification.ReceiverID, notification.NotifierID, notification.AlbumID,
+			notification.ImageID, notification.ImageID, notificationType}
+	}
+
+	return func(ctx context.Context, message events.Message) error {
+		switch e := message.Event.(type) {
+		case events.ImageLiked:
+			return pushEngagementSummary(ctx, connPool, pushSenders, message.ID,
+				engagement(e.Notification, events.ImageLikedType))
+		case events.ImageUnliked:
+			return removePushEngagement(ctx, connPool, events.ImageLikedType, e.Notification.ImageID,
+				e.Notification.NotifierID)
+		case events.ImageUpvoted:
+			return pushEngagementSummary(ctx, connPool, pushSenders, message.ID,
+				engagement(e.Notification, events.ImageUpvotedType))
+		case events.ImageUpvoteRemoved:
+			return removePushEngagement(ctx, connPool, events.ImageUpvotedType, e.Notification.ImageID,
+				e.Notification.NotifierID)
+		case events.CommentAdded:
+			return pushEngagementSummary(ctx, connPool, pushSenders, message.ID, pushEngagement{e.Comment.ImageOwner,
+				e.Comment.UserID, e.Comment.AlbumID, e.Comment.ImageID, e.Comment.ID, events.CommentAddedType})
+		case events.CommentDeleted:
+			return removePushEngagement(ctx, connPool, events.CommentAddedType, e.Comment.ID, e.Comment.UserID)
+		case events.FriendRequestSent:
+			return SendFirebaseMessageToUID(ctx, connPool, pushSenders, m.FirebaseNotification{
+				RecipientID:    e.Request.ReceiverID,
+				NotificationID: e.Request.RequestID,
+				ContentName:    e.Request.FirstName,
+				RequesterID:    e.Request.SenderID,
+				RequesterName:  e.Request.FirstName,
+				Type:           push.FriendRequest,
+				Category:       string(events.FriendRequestSentType),
+			})
+		case events.FriendRequestAccepted:
+			return SendFirebaseMessageToUID(ctx, connPool, pushSenders, m.FirebaseNotification{
+				RecipientID:    e.Request.SenderID,
+				NotificationID: e.Request.RequestID,
+				RequesterID:    e.Request.ReceiverID,
+				RequesterName:  e.Request.FirstName,
+				Type:           push.FriendRequestAccepted,
+				Category:       string(events.FriendRequestAcceptedType),
+			})
+		case events.AlbumInviteSent:
+			return SendFirebaseMessageToUID(ctx, connPool, pushSenders, m.FirebaseNotification{
+				RecipientID:    e.Request.GuestID,
+				NotificationID: e.Request.AlbumID,
+				ContentName:    e.Request.AlbumName,
+				RequesterID:    e.Request.AlbumOwner,
+				RequesterName:  e.Request.OwnerFirst,
+				Type:           push.AlbumInvite,
+				Category:       string(events.AlbumInviteSentType),
+				AlbumID:        e.Request.AlbumID,
+			})
+		case events.AlbumInviteAccepted:
+			return pushToMembers(ctx, connPool, pushSenders, message.ID, e.GuestIDs, e.Request.GuestID, m.FirebaseNotification{
+				NotificationID: e.Request.RequestID,
+				ContentName:    e.Request.AlbumName,
+				RequesterID:    e.Request.GuestID,
+				RequesterName:  e.Request.GuestFirst,
+				Type:           push.AlbumInviteAccepted,
+				Category:       string(events.AlbumInviteAcceptedType),
+				AlbumID:        e.Request.AlbumID,
+			})
+		case events.ImageUploaded:
+			return pushToMembers(ctx, connPool, pushSenders, message.ID, e.MemberIDs, e.Image.ImageOwner, m.FirebaseNotification{
+				NotificationID: e.Image.ID,
+				ContentName:    e.AlbumName,
+				RequesterID:    e.Image.ImageOwner,
+				RequesterName:  e.Image.FirstName,
+				Type:           push.ImageUploaded,
+				Category:       string(events.ImageUploadedType),
+				AlbumID:        e.AlbumID,
+				ImageID:        e.Image.ID,
+				CollapseKey: fmt.Sprintf("%v:%v:%v:%v", e.AlbumID, events.ImageUploadedType, e.Image.ImageOwner,
+					time.Now().UTC().Truncate(pushSummaryWindow).Unix()),
+			})
+		case events.AlbumRevealed:
+			return pushToMembers(ctx, connPool, pushSenders, message.ID, e.MemberIDs, "", m.FirebaseNotification{
+				NotificationID: e.AlbumID,
+				ContentName:    e.AlbumName,
+				Type:           push.AlbumRevealed,
+				Category:       string(events.AlbumRevealedType),
+				AlbumID:        e.AlbumID,
+			})
+		}
+
+		return nil
+	}
+}
+
+// pushToMembers sends the notification to every member except the actor. A member whose push fails does not hold up
+// the others, the first error is returned once all were tried. The members it reached are recorded under sourceKey,
+// so when the event is redelivered only the failed ones are pushed again.
+func pushToMembers(ctx context.Context, connPool *m.PGPool, pushSenders push.Senders, sourceKey string, memberIDs []string, actorID string, notification m.FirebaseNotification) error {
+	var firstErr error
+
+	deliveredQuery := `SELECT recipient_id::text FROM push_deliveries WHERE source_key = $1`
+	recordQuery := `INSERT INTO push_deliveries (source_key, recipient_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	rows, err := connPool.Pool.Query(ctx, deliveredQuery, sourceKey)
+	if err != nil {
+		return err
+	}
+	deliveredIDs, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	delivered := make(map[string]bool, len(deliveredIDs))
+	for _, deliveredID := range deliveredIDs {
+		delivered[deliveredID] = true
+	}
+
+	for _, memberID := range memberIDs {
+		if memberID == actorID || delivered[memberID] {
+			continue
+		}
+
+		notification.RecipientID = memberID
+		err := SendFirebaseMessageToUID(ctx, connPool, pushSenders, notification)
+		if err == nil {
+			_, err = connPool.Pool.Exec(ctx, recordQuery, sourceKey, memberID)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// pushEngagement is a like, upvote or comment to summarize in a push, subjectID is the image or the comment
+type pushEngagement struct {
+	recipientID      string
+	actorID          string
+	albumID          string
+	imageID          string
+	subjectID        string
+	notificationType events.Type
+}
+
+// pushEngagementSummary records the engagement and sends the recipient the summary of the album's engagement of its
+// type in the current window. The collapse key is the same for the whole window, so the device only shows the latest
+// summary. The summary is built from push_engagements, so it does not depend on the recipient's in-app inbox.
+func pushEngagementSummary(ctx context.Context, connPool *m.PGPool, pushSenders push.Senders, sourceKey string, engagement pushEngagement) error {
+	var albumName string
+	var photoTotal, actorTotal int
+	var actorIDs, actorNames []string
+
+	if engagement.recipientID == engagement.actorID {
+		return nil
+	}
+
+	windowStart := time.Now().UTC().Truncate(pushSummaryWindow)
+
+	insertQuery := `INSERT INTO push_engagements (source_key, recipient_id, actor_id, type, album_id, image_id, subject_id)
+					VALUES ($1, $2, $3, $4, $5, $6, $7)
+					ON CONFLICT (source_key) DO NOTHING`
+
+	// Earlier windows are never summarized again
+	pruneQuery := `DELETE FROM push_engagements WHERE recipient_id = $1 AND created_at < $2`
+
+	summaryQuery := `SELECT a.album_name, COUNT(DISTINCT e.image_id), COUNT(DISTINCT e.actor_id),
+							array_agg(e.actor_id::text ORDER BY e.created_at DESC),
+							array_agg(u.first_name ORDER BY e.created_at DESC)
+					FROM push_engagements e
+					JOIN albums a ON a.album_id = e.album_id
+					JOIN users u ON u.user_id = e.actor_id
+					WHERE e.recipient_id = $1
+					AND e.album_id = $2
+					AND e.type = $3
+					AND e.created_at >= $4
+					AND a.deleted_at IS NULL
+					GROUP BY a.album_name`
+
+	_, err := connPool.Pool.Exec(ctx, insertQuery, sourceKey, engagement.recipientID, engagement.actorID,
+		string(engagement.notificationType), engagement.albumID, engagement.imageID, engagement.subjectID)
+	if err != nil {
+		return err
+	}
+
+	_, err = connPool.Pool.Exec(ctx, pruneQuery, engagement.recipientID, windowStart)
+	if err != nil {
+		return err
+	}
+
+	err = connPool.Pool.QueryRow(ctx, summaryQuery, engagement.recipientID, engagement.albumID,
+		string(engagement.notificationType), windowStart).Scan(&albumName, &photoTotal, &actorTotal, &actorIDs, &actorNames)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	actors := summaryActors(actorIDs, actorNames)
+
+	notification := m.FirebaseNotification{
+		RecipientID:    engagement.recipientID,
+		NotificationID: engagement.albumID,
+		ContentName:    albumName,
+		RequesterID:    engagement.actorID,
+		RequesterName:  actors[0].FirstName,
+		Count:          photoTotal,
+		Type:           push.EngagementSummary,
+		CollapseKey:    fmt.Sprintf("%v:%v:%v", engagement.albumID, engagement.notificationType, windowStart.Unix()),
+		Category:       string(engagement.notificationType),
+		AlbumID:        engagement.albumID,
+	}
+	if actorTotal > 1 && len(actors) > 1 {
+		notification.OtherName = actors[1].FirstName
+		notification.Others = actorTotal - 2
+	}
+	// A summary of a single photo opens it
+	if photoTotal == 1 {
+		notification.ImageID = engagement.imageID
+	}
+
+	return SendFirebaseMessageToUID(ctx, connPool, pushSenders, notification)
+}
+
+// removePushEngagement takes a withdrawn like, upvote or comment out of the summaries still to be sent
+func removePushEngagement(ctx context.Context, connPool *m.PGPool, notificationType events.Type, subjectID string, actorID string) error {
+	removeQuery := `DELETE FROM push_engagements WHERE type = $1 AND subject_id = $2 AND actor_id = $3`
+
+	_, err := connPool.Pool.Exec(ctx, removeQuery, string(notificationType), subjectID, actorID)
+	return err
+}
+
+// NotificationEventSubscriber keeps the notifications table in line with likes and upvotes. The notification ID is
+// chosen when the event is created, so inserting the same event twice is a no-op.
+func NotificationEventSubscriber(connPool *m.PGPool) events.Handler {
+	addNotificationQuery := `INSERT INTO notifications (notification_uid, album_id, media_id, sender_id, receiver_id, type, received_at)
+							VALUES ($1, $2, $3, $4, $5, $6, $7)
+							ON CONFLICT (notification_uid) DO NOTHING`
+
+	removeNotificationQuery := `DELETE FROM notifications
+								WHERE media_id = $1
+								AND sender_id = $2
+								AND type = $3`
+
+	add := func(ctx context.Context, notification m.EngagementNotification) error {
+		// Owners are not notified of engagement on their own content
+		if notification.NotifierID == notification.ReceiverID {
+			return nil
+		}
+
+		_, err := connPool.Pool.Exec(ctx, addNotificationQuery, notification.NotificationID, notification.AlbumID,
+			notification.ImageID, notification.NotifierID, notification.ReceiverID, notification.NotificationType,
+			notification.ReceivedAt)
+		return err
+	}
+
+	remove := func(ctx context.Context, notification m.EngagementNotification) error {
+		_, err := connPool.Pool.Exec(ctx, removeNotificationQuery, notification.ImageID, notification.NotifierID,
+			notification.NotificationType)
+		return err
+	}
+
+	return func(ctx context.Context, message events.Message) error {
+		switch e := message.Event.(type) {
+		case events.ImageLiked:
+			return add(ctx, e.Notification)
+		case events.ImageUpvoted:
+			return add(ctx, e.Notification)
+		case events.ImageUnliked:
+			return remove(ctx, e.Notification)
+		case events.ImageUpvoteRemoved:
+			return remove(ctx, e.Notification)
+		}
+
+		return nil
+	}
+}
+
+// inboxEntry is a notification for a single recipient, subjectID is what later events about the same thing match on
+type inboxEntry struct {
+	recipientID string
+	actorID     string
+	albumID     string
+	subjectID   string
+	payload     interface{}
+}
+
+// InboxEventSubscriber keeps user_notifications in line with every event that notifies a user. Rows are keyed by the
+// event and the recipient, so a redelivered event is not stored twice. Recipients who turned in-app notifications of
+// the type off, or muted the album, are skipped.
+func InboxEventSubscriber(connPool *m.PGPool) events.Handler {
+	insertQuery := `INSERT INTO user_notifications (recipient_id, actor_id, type, album_id, subject_id, source_key, payload)
+					VALUES ($1, NULLIF($2, '')::uuid, $3, NULLIF($4, '')::uuid, $5, $6, $7)
+					ON CONFLICT (source_key) DO NOTHING`
+
+	removeQuery := `DELETE FROM user_notifications
+					WHERE type = $1
+					AND subject_id = $2
+					AND actor_id = $3`
+
+	updateQuery := `UPDATE user_notifications
+					SET payload = $3
+					WHERE type = $1
+					AND subject_id = $2`
+
+	// A request that was answered is no longer something to act on
+	resolveQuery := `UPDATE user_notifications
+					 SET payload = $3, read_at = COALESCE(read_at, (now() AT TIME ZONE 'utc'::text))
+					 WHERE type = $1
+					 AND subject_id = $2`
+
+	insert := func(ctx context.Context, message events.Message, entries ...inboxEntry) error {
+		for _, entry := range entries {
+			// Nobody is notified of their own actions
+			if entry.recipientID == entry.actorID {
+				continue
+			}
+
+			allowed, err := NotificationAllowed(ctx, connPool, entry.recipientID, string(message.Event.EventType()),
+				InAppChannel, entry.albumID)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				continue
+			}
+
+			payload, err := json.Marshal(entry.payload)
+			if err != nil {
+				return err
+			}
+
+			_, err = connPool.Pool.Exec(ctx, insertQuery, entry.recipientID, entry.actorID, string(message.Event.EventType()),
+				entry.albumID, entry.subjectID, message.ID+":"+entry.recipientID, payload)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	update := func(ctx context.Context, query string, notificationType events.Type, subjectID string, payload interface{}) error {
+		jsonPayload, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		_, err = connPool.Pool.Exec(ctx, query, string(notificationType), subjectID, jsonPayload)
+		return err
+	}
+
+	remove := func(ctx context.Context, notificationType events.Type, subjectID string, actorID string) error {
+		_, err := connPool.Pool.Exec(ctx, removeQuery, string(notificationType), subjectID, actorID)
+		return err
+	}
+
+	engagement := func(notification m.EngagementNotification) inboxEntry {
+		return inboxEntry{notification.ReceiverID, notification.NotifierID, notification.AlbumID, notification.ImageID, notification}
+	}
+
+	return func(ctx context.Context, message events.Message) error {
+		switch e := message.Event.(type) {
+		case events.ImageLiked:
+			return insert(ctx, message, engagement(e.Notification))
+		case events.ImageUpvoted:
+			return insert(ctx, message, engagement(e.Notification))
+		case events.ImageUnliked:
+			return remove(ctx, events.ImageLikedType, e.Notification.ImageID, e.Notification.NotifierID)
+		case events.ImageUpvoteRemoved:
+			return remove(ctx, events.ImageUpvotedType, e.Notification.ImageID, e.Notification.NotifierID)
+		case events.CommentAdded:
+			return insert(ctx, message, inboxEntry{e.Comment.ImageOwner, e.Comment.UserID, e.Comment.AlbumID, e.Comment.ID, e.Comment})
+		case events.CommentEdited:
+			return update(ctx, updateQuery, events.CommentAddedType, e.Comment.ID, e.Comment)
+		case events.CommentDeleted:
+			return remove(ctx, events.CommentAddedType, e.Comment.ID, e.Comment.UserID)
+		case events.FriendRequestSent:
+			return insert(ctx, message, inboxEntry{e.Request.ReceiverID, e.Request.SenderID, "", e.Request.RequestID, e.Request})
+		case events.FriendRequestAccepted:
+			err := update(ctx, resolveQuery, events.FriendRequestSentType, e.Request.RequestID, e.Request)
+			if err != nil {
+				return err
+			}
+			return insert(ctx, message, inboxEntry{e.Request.SenderID, e.Request.ReceiverID, "", e.Request.RequestID, e.Request})
+		case events.AlbumInviteSent:
+			return insert(ctx, message, inboxEntry{e.Request.GuestID, e.Request.AlbumOwner, e.Request.AlbumID, e.Request.RequestID, e.Request})
+		case events.AlbumInviteAccepted:
+			err := update(ctx, resolveQuery, events.AlbumInviteSentType, e.Request.RequestID, e.Request)
+			if err != nil {
+				return err
+			}
+			return insert(ctx, message, albumResponseEntries(e.Request, e.GuestIDs)...)
+		case events.AlbumInviteDenied:
+			err := update(ctx, resolveQuery, events.AlbumInviteSentType, e.Request.RequestID, e.Request)
+			if err != nil {
+				return err
+			}
+			return insert(ctx, message, albumResponseEntries(e.Request, e.GuestIDs)...)
+		}
+
+		return nil
+	}
+}
+
+func albumResponseEntries(request m.AlbumRequestNotification, guestIDs []string) []inboxEntry {
+	var entries []inboxEntry
+
+	for _, guestID := range guestIDs {
+		entries = append(entries, inboxEntry{guestID, request.GuestID, request.AlbumID, request.RequestID, request})
+	}
+
+	return entries
+}
@@ -2,16 +2,22 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	m "last_weekend_services/src/models"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/redis/go-redis/v9"
 )
 
-func FeedEndpointHandler(ctx context.Context, connPool *m.PGPool) http.Handler {
+func FeedEndpointHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
 		if !ok {
@@ -21,21 +27,29 @@ func FeedEndpointHandler(ctx context.Context, connPool *m.PGPool) http.Handler {
 
 		switch r.Method {
 		case http.MethodGet:
-			GETAppFeed(ctx, w, connPool, claims.RegisteredClaims.Subject)
+			GETAppFeed(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject)
 		}
 	})
 }
 
-func GETAppFeed(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, uid string) {
-	// Get a list of all user's albums I am interested in
-	// Join that list on the list of albums and users
-	// Join that on the list of albums to return the albums
-	//Friends List: UID -> albumuser UID: Album ID -> albums Album ID: *
+const defaultFeedLimit = 20
 
-	albums := []m.Album{}
+// Feed modes, the feed is ranked unless the client asks for it in chronological order
+const (
+	RankedFeed        = "ranked"
+	ChronologicalFeed = "chronological"
+)
+
+// Weights of the score components of the ranked feed, they add up to 1
+const (
+	affinityWeight   = 0.35
+	recencyWeight    = 0.25
+	phaseWeight      = 0.25
+	engagementWeight = 0.15
+)
 
-	query :=
-		`SELECT a.album_id, a.album_name, a.album_owner, u.first_name, u.last_name, a.created_at, a.revealed_at, a.album_cover_id, a.visibility
+// feedAlbumsQuery is every album in the feed of the user: their own albums and the shared albums of their friends
+const feedAlbumsQuery = `SELECT a.album_id, a.album_name, a.album_owner, u.first_name, u.last_name, a.created_at, a.revealed_at, a.album_cover_id, a.visibility
 			FROM albums a
 			JOIN albumuser au
 			ON a.album_id = au.album_id
@@ -49,7 +63,8 @@ func GETAppFeed(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool,
 					END AS friend_id
 				FROM friends) fl
 			ON au.user_id = fl.friend_id
-			WHERE a.visibility = 'public' OR a.visibility = 'friends'
+			WHERE (a.visibility = 'public' OR a.visibility = 'friends')
+			AND a.deleted_at IS NULL AND a.archived_at IS NULL
 			UNION DISTINCT
 			SELECT a.album_id, a.album_name, a.album_owner, u.first_name, u.last_name, a.created_at, a.revealed_at, a.album_cover_id, a.visibility
 			FROM albums a
@@ -57,50 +72,383 @@ func GETAppFeed(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool,
 			ON a.album_id = au.album_id
 			JOIN users u
 			ON a.album_owner = u.user_id
-			WHERE au.user_id = (SELECT user_id FROM users WHERE auth_zero_id=$1)`
+			WHERE au.user_id = (SELECT user_id FROM users WHERE auth_zero_id=$1)
+			AND a.deleted_at IS NULL AND a.archived_at IS NULL`
+
+// GETAppFeed returns the albums of the user and of their friends, as a page when limit or cursor is given. The feed is
+// ranked by default and newest first with mode=chronological, debug=true adds the score of each album to a ranked
+// feed. The first page of a ranked feed ranks it once and every later page is read from that ranking, so a scroll
+// neither repeats nor skips albums. An empty feed is an empty list.
+func GETAppFeed(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, uid string) {
+	var feed m.Page[m.Album]
+	var err error
+
+	paginated := requestsPage(r)
+
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", RankedFeed:
+		debug, _ := strconv.ParseBool(r.URL.Query().Get("debug"))
+
+		// Zero is the whole feed
+		limit := 0
+		if paginated {
+			limit, err = parseLimit(r, defaultFeedLimit)
+			if err != nil {
+				WriteResponseWithCode(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		cursor := rankedCursor{asOf: time.Now().UTC()}
+		if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+			cursor, err = decodeRankedCursor(cursorParam)
+			if err != nil {
+				WriteResponseWithCode(w, http.StatusBadRequest, errInvalidCursor.Error())
+				return
+			}
+		}
 
-	response, err := connPool.Pool.Query(ctx, query, uid)
+		feed, err = queryRankedFeed(ctx, connPool, rdb, uid, limit, cursor, debug)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Feed SQL Query Failed")
+			log.Printf("Ranked Feed SQL Query Failed: %v", err)
+			return
+		}
+	case ChronologicalFeed:
+		page, err := parseListRequest(r, defaultFeedLimit)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		feed, err = queryChronologicalFeed(ctx, connPool, uid, page)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Feed SQL Query Failed")
+			log.Printf("Feed SQL Query Failed: %v", err)
+			return
+		}
+	default:
+		WriteResponseWithCode(w, http.StatusBadRequest, "mode has to be ranked or chronological")
+		return
+	}
+
+	// Only the albums of the page are filled in
+	err = assembleAlbums(ctx, connPool, feed.Items, uid, false)
 	if err != nil {
-		WriteErrorToWriter(w, "Feed SQL Query Failed")
-		log.Print("Feed SQL Query Failed")
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Feed SQL Query Failed")
+		log.Printf("Unable to assemble feed albums: %v", err)
+		return
 	}
 
+	responseBytes, err := json.MarshalIndent(listBody(paginated, feed), "", "\t")
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to write the feed")
+		log.Printf("Unable to marshal the feed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+// queryChronologicalFeed returns a page of the feed newest first
+func queryChronologicalFeed(ctx context.Context, connPool *m.PGPool, uid string, page pageRequest) (m.Page[m.Album], error) {
+	albums := []m.Album{}
+
+	query := `SELECT * FROM (` + feedAlbumsQuery + `) feed
+		WHERE ($2::timestamptz IS NULL OR (feed.created_at, feed.album_id) < ($2, $3::uuid))
+		ORDER BY feed.created_at DESC, feed.album_id DESC
+		LIMIT $4`
+
+	response, err := connPool.Pool.Query(ctx, query, uid, page.cursorTime, page.cursorID, page.queryLimit())
+	if err != nil {
+		return m.Page[m.Album]{}, err
+	}
+	defer response.Close()
+
 	for response.Next() {
 		var album m.Album
 
 		err := response.Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
 			&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility)
 		if err != nil {
-			WriteErrorToWriter(w, "Scanning SQL response failed")
-			log.Printf("Scanning the response failed with: %v", err)
+			return m.Page[m.Album]{}, err
 		}
 
-		QueryImagesData(ctx, connPool, &album, uid)
-		err = album.PhaseCalculation()
-
 		albums = append(albums, album)
 	}
+	if response.Err() != nil {
+		return m.Page[m.Album]{}, response.Err()
+	}
+
+	return newPage(albums, page, func(album m.Album) (time.Time, string) {
+		return album.CreatedAt, album.AlbumID
+	}), nil
+}
+
+// rankedFeedQuery scores every album of the feed as of $2, the time of the scroll's first page. Each component is
+// between 0 and 1:
+//   - affinity: the user's own albums score 1, friends' albums by the albums the user shares with the owner and the
+//     likes, upvotes and comments between the two, saturating at 20. Only the engagements between the user and the
+//     owners in the feed are read.
+//   - recency: the last upload to the album, or its creation, halving about every 33 hours
+//   - phase: albums still to be revealed, the closer the reveal the higher and at least 0.5, revealed albums fade from
+//     0.3 over a few days
+//   - engagement: the likes and upvotes on the album's images, saturating at 100
+//
+// The ranking is stored as a snapshot that the pages are read from, so only the ranking is run against $2.
+const rankedFeedQuery = `WITH viewer AS (SELECT user_id FROM users WHERE auth_zero_id = $1),
+		feed AS (SELECT * FROM (` + feedAlbumsQuery + `) albums WHERE albums.created_at <= $2::timestamp),
+		owners AS (SELECT DISTINCT album_owner AS owner_id FROM feed WHERE album_owner != (SELECT user_id FROM viewer)),
+		shared AS (
+			SELECT theirs.user_id AS owner_id, count(*) AS albums
+			FROM albumuser mine
+			JOIN albumuser theirs ON theirs.album_id = mine.album_id
+			WHERE mine.user_id = (SELECT user_id FROM viewer)
+			AND theirs.user_id IN (SELECT owner_id FROM owners)
+			GROUP BY theirs.user_id),
+		engagements AS (
+			SELECT l.user_id AS actor_id, i.image_owner AS target_id FROM likes l JOIN images i ON i.image_id = l.image_id
+			WHERE (l.user_id = (SELECT user_id FROM viewer) AND i.image_owner IN (SELECT owner_id FROM owners))
+			OR (i.image_owner = (SELECT user_id FROM viewer) AND l.user_id IN (SELECT owner_id FROM owners))
+			UNION ALL
+			SELECT up.user_id, i.image_owner FROM upvotes up JOIN images i ON i.image_id = up.image_id
+			WHERE (up.user_id = (SELECT user_id FROM viewer) AND i.image_owner IN (SELECT owner_id FROM owners))
+			OR (i.image_owner = (SELECT user_id FROM viewer) AND up.user_id IN (SELECT owner_id FROM owners))
+			UNION ALL
+			SELECT c.commenter_id, i.image_owner FROM comments c JOIN images i ON i.image_id = c.image_id
+			WHERE (c.commenter_id = (SELECT user_id FROM viewer) AND i.image_owner IN (SELECT owner_id FROM owners))
+			OR (i.image_owner = (SELECT user_id FROM viewer) AND c.commenter_id IN (SELECT owner_id FROM owners))),
+		interactions AS (
+			SELECT CASE WHEN e.actor_id = (SELECT user_id FROM viewer) THEN e.target_id ELSE e.actor_id END AS owner_id,
+				count(*) AS interactions
+			FROM engagements e
+			GROUP BY 1),
+		scored AS (
+			SELECT feed.*,
+				CASE
+					WHEN feed.album_owner = (SELECT user_id FROM viewer) THEN 1.0
+					ELSE least(1.0, ln(1 + COALESCE(s.albums, 0) + COALESCE(it.interactions, 0)) / ln(21))
+				END::float8 AS affinity,
+				exp(-least(50, greatest(0, extract(epoch FROM ($2::timestamp - COALESCE(activity.last_upload, feed.created_at)))) / 172800.0))::float8 AS recency,
+				CASE
+					WHEN feed.revealed_at > $2::timestamp
+						THEN greatest(0.5, 1 - extract(epoch FROM (feed.revealed_at - $2::timestamp)) / 604800.0)
+					ELSE 0.3 * exp(-least(50, extract(epoch FROM ($2::timestamp - feed.revealed_at)) / 259200.0))
+				END::float8 AS phase,
+				least(1.0, ln(1 + activity.engagement) / ln(101))::float8 AS engagement
+			FROM feed
+			LEFT JOIN shared s ON s.owner_id = feed.album_owner
+			LEFT JOIN interactions it ON it.owner_id = feed.album_owner
+			LEFT JOIN LATERAL (
+				SELECT max(i.created_at) AS last_upload, COALESCE(sum(i.like_count + i.upvote_count), 0) AS engagement
+				FROM imagealbum ia
+				JOIN images i ON i.image_id = ia.image_id
+				WHERE ia.album_id = feed.album_id
+				AND i.created_at <= $2::timestamp
+				AND i.deleted_at IS NULL) activity ON true),
+		ranked AS (
+			SELECT scored.*,
+				$3::float8 * affinity + $4::float8 * recency + $5::float8 * phase + $6::float8 * engagement AS score
+			FROM scored)
+		SELECT album_id, score, affinity, recency, phase, engagement
+		FROM ranked
+		ORDER BY score DESC, album_id DESC`
+
+const (
+	rankedSnapshotKeyPrefix = "feed:ranked:"
+	// A scroll that is left for longer is ranked again as of its time when it continues
+	rankedSnapshotTTL = 30 * time.Minute
+)
+
+// rankedEntry is an album of a ranked feed snapshot
+type rankedEntry struct {
+	AlbumID string      `json:"album_id"`
+	Score   m.FeedScore `json:"score"`
+}
+
+// queryRankedFeed returns a page of the feed best scored first, the whole feed with a limit of zero. The pages of a
+// scroll are read from the snapshot of the ranking taken for its first page. Albums that left the feed since, because
+// they were deleted or archived or their owner is no longer a friend, are dropped from the page, so a page can be
+// short. The scores are only on the albums with debug.
+func queryRankedFeed(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, uid string, limit int, cursor rankedCursor, debug bool) (m.Page[m.Album], error) {
+	entries, err := rankedSnapshot(ctx, connPool, rdb, uid, cursor.asOf, limit > 0)
+	if err != nil {
+		return m.Page[m.Album]{}, err
+	}
+
+	start := min(cursor.offset, len(entries))
+	end := len(entries)
+	if limit > 0 {
+		end = min(start+limit, len(entries))
+	}
+
+	albumIDs := make([]string, 0, end-start)
+	for _, entry := range entries[start:end] {
+		albumIDs = append(albumIDs, entry.AlbumID)
+	}
+
+	albums, err := queryFeedAlbumsByID(ctx, connPool, uid, albumIDs)
+	if err != nil {
+		return m.Page[m.Album]{}, err
+	}
+
+	feed := m.Page[m.Album]{Items: albums}
+	if end < len(entries) {
+		feed.NextCursor = rankedCursor{asOf: cursor.asOf, offset: end}.encode()
+	}
+
+	if debug {
+		scores := make(map[string]m.FeedScore, end-start)
+		for _, entry := range entries[start:end] {
+			scores[entry.AlbumID] = entry.Score
+		}
+		for i := range feed.Items {
+			score := scores[feed.Items[i].AlbumID]
+			feed.Items[i].Score = &score
+		}
+	}
+
+	return feed, nil
+}
+
+// rankedSnapshot returns the ranking of the user's feed as of asOf. It is read from Redis while the snapshot is kept,
+// otherwise the feed is ranked and, when store is set, the ranking kept for the next pages.
+func rankedSnapshot(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, uid string, asOf time.Time, store bool) ([]rankedEntry, error) {
+	entries := []rankedEntry{}
+	snapshotKey := rankedSnapshotKeyPrefix + uid + ":" + asOf.Format(time.RFC3339Nano)
+
+	stored, err := rdb.LRange(ctx, snapshotKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > 0 {
+		for _, encoded := range stored {
+			var entry rankedEntry
+			err = json.Unmarshal([]byte(encoded), &entry)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	response, err := connPool.Pool.Query(ctx, rankedFeedQuery, uid, asOf, affinityWeight, recencyWeight, phaseWeight,
+		engagementWeight)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	for response.Next() {
+		var entry rankedEntry
 
-	var responseBytes []byte
-	if len(albums) != 0 {
-		responseBytes, err = json.MarshalIndent(albums, "", "\t")
+		err := response.Scan(&entry.AlbumID, &entry.Score.Score, &entry.Score.Affinity, &entry.Score.Recency,
+			&entry.Score.Phase, &entry.Score.Engagement)
 		if err != nil {
-			log.Panic(err)
-			return
+			return nil, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(responseBytes)
-	} else {
-		errorString, err := json.MarshalIndent("Error: No Albums Found", "", "\t")
+
+		entries = append(entries, entry)
+	}
+	if response.Err() != nil {
+		return nil, response.Err()
+	}
+
+	if !store || len(entries) == 0 {
+		return entries, nil
+	}
+
+	encoded := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		encoded[i], err = json.Marshal(entry)
 		if err != nil {
-			log.Panic(err)
-			return
+			return nil, err
 		}
-		responseBytes := []byte(errorString)
+	}
 
-		w.WriteHeader(http.StatusNoContent)
-		w.Header().Set("Content-Type", "application/json") //add content length number of bytes
-		w.Write(responseBytes)
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, snapshotKey)
+	pipe.RPush(ctx, snapshotKey, encoded...)
+	pipe.Expire(ctx, snapshotKey, rankedSnapshotTTL)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// queryFeedAlbumsByID returns the albums that are still in the user's feed in the order of albumIDs
+func queryFeedAlbumsByID(ctx context.Context, connPool *m.PGPool, uid string, albumIDs []string) ([]m.Album, error) {
+	albums := []m.Album{}
+
+	if len(albumIDs) == 0 {
+		return albums, nil
+	}
+
+	query := `SELECT * FROM (` + feedAlbumsQuery + `) feed
+		WHERE feed.album_id = ANY($2::uuid[])
+		ORDER BY array_position($2::uuid[], feed.album_id)`
+
+	response, err := connPool.Pool.Query(ctx, query, uid, albumIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	for response.Next() {
+		var album m.Album
+
+		err := response.Scan(&album.AlbumID, &album.AlbumName, &album.AlbumOwner, &album.OwnerFirst, &album.OwnerLast,
+			&album.CreatedAt, &album.RevealedAt, &album.AlbumCoverID, &album.Visibility)
+		if err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, album)
+	}
+
+	return albums, response.Err()
+}
+
+// rankedCursor is the position in a ranked feed snapshot and the time of the snapshot, the time of the first page
+type rankedCursor struct {
+	asOf   time.Time
+	offset int
+}
+
+func (cursor rankedCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor.asOf.Format(time.RFC3339Nano) + "," +
+		strconv.Itoa(cursor.offset)))
+}
+
+func decodeRankedCursor(encoded string) (rankedCursor, error) {
+	var cursor rankedCursor
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, err
+	}
+
+	asOfPart, offsetPart, found := strings.Cut(string(decoded), ",")
+	if !found {
+		return cursor, errors.New("cursor is not a ranked feed cursor")
+	}
+
+	cursor.asOf, err = time.Parse(time.RFC3339Nano, asOfPart)
+	if err != nil {
+		return cursor, err
+	}
+
+	cursor.offset, err = strconv.Atoi(offsetPart)
+	if err != nil {
+		return cursor, err
+	}
+	if cursor.offset < 0 {
+		return cursor, errors.New("cursor offset is negative")
 	}
 
+	return cursor, nil
 }
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRankedSnapshotIsReadFromRedis(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	asOf := time.Date(2024, 5, 17, 21, 3, 4, 123456789, time.UTC)
+
+	entries := []rankedEntry{
+		{AlbumID: "0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"},
+		{AlbumID: "6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f"},
+	}
+	entries[0].Score.Score = 0.9
+	entries[1].Score.Score = 0.4
+	for _, entry := range entries {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		rdb.RPush(ctx, rankedSnapshotKeyPrefix+"test|user:"+asOf.Format(time.RFC3339Nano), encoded)
+	}
+
+	// A kept snapshot is never ranked again, so no database is needed
+	snapshot, err := rankedSnapshot(ctx, nil, rdb, "test|user", asOf, true)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if !reflect.DeepEqual(snapshot, entries) {
+		t.Errorf("snapshot = %v, want %v", snapshot, entries)
+	}
+}
@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"context"
-	"firebase.google.com/go/v4/messaging"
-	"fmt"
+	"errors"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func FirebaseHandlers(connPool *m.PGPool, ctx context.Context) http.Handler {
@@ -25,23 +27,47 @@ func FirebaseHandlers(connPool *m.PGPool, ctx context.Context) http.Handler {
 			case "/fcm":
 				PUTFirebaseToken(w, r, ctx, connPool, claims.RegisteredClaims.Subject)
 			}
+		case http.MethodDelete:
+			switch r.URL.Path {
+			case "/fcm":
+				DELETEFirebaseToken(w, r, ctx, connPool, claims.RegisteredClaims.Subject)
+			}
 		}
 
 	})
 }
 
+// PUTFirebaseToken registers the push token of a device. provider is fcm unless the app registered an APNs device
+// token to be sent to directly.
 func PUTFirebaseToken(w http.ResponseWriter, r *http.Request, context context.Context, connPool *m.PGPool, authZeroID string) {
 	token := r.URL.Query().Get("token")
 	deviceId := r.URL.Query().Get("device_id")
+	provider := r.URL.Query().Get("provider")
 
-	query := `INSERT INTO firebase_tokens (user_id, token, device_id)
-				VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2, $3)
+	query := `INSERT INTO firebase_tokens (user_id, token, device_id, provider)
+				VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2, $3, $4)
 				ON CONFLICT (user_id,device_id)
-				DO UPDATE SET token = EXCLUDED.token,  updated_at = (now() AT TIME ZONE 'utc'::text)`
+				DO UPDATE SET token = EXCLUDED.token, provider = EXCLUDED.provider, updated_at = (now() AT TIME ZONE 'utc'::text)`
 
-	_, err := connPool.Pool.Exec(context, query, authZeroID, token, deviceId)
+	if token == "" || deviceId == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "token and device_id are required")
+		return
+	}
+
+	switch provider {
+	case "":
+		provider = push.FCM
+	case push.FCM, push.APNs:
+	default:
+		WriteResponseWithCode(w, http.StatusBadRequest, "provider has to be fcm or apns")
+		return
+	}
+
+	_, err := connPool.Pool.Exec(context, query, authZeroID, token, deviceId, provider)
 	if err != nil {
 		log.Printf("Failed to insert firebase token: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Failed to update token")
+		return
 	}
 
 	responseBytes := []byte("updated token - success")
@@ -51,61 +77,255 @@ func PUTFirebaseToken(w http.ResponseWriter, r *http.Request, context context.Co
 
 }
 
-func SendFirebaseMessageToUID(context context.Context, connPool *m.PGPool, messagingClient *messaging.Client, notification m.FirebaseNotification) error {
-	var title string
-	var body string
-	var tokens []string
-	var dataPayload map[string]string
+// DELETEFirebaseToken removes the token of the device on logout, so the device stops receiving the user's pushes. The
+// device is given by device_id or by the token itself.
+func DELETEFirebaseToken(w http.ResponseWriter, r *http.Request, context context.Context, connPool *m.PGPool, authZeroID string) {
+	token := r.URL.Query().Get("token")
+	deviceId := r.URL.Query().Get("device_id")
+
+	if token == "" && deviceId == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "token or device_id is required")
+		return
+	}
 
-	tokenQuery := `SELECT token FROM firebase_tokens WHERE user_id = $1`
+	query := `DELETE FROM firebase_tokens
+				WHERE user_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)
+				AND (device_id = NULLIF($2, '') OR token = NULLIF($3, ''))`
+
+	_, err := connPool.Pool.Exec(context, query, authZeroID, deviceId, token)
+	if err != nil {
+		log.Printf("Failed to delete firebase token: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Failed to delete token")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Token deleted")
+}
+
+// SendFirebaseMessageToUID renders the notification in the recipient's locale and sends it to all of their devices.
+// The data payload carries what the app needs to open the notification and the badge is the recipient's unread count.
+func SendFirebaseMessageToUID(context context.Context, connPool *m.PGPool, pushSenders push.Senders, notification m.FirebaseNotification) error {
+	var locale string
+	var unread int
+	tokens := make(map[string][]string)
+
+	allowed, err := NotificationAllowed(context, connPool, notification.RecipientID, notification.Category,
+		PushChannel, notification.AlbumID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		// The user turned the push off, muted the album or is in their quiet hours
+		return nil
+	}
+
+	tokenQuery := `SELECT token, provider FROM firebase_tokens WHERE user_id = $1`
+
+	localeQuery := `SELECT COALESCE((SELECT locale FROM user_notification_settings WHERE user_id = $1), $2)`
 
 	rows, err := connPool.Pool.Query(context, tokenQuery, notification.RecipientID)
 	if err != nil {
-		log.Print(err)
+		return err
 	}
 
 	for rows.Next() {
-		var token string
+		var token, provider string
 
-		err = rows.Scan(&token)
+		err = rows.Scan(&token, &provider)
 		if err != nil {
-			log.Print(err)
+			rows.Close()
+			return err
 		}
 
-		tokens = append(tokens, token)
+		tokens[provider] = append(tokens[provider], token)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	if len(tokens) == 0 {
+		return nil
 	}
 
-	switch notification.Type {
-	case "album-invite":
-		dataPayload = map[string]string{
-			"type": "album-invite",
-		}
-		title = fmt.Sprintf("Accept invite to %v", notification.ContentName)
-		body = fmt.Sprintf("%v sent you an album invite.", notification.RequesterName)
-	case "friend-request":
-		dataPayload = map[string]string{
-			"type": "friend-request",
-		}
-		title = fmt.Sprintf("New Friend Request!")
-		body = fmt.Sprintf("%v sent you a friend request.", notification.RequesterName)
-		log.Print("Inside friend request")
+	err = connPool.Pool.QueryRow(context, localeQuery, notification.RecipientID, push.DefaultLocale).Scan(&locale)
+	if err != nil {
+		return err
 	}
 
-	fcmNotification := messaging.Notification{
-		Title: title,
-		Body:  body,
+	// The badge matches the unread count of the inbox
+	unread, err = queryUnreadCount(context, connPool, notification.RecipientID)
+	if err != nil {
+		return err
 	}
 
-	message := messaging.MulticastMessage{
-		Data:         dataPayload,
-		Tokens:       tokens,
-		Notification: &fcmNotification,
+	kind := notification.Type
+	if kind == push.EngagementSummary {
+		kind = push.SummaryKind(notification.Category)
 	}
 
-	_, err = messagingClient.SendEachForMulticast(context, &message)
+	title, body, err := push.Render(locale, kind, push.Params{
+		Actor:  notification.RequesterName,
+		Other:  notification.OtherName,
+		Others: notification.Others,
+		Album:  notification.ContentName,
+		Count:  notification.Count,
+	})
 	if err != nil {
 		return err
 	}
 
+	dataPayload := map[string]string{
+		"type":  notification.Type,
+		"badge": strconv.Itoa(unread),
+	}
+	if notification.NotificationID != "" {
+		dataPayload["notification_id"] = notification.NotificationID
+	}
+	if notification.AlbumID != "" {
+		dataPayload["album_id"] = notification.AlbumID
+	}
+	if notification.ImageID != "" {
+		dataPayload["image_id"] = notification.ImageID
+	}
+
+	var delivered int
+	var lastErr error
+	for provider, providerTokens := range tokens {
+		sender, ok := pushSenders[provider]
+		if !ok {
+			log.Printf("No push sender for %v tokens", provider)
+			continue
+		}
+
+		sent, err := deliverPush(context, connPool, sender, push.Message{
+			Tokens:      providerTokens,
+			Title:       title,
+			Body:        body,
+			Data:        dataPayload,
+			Badge:       &unread,
+			CollapseKey: notification.CollapseKey,
+		})
+		delivered += sent
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	// The push is only retried when it reached none of the devices, retrying would repeat it on the others
+	if delivered == 0 {
+		return lastErr
+	}
 	return nil
 }
+
+const (
+	// Attempts for the tokens the provider could not reach because of a transient failure
+	pushSendAttempts = 3
+	pushRetryBackoff = time.Second
+	// Tokens that were not refreshed for this long belong to devices that are no longer used
+	FirebaseTokenMaxAge = 60 * 24 * time.Hour
+	// Album pushes record who they reached for as long as their event can be redelivered
+	pushDeliveryRetention = 24 * time.Hour
+)
+
+// deliverPush sends the message to each of its tokens and returns to how many it was delivered. Tokens the provider
+// reports as unregistered or invalid are deleted and tokens that failed transiently are retried with backoff.
+func deliverPush(ctx context.Context, connPool *m.PGPool, sender push.Sender, message push.Message) (int, error) {
+	var delivered int
+	var lastErr error
+	var invalid []string
+	backoff := pushRetryBackoff
+
+	for attempt := 1; len(message.Tokens) > 0; attempt++ {
+		var retry []string
+
+		results, err := sender.Send(ctx, message)
+		if err != nil {
+			// The whole request failed, every token is tried again
+			lastErr = err
+			retry = message.Tokens
+		} else {
+			accepted := 0
+			for _, result := range results {
+				if result.Err == nil {
+					accepted++
+				}
+			}
+			delivered += accepted
+
+			for _, result := range results {
+				switch {
+				case result.Err == nil:
+				case errors.Is(result.Err, push.ErrInvalidToken):
+					invalid = append(invalid, result.Token)
+				case errors.Is(result.Err, push.ErrInvalidArgument) && accepted > 0:
+					// The same message reached other devices, so the argument at fault is the token
+					invalid = append(invalid, result.Token)
+				case errors.Is(result.Err, push.ErrUnavailable):
+					lastErr = result.Err
+					retry = append(retry, result.Token)
+				default:
+					lastErr = result.Err
+					log.Printf("Unable to send push to token: %v", result.Err)
+				}
+			}
+		}
+
+		if len(retry) == 0 || attempt == pushSendAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		message.Tokens = retry
+	}
+
+	if len(invalid) > 0 {
+		deleteQuery := `DELETE FROM firebase_tokens WHERE token = ANY($1)`
+
+		_, err := connPool.Pool.Exec(ctx, deleteQuery, invalid)
+		if err != nil {
+			log.Printf("Unable to delete invalid push tokens: %v", err)
+		}
+	}
+
+	return delivered, lastErr
+}
+
+// FirebaseTokenCleanupJob deletes the tokens that were not refreshed within FirebaseTokenMaxAge and the push deliveries
+// older than pushDeliveryRetention, checking every interval until the context is cancelled. Apps refresh their token
+// on every launch.
+func FirebaseTokenCleanupJob(ctx context.Context, connPool *m.PGPool, interval time.Duration) {
+	staleQuery := `DELETE FROM firebase_tokens WHERE updated_at < $1`
+	// Deliveries are only checked while their event can still be redelivered
+	deliveriesQuery := `DELETE FROM push_deliveries WHERE delivered_at < $1`
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := connPool.Pool.Exec(ctx, staleQuery, time.Now().UTC().Add(-FirebaseTokenMaxAge))
+			if err != nil {
+				log.Printf("Token cleanup: unable to delete stale tokens: %v", err)
+				continue
+			}
+			if result.RowsAffected() > 0 {
+				log.Printf("Token cleanup: deleted %v stale tokens", result.RowsAffected())
+			}
+
+			_, err = connPool.Pool.Exec(ctx, deliveriesQuery, time.Now().UTC().Add(-pushDeliveryRetention))
+			if err != nil {
+				log.Printf("Token cleanup: unable to delete old push deliveries: %v", err)
+			}
+		}
+	}
+}
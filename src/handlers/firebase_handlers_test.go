@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestDeliverPushCountsAcceptedTokens(t *testing.T) {
+	ctx := context.Background()
+	fake := push.NewFake()
+
+	message := push.Message{Tokens: []string{"phone", "tablet"}, Title: "Ana", Body: "sent you a friend request"}
+
+	delivered, err := deliverPush(ctx, nil, fake, message)
+	if err != nil || delivered != 2 {
+		t.Fatalf("delivered = %d, %v, want 2 and no error", delivered, err)
+	}
+	if sent := fake.Sent(); len(sent) != 1 || !reflect.DeepEqual(sent[0], message) {
+		t.Errorf("sent = %+v, want the message once", sent)
+	}
+
+	// A rejected device is neither retried nor deleted, the push still counts for the other one
+	fake.Reset()
+	rejected := errors.New("rejected")
+	fake.Fail("tablet", rejected)
+
+	delivered, err = deliverPush(ctx, nil, fake, message)
+	if !errors.Is(err, rejected) || delivered != 1 {
+		t.Errorf("delivered = %d, %v, want 1 and the rejection", delivered, err)
+	}
+	if sent := fake.Sent(); len(sent) != 1 {
+		t.Errorf("the message was sent %d times, want 1", len(sent))
+	}
+}
+
+// pushFlow is the path of an event from the handler that enqueues it to the push sender, with the fake in place of
+// the providers
+type pushFlow struct {
+	connPool *m.PGPool
+	rdb      *redis.Client
+	fake     *push.Fake
+	senders  push.Senders
+	bus      *events.MemoryBus
+}
+
+func newPushFlow(t *testing.T) *pushFlow {
+	t.Helper()
+
+	connPool := testPool(t)
+	rdb := newTestRedis(t)
+	fake := push.NewFake()
+	senders := push.Senders{push.FCM: fake}
+
+	bus := events.NewMemoryBus()
+	RegisterEventSubscribers(bus, connPool, rdb, senders, nil, "")
+
+	return &pushFlow{connPool: connPool, rdb: rdb, fake: fake, senders: senders, bus: bus}
+}
+
+// dispatch hands the pending outbox entries to the subscribers and returns the pushes sent to the token
+func (flow *pushFlow) dispatch(t *testing.T, token string) []push.Message {
+	t.Helper()
+
+	for {
+		dispatched, err := dispatchOutboxBatch(context.Background(), flow.connPool, flow.rdb, flow.bus)
+		if err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+		if dispatched < outboxBatchSize {
+			break
+		}
+	}
+
+	var sent []push.Message
+	for _, message := range flow.fake.Sent() {
+		for _, messageToken := range message.Tokens {
+			if messageToken == token {
+				sent = append(sent, message)
+			}
+		}
+	}
+	return sent
+}
+
+func TestFriendRequestPush(t *testing.T) {
+	flow := newPushFlow(t)
+
+	_, senderAuthZeroID := seedUser(t, flow.connPool, "Ana")
+	receiverID, _ := seedUser(t, flow.connPool, "Ben")
+	token := seedPushToken(t, flow.connPool, receiverID)
+
+	w := httptest.NewRecorder()
+	POSTFriendRequest(context.Background(), w, httptest.NewRequest(http.MethodPost, "/friend-request?id="+receiverID, nil),
+		flow.connPool, flow.rdb, senderAuthZeroID, flow.senders)
+	// Failures are written with a 200 as well
+	if !strings.Contains(w.Body.String(), "success") {
+		t.Fatalf("the request was not sent: %s", w.Body)
+	}
+
+	sent := flow.dispatch(t, token)
+	if len(sent) != 1 {
+		t.Fatalf("%d pushes sent to the receiver, want 1", len(sent))
+	}
+	if sent[0].Data["type"] != push.FriendRequest || sent[0].Data["notification_id"] == "" {
+		t.Errorf("data = %v, want a friend request with its id", sent[0].Data)
+	}
+	if !strings.Contains(sent[0].Title+sent[0].Body, "Ana") {
+		t.Errorf("the push %q %q does not name the sender", sent[0].Title, sent[0].Body)
+	}
+
+	// The request is only pushed once, also when the outbox is dispatched again
+	if sent := flow.dispatch(t, token); len(sent) != 1 {
+		t.Errorf("%d pushes sent after dispatching again, want 1", len(sent))
+	}
+}
+
+func TestAlbumInvitePush(t *testing.T) {
+	flow := newPushFlow(t)
+
+	ownerID, ownerAuthZeroID := seedUser(t, flow.connPool, "Ana")
+	guestID, _ := seedUser(t, flow.connPool, "Ben")
+	seedFriends(t, flow.connPool, ownerID, guestID)
+	token := seedPushToken(t, flow.connPool, guestID)
+	album := seedAlbums(t, flow.connPool, ownerID, nil, 1, 0)[0]
+
+	body := `{"album_id": "` + album.AlbumID + `", "guest_ids": ["` + guestID + `"]}`
+	w := httptest.NewRecorder()
+	POSTBulkAlbumInvite(context.Background(), w, httptest.NewRequest(http.MethodPost, "/album/invite", strings.NewReader(body)),
+		flow.connPool, ownerAuthZeroID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	sent := flow.dispatch(t, token)
+	if len(sent) != 1 {
+		t.Fatalf("%d pushes sent to the guest, want 1", len(sent))
+	}
+	if sent[0].Data["type"] != push.AlbumInvite || sent[0].Data["album_id"] != album.AlbumID {
+		t.Errorf("data = %v, want an invite to %v", sent[0].Data, album.AlbumID)
+	}
+	if !strings.Contains(sent[0].Title+sent[0].Body, album.AlbumName) {
+		t.Errorf("the push %q %q does not name the album", sent[0].Title, sent[0].Body)
+	}
+}
+
+func TestInvalidPushTokensAreDeleted(t *testing.T) {
+	flow := newPushFlow(t)
+
+	_, senderAuthZeroID := seedUser(t, flow.connPool, "Ana")
+	receiverID, _ := seedUser(t, flow.connPool, "Ben")
+	token := seedPushToken(t, flow.connPool, receiverID)
+	flow.fake.Fail(token, push.ErrInvalidToken)
+
+	w := httptest.NewRecorder()
+	POSTFriendRequest(context.Background(), w, httptest.NewRequest(http.MethodPost, "/friend-request?id="+receiverID, nil),
+		flow.connPool, flow.rdb, senderAuthZeroID, flow.senders)
+	// Failures are written with a 200 as well
+	if !strings.Contains(w.Body.String(), "success") {
+		t.Fatalf("the request was not sent: %s", w.Body)
+	}
+
+	if sent := flow.dispatch(t, token); len(sent) != 1 {
+		t.Fatalf("%d pushes sent to the receiver, want 1", len(sent))
+	}
+
+	var tokens int
+	err := flow.connPool.Pool.QueryRow(context.Background(), `SELECT count(*) FROM firebase_tokens WHERE token = $1`,
+		token).Scan(&tokens)
+	if err != nil {
+		t.Fatalf("count tokens: %v", err)
+	}
+	if tokens != 0 {
+		t.Error("the invalid token was not deleted")
+	}
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/jackc/pgx/v5"
+	m "last_weekend_services/src/models"
+	"log"
+	"net/http"
+)
+
+func FriendGroupEndpointHandler(ctx context.Context, connPool *m.PGPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		if !ok {
+			log.Printf("Failed to get validated claims")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			GETFriendGroups(ctx, w, connPool, claims.RegisteredClaims.Subject)
+		case http.MethodPost:
+			POSTNewFriendGroup(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+		case http.MethodPatch:
+			PATCHFriendGroup(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+		case http.MethodDelete:
+			DELETEFriendGroup(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+		}
+	})
+}
+
+func GETFriendGroups(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, authZeroID string) {
+	groups := []m.FriendGroup{}
+
+	groupQuery := `SELECT group_id, group_name, owner_id, created_at
+					FROM friend_groups
+					WHERE owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)
+					ORDER BY created_at`
+
+	// Joining on friends drops members the owner has since unfriended
+	memberQuery := `SELECT fgm.group_id, u.user_id, u.first_name, u.last_name, f.friends_since
+					FROM friend_group_members fgm
+					JOIN friend_groups fg ON fg.group_id = fgm.group_id
+					JOIN users u ON u.user_id = fgm.user_id
+					JOIN friends f
+					ON (f.user1_id = fg.owner_id AND f.user2_id = fgm.user_id)
+					OR (f.user2_id = fg.owner_id AND f.user1_id = fgm.user_id)
+					WHERE fg.owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)`
+
+	batch := &pgx.Batch{}
+	batch.Queue(groupQuery, authZeroID)
+	batch.Queue(memberQuery, authZeroID)
+	batchResults := connPool.Pool.SendBatch(ctx, batch)
+	defer func() {
+		err := batchResults.Close()
+		if err != nil {
+			log.Printf("%v", err)
+			return
+		}
+	}()
+
+	groupRows, err := batchResults.Query()
+	if err != nil {
+		log.Printf("Unable to query friend groups: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query friend groups")
+		return
+	}
+
+	groupIndex := make(map[string]int)
+	for groupRows.Next() {
+		group := m.FriendGroup{Members: []m.Friend{}}
+
+		err = groupRows.Scan(&group.GroupID, &group.GroupName, &group.OwnerID, &group.CreatedAt)
+		if err != nil {
+			log.Printf("Unable to scan friend group: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to scan friend group")
+			return
+		}
+
+		groupIndex[group.GroupID] = len(groups)
+		groups = append(groups, group)
+	}
+
+	memberRows, err := batchResults.Query()
+	if err != nil {
+		log.Printf("Unable to query friend group members: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query friend group members")
+		return
+	}
+
+	for memberRows.Next() {
+		var groupID string
+		var member m.Friend
+
+		err = memberRows.Scan(&groupID, &member.ID, &member.FirstName, &member.LastName, &member.FriendsSince)
+		if err != nil {
+			log.Printf("Unable to scan friend group member: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to scan friend group member")
+			return
+		}
+
+		if index, ok := groupIndex[groupID]; ok {
+			groups[index].Members = append(groups[index].Members, member)
+		}
+	}
+
+	responseBytes, err := json.MarshalIndent(groups, "", "\t")
+	if err != nil {
+		log.Panic(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func POSTNewFriendGroup(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var group m.FriendGroup
+
+	err := json.NewDecoder(r.Body).Decode(&group)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Invalid request body - could not be mapped to object")
+		log.Printf("Unable to decode friend group: %v", err)
+		return
+	}
+
+	if group.GroupName == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Group name not provided")
+		return
+	}
+
+	createGroupQuery := `INSERT INTO friend_groups (group_name, owner_id)
+						VALUES ($1, (SELECT user_id FROM users WHERE auth_zero_id = $2))
+						RETURNING group_id, owner_id, created_at`
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error starting transaction")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, createGroupQuery, group.GroupName, authZeroID).Scan(&group.GroupID, &group.OwnerID, &group.CreatedAt)
+	if err != nil {
+		log.Printf("Unable to create friend group: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to create friend group")
+		return
+	}
+
+	group.Members, err = replaceFriendGroupMembers(ctx, tx, group.GroupID, group.OwnerID, group.MemberIDs)
+	if err != nil {
+		log.Printf("Unable to add friend group members: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to add friend group members")
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing friend group: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error committing friend group")
+		return
+	}
+
+	group.MemberIDs = nil
+	responseBytes, err := json.MarshalIndent(group, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func PATCHFriendGroup(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var group m.FriendGroup
+
+	err := json.NewDecoder(r.Body).Decode(&group)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Error: Invalid request body - could not be mapped to object")
+		log.Printf("Unable to decode friend group: %v", err)
+		return
+	}
+
+	// An empty name keeps the existing one so the endpoint can be used to only change the members
+	updateGroupQuery := `UPDATE friend_groups
+						SET group_name = COALESCE(NULLIF($1, ''), group_name)
+						WHERE group_id = $2
+						AND owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $3)
+						RETURNING group_name, owner_id, created_at`
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		log.Printf("Error starting transaction: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error starting transaction")
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, updateGroupQuery, group.GroupName, group.GroupID, authZeroID).Scan(&group.GroupName,
+		&group.OwnerID, &group.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Friend group not found")
+			return
+		}
+		log.Printf("Unable to update friend group: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update friend group")
+		return
+	}
+
+	// Omitting member_ids leaves the members untouched, an empty list clears them
+	if group.MemberIDs != nil {
+		group.Members, err = replaceFriendGroupMembers(ctx, tx, group.GroupID, group.OwnerID, group.MemberIDs)
+	} else {
+		group.Members, err = queryFriendGroupMembers(ctx, tx, group.GroupID)
+	}
+	if err != nil {
+		log.Printf("Unable to update friend group members: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update friend group members")
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing friend group: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Error committing friend group")
+		return
+	}
+
+	group.MemberIDs = nil
+	responseBytes, err := json.MarshalIndent(group, "", "\t")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func DELETEFriendGroup(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	groupID := r.URL.Query().Get("group_id")
+	if groupID == "" {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Group ID not provided")
+		return
+	}
+
+	// Members are removed through the ON DELETE CASCADE on friend_group_members
+	deleteQuery := `DELETE FROM friend_groups
+					WHERE group_id = $1
+					AND owner_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)`
+
+	tag, err := connPool.Pool.Exec(ctx, deleteQuery, groupID, authZeroID)
+	if err != nil {
+		log.Printf("Unable to delete friend group: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to delete friend group")
+		return
+	}
+
+	if tag.RowsAffected() == 0 {
+		WriteResponseWithCode(w, http.StatusNotFound, "Friend group not found")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Friend group deleted")
+}
+
+// replaceFriendGroupMembers swaps the members of a group for the provided IDs. Only users that are friends with the
+// group owner are kept, anything else is silently dropped.
+func replaceFriendGroupMembers(ctx context.Context, tx pgx.Tx, groupID string, ownerID string, memberIDs []string) ([]m.Friend, error) {
+	members := []m.Friend{}
+
+	clearQuery := `DELETE FROM friend_group_members WHERE group_id = $1`
+	insertQuery := `WITH owner_friends AS (
+						SELECT friends_since,
+							CASE
+								WHEN user1_id = $2 THEN user2_id
+								WHEN user2_id = $2 THEN user1_id
+							END AS friend_id
+						FROM friends
+						WHERE user1_id = $2 OR user2_id = $2
+					), inserted AS (
+						INSERT INTO friend_group_members (group_id, user_id)
+						SELECT $1, of.friend_id
+						FROM owner_friends of
+						WHERE of.friend_id::text = ANY($3::text[])
+						ON CONFLICT DO NOTHING
+						RETURNING user_id
+					)
+					SELECT u.user_id, u.first_name, u.last_name, of.friends_since
+					FROM inserted i
+					JOIN users u ON u.user_id = i.user_id
+					JOIN owner_friends of ON of.friend_id = i.user_id`
+
+	_, err := tx.Exec(ctx, clearQuery, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(memberIDs) == 0 {
+		return members, nil
+	}
+
+	rows, err := tx.Query(ctx, insertQuery, groupID, ownerID, memberIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member m.Friend
+
+		err = rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.FriendsSince)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+func queryFriendGroupMembers(ctx context.Context, tx pgx.Tx, groupID string) ([]m.Friend, error) {
+	members := []m.Friend{}
+
+	memberQuery := `SELECT u.user_id, u.first_name, u.last_name, f.friends_since
+					FROM friend_group_members fgm
+					JOIN friend_groups fg ON fg.group_id = fgm.group_id
+					JOIN users u ON u.user_id = fgm.user_id
+					JOIN friends f
+					ON (f.user1_id = fg.owner_id AND f.user2_id = fgm.user_id)
+					OR (f.user2_id = fg.owner_id AND f.user1_id = fgm.user_id)
+					WHERE fgm.group_id = $1`
+
+	rows, err := tx.Query(ctx, memberQuery, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member m.Friend
+
+		err = rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.FriendsSince)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
@@ -3,18 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"firebase.google.com/go/v4/messaging"
 	"fmt"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	"github.com/jackc/pgx/v5"
 	"github.com/redis/go-redis/v9"
+	"last_weekend_services/src/events"
 	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
 	"log"
 	"net/http"
 )
 
-func FriendRequestHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, messagingClient *messaging.Client) http.Handler {
+func FriendRequestHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, pushSenders push.Senders) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
 		if !ok {
@@ -24,9 +25,9 @@ func FriendRequestHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Cl
 
 		switch r.Method {
 		case http.MethodPost:
-			POSTFriendRequest(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, messagingClient)
+			POSTFriendRequest(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, pushSenders)
 		case http.MethodPut:
-			PUTAcceptFriendRequest(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, messagingClient)
+			PUTAcceptFriendRequest(ctx, w, r, connPool, rdb, claims.RegisteredClaims.Subject, pushSenders)
 		case http.MethodDelete:
 			DELETEDenyFriendRequest(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
 		case http.MethodPatch:
@@ -35,36 +36,33 @@ func FriendRequestHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Cl
 	})
 }
 
-func POSTFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, senderID string, messagingClient *messaging.Client) {
+func POSTFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, senderID string, pushSenders push.Senders) {
 	var friendRequest m.FriendRequestNotification
 	friendRequest.ReceiverID = r.URL.Query().Get("id")
 
-	wsPayload := WebSocketPayload{
-		Operation: "REQUEST",
-		Type:      "friend-request",
-		UserID:    friendRequest.ReceiverID,
-	}
-
 	// Create SQL entry to add request to friend request table
 	requestQuery := `INSERT INTO friend_requests (sender_id, receiver_id) 
 					 VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2)
 					 RETURNING request_id, updated_at`
 	senderInfoQuery := `SELECT user_id, first_name, last_name from users WHERE auth_zero_id = $1`
 
+	// The request and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Unable to start transaction")
+		log.Printf("Unable to start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
 	batch := &pgx.Batch{}
 	batch.Queue(requestQuery, senderID, friendRequest.ReceiverID)
 	batch.Queue(senderInfoQuery, senderID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	batchResults := tx.SendBatch(ctx, batch)
 
-	err := batchResults.QueryRow().Scan(&friendRequest.RequestID, &friendRequest.ReceivedAt)
+	err = batchResults.QueryRow().Scan(&friendRequest.RequestID, &friendRequest.ReceivedAt)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Unable to add friend request")
 		log.Printf("Unable to add friend request: %v", err)
 		return
@@ -72,35 +70,34 @@ func POSTFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 	err = batchResults.QueryRow().Scan(&friendRequest.SenderID, &friendRequest.FirstName, &friendRequest.LastName)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Unable to lookup requesting user")
 		log.Printf("Unable to lookup requesting user: %v", err)
 		return
 	}
 
-	var fcmNotification = m.FirebaseNotification{
-		RecipientID:    friendRequest.ReceiverID,
-		NotificationID: friendRequest.RequestID,
-		ContentName:    friendRequest.FirstName,
-		RequesterID:    friendRequest.SenderID,
-		RequesterName:  friendRequest.FirstName,
-		Type:           "friend-request",
+	// The batch has to be closed before the transaction can be used again
+	err = batchResults.Close()
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Unable to add friend request")
+		log.Printf("Unable to add friend request: %v", err)
+		return
 	}
 
-	wsPayload.Payload = friendRequest
-
-	// If successfully added to friend_request table then publish the change to redis
-	jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
+	err = EnqueueEvent(ctx, tx, friendRequest.RequestID, events.FriendRequestSent{Request: friendRequest})
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Unable to queue friend request event")
+		log.Printf("Unable to queue friend request event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Unable to add friend request")
+		log.Printf("Unable to commit friend request: %v", err)
+		return
 	}
 
-	err = SendFirebaseMessageToUID(ctx, connPool, messagingClient, fcmNotification)
-
 	//Respond to the calling user that the action was successful
 	responseBytes, err := json.MarshalIndent("friend request sent - success", "", "\t")
 	if err != nil {
@@ -112,15 +109,10 @@ func POSTFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	w.Write(responseBytes)
 }
 
-func PUTAcceptFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, usersID string, messagingClient *messaging.Client) {
+func PUTAcceptFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, usersID string, pushSenders push.Senders) {
 	var friendRequest m.FriendRequestNotification
 	friendRequest.SenderID = r.URL.Query().Get("id")
 	friendRequest.RequestID = r.URL.Query().Get("request_id")
-	wsPayload := WebSocketPayload{
-		Operation: "ACCEPTED",
-		Type:      "friend-request",
-		UserID:    friendRequest.SenderID,
-	}
 
 	updateReqToAccepted := `UPDATE friend_requests
 							SET status = 'accepted', updated_at = (now() AT TIME ZONE 'utc'::text), seen = true
@@ -133,36 +125,43 @@ func PUTAcceptFriendRequest(ctx context.Context, w http.ResponseWriter, r *http.
 
 	senderInfoQuery := `SELECT user_id, first_name, last_name from users WHERE auth_zero_id = $1`
 
+	// The friendship and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		fmt.Fprintf(w, "Error trying to start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
 	// Update Entry in Friend Request Table
-	err := connPool.Pool.QueryRow(ctx, updateReqToAccepted, friendRequest.RequestID).Scan(&friendRequest.Status, &friendRequest.RequestSeen)
+	err = tx.QueryRow(ctx, updateReqToAccepted, friendRequest.RequestID).Scan(&friendRequest.Status, &friendRequest.RequestSeen)
 	if err != nil {
 		fmt.Fprintf(w, "Error trying to remove request: %v", err)
 		return
 	}
 
-	err = connPool.Pool.QueryRow(ctx, addFriendshipQuery, friendRequest.SenderID, usersID).Scan(&friendRequest.ReceivedAt)
+	err = tx.QueryRow(ctx, addFriendshipQuery, friendRequest.SenderID, usersID).Scan(&friendRequest.ReceivedAt)
 	if err != nil {
 		fmt.Fprintf(w, "Error trying to insert friend to friends list: %v", err)
 		return
 	}
 
-	err = connPool.Pool.QueryRow(ctx, senderInfoQuery, usersID).Scan(&friendRequest.ReceiverID, &friendRequest.FirstName, &friendRequest.LastName)
+	err = tx.QueryRow(ctx, senderInfoQuery, usersID).Scan(&friendRequest.ReceiverID, &friendRequest.FirstName, &friendRequest.LastName)
 	if err != nil {
 		fmt.Fprintf(w, "Unable to lookup requesting user: %v", err)
 		return
 	}
 
-	wsPayload.Payload = friendRequest
-
-	// If successfully added to friend_request table then publish the change to redis
-	jsonPayload, err := json.MarshalIndent(wsPayload, "", "\t")
+	err = EnqueueEvent(ctx, tx, friendRequest.RequestID+":accepted", events.FriendRequestAccepted{Request: friendRequest})
 	if err != nil {
-		log.Print(err)
+		fmt.Fprintf(w, "Unable to queue friend request event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		fmt.Fprintf(w, "Error trying to commit friendship: %v", err)
+		return
 	}
 
 	//Respond to the calling user that the action was successful
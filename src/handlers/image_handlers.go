@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"last_weekend_services/src/events"
 	m "last_weekend_services/src/models"
 	"log"
 	"net/http"
@@ -19,6 +20,17 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// liveImageQuery selects the image when neither it nor the album it was posted to has been deleted, engagement with
+// anything else is answered with a 404. imageID is the parameter or column the image is matched against.
+func liveImageQuery(imageID string) string {
+	return `SELECT 1 FROM images li
+			JOIN imagealbum lia ON lia.image_id = li.image_id
+			JOIN albums la ON la.album_id = lia.album_id
+			WHERE li.image_id = ` + imageID + `
+			AND li.deleted_at IS NULL
+			AND la.deleted_at IS NULL`
+}
+
 func ImageEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
@@ -78,51 +90,43 @@ func DELETEImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	}
 
 	notification.ImageID = r.URL.Query().Get("image_id")
-	var image_owner string
 
-	imageOwnerQuery := `SELECT u.auth_zero_id, i.image_owner, a.album_id
+	imageOwnerQuery := `SELECT i.image_owner, a.album_id, (SELECT user_id FROM users WHERE auth_zero_id=$2)
 						FROM images i
-						JOIN users u
-						ON i.image_owner = u.user_id 
 						JOIN imagealbum ia
 						ON i.image_id = ia.image_id
 						JOIN albums a 
 						ON a.album_id = ia.album_id
-						WHERE i.image_id=$1`
+						WHERE i.image_id=$1
+						AND i.deleted_at IS NULL
+						AND a.deleted_at IS NULL`
 
-	err := connPool.Pool.QueryRow(ctx, imageOwnerQuery, notification.ImageID).Scan(&image_owner, &notification.ReceiverID, &notification.AlbumID)
+	err := connPool.Pool.QueryRow(ctx, imageOwnerQuery, notification.ImageID, uid).Scan(&notification.ReceiverID,
+		&notification.AlbumID, &notification.NotifierID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Could not get image_owner")
 		log.Printf("Could not get image_owner: %v", err)
 		return
 	}
-	// Setup the notification in the event that the image_owner is unliking their own image
-	notification.NotifierID = notification.ReceiverID
 
 	upvoteQuery := `DELETE FROM upvotes
 			  WHERE (image_id=$1
 			  AND user_id=(SELECT user_id FROM users WHERE auth_zero_id=$2))`
-	notificationQuery := `DELETE FROM notifications
-						WHERE (media_id=$1
-						AND sender_id=(SELECT user_id FROM users WHERE auth_zero_id=$2)
-						AND type='upvote')
-						RETURNING album_id, receiver_id, sender_id`
 
-	batch := &pgx.Batch{}
-	batch.Queue(upvoteQuery, &notification.ImageID, uid)
-	if image_owner != uid {
-		batch.Queue(notificationQuery, &notification.ImageID, uid)
+	// The removal and its event are committed together, the matching notification is removed by the event subscriber
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not start transaction")
+		log.Printf("Could not start transaction: %v", err)
+		return
 	}
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	defer tx.Rollback(ctx)
 
-	status, err := batchResults.Exec()
+	status, err := tx.Exec(ctx, upvoteQuery, &notification.ImageID, uid)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Upvote could not be deleted")
 		log.Printf("Upvote could not be deleted: %v", err)
@@ -134,18 +138,9 @@ func DELETEImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if image_owner != uid {
-		err = batchResults.QueryRow().Scan(&notification.AlbumID, &notification.ReceiverID, &notification.NotifierID)
-		if err != nil {
-			WriteErrorToWriter(w, "Error: Notification could not be deleted")
-			log.Printf("Notification could not be deleted: %v", err)
-			return
-		}
-	}
-
 	countQuery := `SELECT COUNT(*) FROM upvotes WHERE image_id=$1`
 
-	countResponse := connPool.Pool.QueryRow(ctx, countQuery, &notification.ImageID)
+	countResponse := tx.QueryRow(ctx, countQuery, &notification.ImageID)
 	err = countResponse.Scan(&notification.NewCount)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Could not get upvote count")
@@ -153,24 +148,18 @@ func DELETEImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	payload := WebSocketPayload{
-		Operation: `REMOVE`,
-		Type:      `upvote`,
-		UserID:    notification.ReceiverID,
-		AlbumID:   notification.AlbumID,
-		Payload:   notification,
-	}
-	payload.Payload = notification
-
-	// Send payload to WebSocket
-	jsonPayload, jsonErr := json.MarshalIndent(payload, "", "\t")
-	if jsonErr != nil {
-		log.Print(jsonErr)
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.ImageUpvoteRemoved{Notification: notification})
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not queue upvote event")
+		log.Printf("Could not queue upvote event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, notification.AlbumID, jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Upvote could not be deleted")
+		log.Printf("Could not commit upvote removal: %v", err)
+		return
 	}
 
 	responseJSON, err := json.MarshalIndent(notification, "", "\t")
@@ -198,7 +187,8 @@ func POSTImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Request
 
 	// TODO: Remove upvote_id from upvotes table
 	addToUpvotesQuery := `INSERT INTO upvotes (user_id, image_id)
-			  VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2)
+			  SELECT (SELECT user_id FROM users WHERE auth_zero_id=$1), $2
+			  WHERE EXISTS (` + liveImageQuery("$2") + `)
 			  RETURNING user_id, image_id`
 
 	// Batched Queries
@@ -209,18 +199,28 @@ func POSTImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Request
 						ON a.album_id = ia.album_id
 						JOIN images i 
 						ON ia.image_id = i.image_id
-						WHERE ia.image_id=$1`
+						WHERE ia.image_id=$1
+						AND i.deleted_at IS NULL
+						AND a.deleted_at IS NULL`
 	engagerQuery := `SELECT first_name, last_name FROM users WHERE user_id=$1`
 
-	// Add Notification to be Sent to user
-	addToNotifications := `INSERT INTO notifications (album_id, media_id, sender_id, receiver_id, type) 
-							VALUES ($1, $2, $3, $4, 'upvote')
-							RETURNING notification_uid, received_at, seen`
+	// The upvote and its event are committed together so the event is only published when the upvote is stored
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not start transaction")
+		log.Printf("Could not start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
 
 	// Add to Upvote Table Query
-	err = connPool.Pool.QueryRow(ctx, addToUpvotesQuery, uid, imageID).Scan(&notification.NotifierID,
+	err = tx.QueryRow(ctx, addToUpvotesQuery, uid, imageID).Scan(&notification.NotifierID,
 		&notification.ImageID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Image could not be upvoted")
 		log.Printf("Image could not be upvoted: %v", err)
 		return
@@ -229,18 +229,12 @@ func POSTImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Request
 	batch.Queue(countQuery, imageID)
 	batch.Queue(albumDataQuery, imageID)
 	batch.Queue(engagerQuery, &notification.NotifierID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	batchResults := tx.SendBatch(ctx, batch)
 
 	//Count Query
 	err = batchResults.QueryRow().Scan(&notification.NewCount)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get upvote count")
 		log.Printf("Could not get upvote count: %v", err)
 		return
@@ -249,6 +243,7 @@ func POSTImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Request
 	// Album Data Query
 	err = batchResults.QueryRow().Scan(&notification.AlbumID, &notification.AlbumName, &notification.ReceiverID)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get upvote album data")
 		log.Printf("Could not get upvote album data: %v", err)
 		return
@@ -257,45 +252,36 @@ func POSTImageUpvote(ctx context.Context, w http.ResponseWriter, r *http.Request
 	// Engager Query
 	err = batchResults.QueryRow().Scan(&notification.NotifierFirst, &notification.NotifierLast)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get upvote engager data")
 		log.Printf("Could not get upvote engager data: %v", err)
 		return
 	}
 
-	// Add to Notification Table for Auth'd User
-	if notification.NotifierID != notification.ReceiverID {
-		err = connPool.Pool.QueryRow(ctx, addToNotifications, &notification.AlbumID, &notification.ImageID,
-			&notification.NotifierID, notification.ReceiverID).Scan(&notification.NotificationID, &notification.ReceivedAt, &notification.NotificationSeen)
-		if err != nil {
-			WriteErrorToWriter(w, "Error: With adding to notification table")
-			log.Printf("Adding to notification table error: %v", err)
-			return
-		}
+	// The batch has to be closed before the transaction can be used again
+	err = batchResults.Close()
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not read upvote data")
+		log.Printf("Could not read upvote data: %v", err)
+		return
 	}
 
-	payload := WebSocketPayload{
-		Operation: `ADD`,
-		Type:      `upvote`,
-		UserID:    notification.ReceiverID,
-		AlbumID:   notification.AlbumID,
-		Payload:   notification,
-	}
-	payload.Payload = notification
+	// The notification is stored by the event subscriber under this ID
+	notification.NotificationID = uuid.NewString()
+	notification.ReceivedAt = time.Now().UTC()
 
-	// Send payload to WebSocket
-	jsonPayload, jsonErr := json.MarshalIndent(payload, "", "\t")
-	if jsonErr != nil {
-		log.Print(jsonErr)
-	}
-
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = EnqueueEvent(ctx, tx, notification.NotificationID, events.ImageUpvoted{Notification: notification})
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Could not queue upvote event")
+		log.Printf("Could not queue upvote event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, notification.AlbumID, jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Could not commit upvote")
+		log.Printf("Could not commit upvote: %v", err)
+		return
 	}
 
 	responseJSON, err := json.MarshalIndent(notification.NewCount, "", "\t")
@@ -314,51 +300,43 @@ func DELETEImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request
 	}
 
 	notification.ImageID = r.URL.Query().Get("image_id")
-	var image_owner string
 
-	imageOwnerQuery := `SELECT u.auth_zero_id, i.image_owner, a.album_id
+	imageOwnerQuery := `SELECT i.image_owner, a.album_id, (SELECT user_id FROM users WHERE auth_zero_id=$2)
 						FROM images i
-						JOIN users u
-						ON i.image_owner = u.user_id 
 						JOIN imagealbum ia
 						ON i.image_id = ia.image_id
 						JOIN albums a 
 						ON a.album_id = ia.album_id
-						WHERE i.image_id=$1`
+						WHERE i.image_id=$1
+						AND i.deleted_at IS NULL
+						AND a.deleted_at IS NULL`
 
-	err := connPool.Pool.QueryRow(ctx, imageOwnerQuery, notification.ImageID).Scan(&image_owner, &notification.ReceiverID, &notification.AlbumID)
+	err := connPool.Pool.QueryRow(ctx, imageOwnerQuery, notification.ImageID, uid).Scan(&notification.ReceiverID,
+		&notification.AlbumID, &notification.NotifierID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Could not get image_owner")
 		log.Printf("Could not get image_owner: %v", err)
 		return
 	}
-	// Setup the notification in the event that the image_owner is unliking their own image
-	notification.NotifierID = notification.ReceiverID
 
 	upvoteQuery := `DELETE FROM likes
 			  WHERE (image_id=$1
 			  AND user_id=(SELECT user_id FROM users WHERE auth_zero_id=$2))`
-	notificationQuery := `DELETE FROM notifications
-						WHERE (media_id=$1
-						AND sender_id=(SELECT user_id FROM users WHERE auth_zero_id=$2)
-						AND type='liked')
-						RETURNING album_id, receiver_id, sender_id`
 
-	batch := &pgx.Batch{}
-	batch.Queue(upvoteQuery, &notification.ImageID, uid)
-	if image_owner != uid {
-		batch.Queue(notificationQuery, &notification.ImageID, uid)
+	// The removal and its event are committed together, the matching notification is removed by the event subscriber
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not start transaction")
+		log.Printf("Could not start transaction: %v", err)
+		return
 	}
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	defer tx.Rollback(ctx)
 
-	status, err := batchResults.Exec()
+	status, err := tx.Exec(ctx, upvoteQuery, &notification.ImageID, uid)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Like could not be deleted")
 		log.Printf("Like could not be deleted: %v", err)
@@ -370,18 +348,9 @@ func DELETEImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if image_owner != uid {
-		err = batchResults.QueryRow().Scan(&notification.AlbumID, &notification.ReceiverID, &notification.NotifierID)
-		if err != nil {
-			WriteErrorToWriter(w, "Error: Notification could not be deleted")
-			log.Printf("Notification could not be deleted: %v", err)
-			return
-		}
-	}
-
 	countQuery := `SELECT COUNT(*) FROM likes WHERE image_id=$1`
 
-	countResponse := connPool.Pool.QueryRow(ctx, countQuery, &notification.ImageID)
+	countResponse := tx.QueryRow(ctx, countQuery, &notification.ImageID)
 	err = countResponse.Scan(&notification.NewCount)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Could not get like count")
@@ -389,24 +358,18 @@ func DELETEImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	payload := WebSocketPayload{
-		Operation: `REMOVE`,
-		Type:      `liked`,
-		UserID:    notification.ReceiverID,
-		AlbumID:   notification.AlbumID,
-		Payload:   notification,
-	}
-	payload.Payload = notification
-
-	// Send payload to WebSocket
-	jsonPayload, jsonErr := json.MarshalIndent(payload, "", "\t")
-	if jsonErr != nil {
-		log.Print(jsonErr)
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.ImageUnliked{Notification: notification})
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not queue like event")
+		log.Printf("Could not queue like event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, notification.AlbumID, jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Like could not be deleted")
+		log.Printf("Could not commit like removal: %v", err)
+		return
 	}
 
 	responseJSON, err := json.MarshalIndent(notification, "", "\t")
@@ -434,7 +397,8 @@ func POSTImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request,
 
 	// Add to Like Table Query
 	addToLikesQuery := `INSERT INTO likes (user_id, image_id)
-			  VALUES ((SELECT user_id FROM users WHERE auth_zero_id=$1), $2)
+			  SELECT (SELECT user_id FROM users WHERE auth_zero_id=$1), $2
+			  WHERE EXISTS (` + liveImageQuery("$2") + `)
 			  RETURNING user_id, image_id`
 
 	// Batched Queries
@@ -445,17 +409,27 @@ func POSTImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request,
 						ON a.album_id = ia.album_id
 						JOIN images i 
 						ON ia.image_id = i.image_id
-						WHERE ia.image_id=$1`
+						WHERE ia.image_id=$1
+						AND i.deleted_at IS NULL
+						AND a.deleted_at IS NULL`
 	engagerQuery := `SELECT first_name, last_name FROM users WHERE user_id=$1`
 
-	// Add Notification to be Sent to user
-	addToNotifications := `INSERT INTO notifications (album_id, media_id, sender_id, receiver_id, type) 
-							VALUES ($1, $2, $3, $4, 'liked')
-							RETURNING notification_uid, received_at, seen`
+	// The like and its event are committed together so the event is only published when the like is stored
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not start transaction")
+		log.Printf("Could not start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
 
-	err = connPool.Pool.QueryRow(ctx, addToLikesQuery, uid, imageID).Scan(&notification.NotifierID,
+	err = tx.QueryRow(ctx, addToLikesQuery, uid, imageID).Scan(&notification.NotifierID,
 		&notification.ImageID)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Image could not be liked")
 		log.Printf("Image could not be liked: %v", err)
 		return
@@ -464,24 +438,20 @@ func POSTImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	batch.Queue(countQuery, imageID)
 	batch.Queue(albumDataQuery, imageID)
 	batch.Queue(engagerQuery, &notification.NotifierID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
+	batchResults := tx.SendBatch(ctx, batch)
 
 	err = batchResults.QueryRow().Scan(&notification.NewCount)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get like count")
 		log.Printf("Could not get like count: %v", err)
 		return
 	}
+
 	// Album Data Query
 	err = batchResults.QueryRow().Scan(&notification.AlbumID, &notification.AlbumName, &notification.ReceiverID)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get liked image album data")
 		log.Printf("Could not get liked image album data: %v", err)
 		return
@@ -490,45 +460,36 @@ func POSTImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	// Engager Query
 	err = batchResults.QueryRow().Scan(&notification.NotifierFirst, &notification.NotifierLast)
 	if err != nil {
+		batchResults.Close()
 		WriteErrorToWriter(w, "Error: Could not get liked image engager data")
 		log.Printf("Could not get liked image engager data: %v", err)
 		return
 	}
 
-	// Add to Notification Table for Auth'd User
-	if notification.NotifierID != notification.ReceiverID {
-		err = connPool.Pool.QueryRow(ctx, addToNotifications, &notification.AlbumID, &notification.ImageID,
-			&notification.NotifierID, notification.ReceiverID).Scan(&notification.NotificationID,
-			&notification.ReceivedAt, &notification.NotificationSeen)
-		if err != nil {
-			WriteErrorToWriter(w, "Error: With adding to notification table")
-			log.Printf("Adding to notification table error: %v", err)
-			return
-		}
-	}
-
-	payload := WebSocketPayload{
-		Operation: `ADD`,
-		Type:      `liked`,
-		UserID:    notification.ReceiverID,
-		AlbumID:   notification.AlbumID,
-		Payload:   notification,
+	// The batch has to be closed before the transaction can be used again
+	err = batchResults.Close()
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not read like data")
+		log.Printf("Could not read like data: %v", err)
+		return
 	}
 
-	// Send payload to WebSocket
-	jsonPayload, jsonErr := json.MarshalIndent(payload, "", "\t")
-	if jsonErr != nil {
-		log.Print(jsonErr)
-	}
+	// The notification is stored by the event subscriber under this ID
+	notification.NotificationID = uuid.NewString()
+	notification.ReceivedAt = time.Now().UTC()
 
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = EnqueueEvent(ctx, tx, notification.NotificationID, events.ImageLiked{Notification: notification})
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Could not queue like event")
+		log.Printf("Could not queue like event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, notification.AlbumID, jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Could not commit like")
+		log.Printf("Could not commit like: %v", err)
+		return
 	}
 
 	responseJSON, err := json.MarshalIndent(notification.NewCount, "", "\t")
@@ -542,6 +503,8 @@ func POSTImageLike(ctx context.Context, w http.ResponseWriter, r *http.Request,
 }
 
 func DELETEImageComment(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	var comment m.Comment
+
 	commentId, err := uuid.Parse(r.URL.Query().Get("id"))
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Could not fetch Comment ID")
@@ -551,18 +514,49 @@ func DELETEImageComment(ctx context.Context, w http.ResponseWriter, r *http.Requ
 
 	query := `DELETE FROM comments
 			  WHERE id=$1
-			  AND commenter_id=(SELECT user_id FROM users WHERE auth_zero_id=$2)`
+			  AND commenter_id=(SELECT user_id FROM users WHERE auth_zero_id=$2)
+			  AND EXISTS (` + liveImageQuery("comments.image_id") + `)
+			  RETURNING id, image_id, commenter_id, comment_text, created_at, updated_at, seen`
 
-	status, err := connPool.Pool.Exec(ctx, query, commentId, uid)
+	// The deletion and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
 	if err != nil {
+		WriteErrorToWriter(w, "Error: Couldn't start transaction")
+		log.Printf("Couldn't start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, commentId, uid).Scan(&comment.ID, &comment.ImageID, &comment.UserID, &comment.Comment,
+		&comment.CreatedAt, &comment.UpdatedAt, &comment.Seen)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Comment not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Comment could not be deleted")
 		log.Printf("Comment could not be deleted: %v", err)
 		return
 	}
 
-	if status.RowsAffected() < 1 {
-		WriteErrorToWriter(w, "Error: Return SQL status is not delete")
-		log.Printf("Return SQL status is not delete %v", err)
+	err = queryCommentContext(ctx, tx, &comment)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Comment could not be deleted")
+		log.Printf("Could not get comment data: %v", err)
+		return
+	}
+
+	err = EnqueueEvent(ctx, tx, "comment:"+comment.ID+":deleted", events.CommentDeleted{Comment: comment})
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not queue comment event")
+		log.Printf("Could not queue comment event: %v", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Comment could not be deleted")
+		log.Printf("Couldn't commit comment deletion: %v", err)
 		return
 	}
 
@@ -577,8 +571,10 @@ func DELETEImageComment(ctx context.Context, w http.ResponseWriter, r *http.Requ
 }
 
 func PATCHImageComment(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
-	var comment m.UpdateComment
-	err := json.NewDecoder(r.Body).Decode(&comment)
+	var update m.UpdateComment
+	var comment m.Comment
+
+	err := json.NewDecoder(r.Body).Decode(&update)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Bad Comment")
 		log.Printf("Unable to decode new comment: %v", err)
@@ -587,22 +583,54 @@ func PATCHImageComment(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 	query := `UPDATE comments
 			  SET comment_text=$1, updated_at=(now() AT TIME ZONE 'utc'::text)
-              WHERE id=$2 AND commenter_id=(SELECT user_id FROM users WHERE auth_zero_id=$3)`
+              WHERE id=$2 AND commenter_id=(SELECT user_id FROM users WHERE auth_zero_id=$3)
+              AND EXISTS (` + liveImageQuery("comments.image_id") + `)
+              RETURNING id, image_id, commenter_id, comment_text, created_at, updated_at, seen`
 
-	status, err := connPool.Pool.Exec(ctx, query, comment.Comment, comment.ID, uid)
+	// The edit and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
 	if err != nil {
+		WriteErrorToWriter(w, "Error: Couldn't start transaction")
+		log.Printf("Couldn't start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, query, update.Comment, update.ID, uid).Scan(&comment.ID, &comment.ImageID, &comment.UserID,
+		&comment.Comment, &comment.CreatedAt, &comment.UpdatedAt, &comment.Seen)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Comment not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Comment could not be updated")
 		log.Printf("Comment could not be updated: %v", err)
 		return
 	}
 
-	if status.RowsAffected() < 1 {
-		WriteErrorToWriter(w, "Error: Return SQL status is not update")
-		log.Printf("Return SQL status is not update %v", err)
+	err = queryCommentContext(ctx, tx, &comment)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Comment could not be updated")
+		log.Printf("Could not get comment data: %v", err)
+		return
+	}
+
+	// A comment can be edited any number of times, every edit is its own event
+	err = EnqueueEvent(ctx, tx, uuid.NewString(), events.CommentEdited{Comment: comment})
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Could not queue comment event")
+		log.Printf("Could not queue comment event: %v", err)
 		return
 	}
 
-	responseJSON, err := json.Marshal(status.Update())
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Comment could not be updated")
+		log.Printf("Couldn't commit comment update: %v", err)
+		return
+	}
+
+	responseJSON, err := json.Marshal(true)
 	if err != nil {
 		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
 		return
@@ -650,6 +678,18 @@ func GETImageComments(ctx context.Context, w http.ResponseWriter, r *http.Reques
 				ON u.user_id = c.commenter_id
 				WHERE image_id=$1`
 
+	var live bool
+	err = connPool.Pool.QueryRow(ctx, `SELECT EXISTS (`+liveImageQuery("$1")+`)`, imageId).Scan(&live)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Unable to query comments from DB")
+		log.Printf("Unable to look up image: %v", err)
+		return
+	}
+	if !live {
+		WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+		return
+	}
+
 	result, err := connPool.Pool.Query(ctx, query, imageId)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Unable to query comments from DB")
@@ -696,55 +736,41 @@ func POSTNewComment(ctx context.Context, w http.ResponseWriter, r *http.Request,
 
 	// Add the comment to the comment table
 	addCommentQuery := `INSERT INTO comments (comment_text, image_id, commenter_id)
-			  			VALUES ($1, $2, (SELECT user_id FROM users WHERE auth_zero_id=$3))
+			  			SELECT $1, $2, (SELECT user_id FROM users WHERE auth_zero_id=$3)
+			  			WHERE EXISTS (` + liveImageQuery("$2") + `)
 			  			RETURNING id, commenter_id, comment_text, created_at, seen`
 
-	imageDataQuery := `SELECT image_owner, ia.album_id, a.album_name
-						FROM images i
-						JOIN imagealbum ia
-						ON i.image_id = ia.image_id
-						JOIN albums a 
-						ON a.album_id = ia.album_id
-						WHERE i.image_id = $1`
-
-	commenterInfoQuery := `SELECT first_name, last_name FROM users WHERE user_id = $1`
+	// The comment and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Couldn't start transaction")
+		log.Printf("Couldn't start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
 
-	err = connPool.Pool.QueryRow(ctx, addCommentQuery, comment.Comment, comment.ImageID, uid).Scan(
+	err = tx.QueryRow(ctx, addCommentQuery, comment.Comment, comment.ImageID, uid).Scan(
 		&comment.ID, &comment.UserID, &comment.Comment, &comment.CreatedAt, &comment.Seen)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Image not found")
+			return
+		}
 		WriteErrorToWriter(w, "Error: Couldn't post comment")
 		log.Printf("Couldn't post comment: %v", err)
 		return
 	}
 
-	batch := &pgx.Batch{}
-	batch.Queue(imageDataQuery, comment.ImageID)
-	batch.Queue(commenterInfoQuery, comment.UserID)
-	batchResults := connPool.Pool.SendBatch(ctx, batch)
-	defer func() {
-		err := batchResults.Close()
-		if err != nil {
-			log.Printf("%v", err)
-			return
-		}
-	}()
-
-	err = batchResults.QueryRow().Scan(&comment.ImageOwner, &comment.AlbumID, &comment.AlbumName)
+	err = queryCommentContext(ctx, tx, &comment)
 	if err != nil {
 		WriteErrorToWriter(w, "Error: Could not get image owner")
-		log.Printf("Could not get image owner: %v", err)
-		return
-	}
-	err = batchResults.QueryRow().Scan(&comment.FirstName, &comment.LastName)
-	if err != nil {
-		WriteErrorToWriter(w, "Error: Could not get commenter information")
-		log.Printf("Could not get commenter information: %v", err)
+		log.Printf("Could not get comment data: %v", err)
 		return
 	}
 
 	if comment.ImageOwner == comment.UserID {
 		markRead := `UPDATE comments SET seen = true WHERE id = $1`
-		_, err = connPool.Pool.Exec(ctx, markRead, comment.ID)
+		_, err = tx.Exec(ctx, markRead, comment.ID)
 		if err != nil {
 			WriteErrorToWriter(w, "Error: Could not get mark comment as read")
 			log.Printf("Could not get mark comment as read: %v", err)
@@ -752,28 +778,18 @@ func POSTNewComment(ctx context.Context, w http.ResponseWriter, r *http.Request,
 		}
 	}
 
-	payload := WebSocketPayload{
-		Operation: `ADD`,
-		Type:      `comment`,
-		UserID:    comment.ImageOwner,
-		AlbumID:   comment.AlbumID,
-		Payload:   comment,
-	}
-
-	// Send payload to WebSocket
-	jsonPayload, jsonErr := json.MarshalIndent(payload, "", "\t")
-	if jsonErr != nil {
-		log.Print(jsonErr)
-	}
-
-	err = rdb.Publish(ctx, "notifications", jsonPayload).Err()
+	err = EnqueueEvent(ctx, tx, "comment:"+comment.ID, events.CommentAdded{Comment: comment})
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Could not queue comment event")
+		log.Printf("Could not queue comment event: %v", err)
+		return
 	}
 
-	err = rdb.Publish(ctx, payload.AlbumID, jsonPayload).Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Print(err)
+		WriteErrorToWriter(w, "Error: Couldn't post comment")
+		log.Printf("Couldn't commit comment: %v", err)
+		return
 	}
 
 	responseJSON, err := json.Marshal(comment)
@@ -786,13 +802,46 @@ func POSTNewComment(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	w.Write(responseJSON)
 }
 
+// queryCommentContext fills in the image owner, album and commenter name that the comment's events are routed with
+func queryCommentContext(ctx context.Context, tx pgx.Tx, comment *m.Comment) error {
+	imageDataQuery := `SELECT image_owner, ia.album_id, a.album_name
+						FROM images i
+						JOIN imagealbum ia
+						ON i.image_id = ia.image_id
+						JOIN albums a 
+						ON a.album_id = ia.album_id
+						WHERE i.image_id = $1`
+
+	commenterInfoQuery := `SELECT first_name, last_name FROM users WHERE user_id = $1`
+
+	batch := &pgx.Batch{}
+	batch.Queue(imageDataQuery, comment.ImageID)
+	batch.Queue(commenterInfoQuery, comment.UserID)
+	batchResults := tx.SendBatch(ctx, batch)
+
+	err := batchResults.QueryRow().Scan(&comment.ImageOwner, &comment.AlbumID, &comment.AlbumName)
+	if err != nil {
+		batchResults.Close()
+		return fmt.Errorf("image owner: %w", err)
+	}
+	err = batchResults.QueryRow().Scan(&comment.FirstName, &comment.LastName)
+	if err != nil {
+		batchResults.Close()
+		return fmt.Errorf("commenter information: %w", err)
+	}
+
+	// The batch has to be closed before the transaction can be used again
+	return batchResults.Close()
+}
+
 func GETImagesFromUserID(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
 	var images []m.Image
 
 	query := `
 			SELECT image_id, image_owner, caption, upload_type, created_at
 			FROM images
-			WHERE image_owner = (SELECT user_id FROM users WHERE auth_zero_id=$1);`
+			WHERE image_owner = (SELECT user_id FROM users WHERE auth_zero_id=$1)
+			AND deleted_at IS NULL;`
 	result, err := connPool.Pool.Query(ctx, query, uid)
 	if err != nil {
 		log.Print(err)
@@ -831,13 +880,14 @@ func GETImageFromID(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	}
 
 	query := `SELECT image_id, image_owner, caption, upvotes, created_at
-			  FROM images WHERE image_id = $1`
+			  FROM images WHERE image_id = $1
+			  AND EXISTS (` + liveImageQuery("$1") + `)`
 
 	results := connPool.Pool.QueryRow(ctx, query, uid)
 	err = results.Scan(&image.ID, &image.ImageOwner, &image.Caption, &image.Upvotes, &image.CapturedAt)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			WriteErrorToWriter(w, "Error: Image does not exist")
+		if errors.Is(err, pgx.ErrNoRows) {
+			WriteResponseWithCode(w, http.StatusNotFound, "Error: Image does not exist")
 			log.Print("Error: Image does not exist")
 			return
 		} else {
@@ -945,7 +995,28 @@ func POSTNewImage(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 	imageCreationQuery := `INSERT INTO images
 			  (image_id, image_owner, caption, upload_type, captured_at) VALUES ($1,(SELECT user_id FROM users WHERE auth_zero_id=$2), $3, $4, $5)
 			  RETURNING image_id, created_at`
-	err = connPool.Pool.QueryRow(ctx, imageCreationQuery, image.ID, image.ImageOwner, image.Caption,
+
+	addImageAlbum := `INSERT INTO imagealbum
+					(image_id, album_id) VALUES ($1, $2)`
+
+	getUploaderData := `SELECT first_name, last_name, user_id FROM users WHERE auth_zero_id=$1`
+
+	albumMembersQuery := `SELECT a.album_name, array_agg(au.user_id)
+							FROM albums a
+							JOIN albumuser au ON au.album_id = a.album_id
+							WHERE a.album_id = $1
+							GROUP BY a.album_name`
+
+	// The image and its event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Unable to create image in database")
+		log.Printf("Could not start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, imageCreationQuery, image.ID, image.ImageOwner, image.Caption,
 		image.UploadType, image.CapturedAt).Scan(&image.ID, &image.CapturedAt)
 	if err != nil {
 		WriteErrorToWriter(w, "Unable to create image in database")
@@ -953,23 +1024,42 @@ func POSTNewImage(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 		return
 	}
 
-	addImageAlbum := `INSERT INTO imagealbum
-					(image_id, album_id) VALUES ($1, $2)`
-	_, err = connPool.Pool.Exec(ctx, addImageAlbum, image.ID, album_id)
+	_, err = tx.Exec(ctx, addImageAlbum, image.ID, album_id)
 	if err != nil {
 		WriteErrorToWriter(w, "Unable to associate image to album")
 		log.Printf("Unable to associate image to album: %v", err)
 		return
 	}
 
-	getUploaderData := `SELECT first_name, last_name, user_id FROM users WHERE auth_zero_id=$1`
-	err = connPool.Pool.QueryRow(ctx, getUploaderData, image.ImageOwner).Scan(&image.FirstName, &image.LastName, &image.ImageOwner)
+	err = tx.QueryRow(ctx, getUploaderData, image.ImageOwner).Scan(&image.FirstName, &image.LastName, &image.ImageOwner)
 	if err != nil {
 		WriteErrorToWriter(w, "Unable to get uploader data")
 		log.Printf("Unable to get uploader data: %v", err)
 		return
 	}
 
+	uploaded := events.ImageUploaded{Image: image, AlbumID: album_id}
+	err = tx.QueryRow(ctx, albumMembersQuery, album_id).Scan(&uploaded.AlbumName, &uploaded.MemberIDs)
+	if err != nil {
+		WriteErrorToWriter(w, "Unable to associate image to album")
+		log.Printf("Unable to query album members: %v", err)
+		return
+	}
+
+	err = EnqueueEvent(ctx, tx, "image:"+image.ID+":uploaded", uploaded)
+	if err != nil {
+		WriteErrorToWriter(w, "Unable to create image in database")
+		log.Printf("Unable to enqueue image upload: %v", err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteErrorToWriter(w, "Unable to create image in database")
+		log.Printf("Couldn't commit image: %v", err)
+		return
+	}
+
 	insertResponse, err := json.MarshalIndent(image, "", "\t")
 	if err != nil {
 		log.Print(err)
@@ -1042,23 +1132,37 @@ func PATCHUpdateImageAlbum(ctx context.Context, w http.ResponseWriter, r *http.R
 }
 
 func QueryImagesData(ctx context.Context, connPool *m.PGPool, album *m.Album, uid string) {
+	images, err := queryAlbumImages(ctx, connPool, album.AlbumID, uid, pageRequest{})
+	if err != nil {
+		log.Print(err)
+	}
+	album.Images = images
+}
+
+// queryAlbumImages returns the page of the album's images newest first, together with the engagement of the user.
+// The page query returns one row more than the page, see newPage.
+func queryAlbumImages(ctx context.Context, connPool *m.PGPool, albumID string, uid string, page pageRequest) ([]m.Image, error) {
 	imageQuery := `SELECT i.image_id, i.image_owner, u.first_name, u.last_name, i.caption, i.upload_type,
-                      (SELECT COUNT(*) FROM likes l WHERE l.image_id = i.image_id) AS like_count,
-                      (SELECT COUNT(*) FROM upvotes up WHERE up.image_id = i.image_id) AS upvote_count,
+                      i.like_count, i.upvote_count,
                       EXISTS (SELECT 1 FROM likes l WHERE l.image_id = i.image_id AND l.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)) AS user_has_liked,
                       EXISTS (SELECT 1 FROM upvotes up WHERE up.image_id = i.image_id AND up.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $2)) AS user_has_upvoted,
                       i.created_at
 					  FROM images i
 					  JOIN imagealbum ia ON i.image_id = ia.image_id
 					  JOIN users u ON i.image_owner = u.user_id
-					  WHERE ia.album_id = $1`
+					  WHERE ia.album_id = $1
+					  AND i.deleted_at IS NULL
+					  AND ($3::timestamptz IS NULL OR (i.created_at, i.image_id) < ($3, $4::uuid))
+					  ORDER BY i.created_at DESC, i.image_id DESC
+					  LIMIT $5`
 
 	images := []m.Image{}
 
 	//Fetch Albums Images
-	imageResponse, err := connPool.Pool.Query(ctx, imageQuery, album.AlbumID, uid)
+	imageResponse, err := connPool.Pool.Query(ctx, imageQuery, albumID, uid, page.cursorTime, page.cursorID,
+		page.queryLimit())
 	if err != nil {
-		log.Print(err)
+		return images, err
 	}
 	defer imageResponse.Close()
 
@@ -1067,12 +1171,15 @@ func QueryImagesData(ctx context.Context, connPool *m.PGPool, album *m.Album, ui
 		var image m.Image
 
 		err = imageResponse.Scan(&image.ID, &image.ImageOwner, &image.FirstName, &image.LastName, &image.Caption,
-			&image.UploadType, &image.Likes, &image.Upvotes, &image.UserLiked, &image.UserUpvoted, &image.CapturedAt)
+			&image.UploadType, &image.Likes, &image.Upvotes, &image.UserLiked, &image.UserUpvoted, &image.CreatedAt)
 		if err != nil {
-			log.Print(err)
+			return images, err
 		}
+		// Clients read the upload time from captured_at
+		image.CapturedAt = image.CreatedAt
 
 		images = append(images, image)
 	}
-	album.Images = images
+
+	return images, imageResponse.Err()
 }
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeletedImagesAreNotFound(t *testing.T) {
+	ctx := context.Background()
+	connPool := testPool(t)
+	rdb := newTestRedis(t)
+
+	ownerID, _ := seedUser(t, connPool, "Ana")
+	guestID, guestAuthZeroID := seedUser(t, connPool, "Ben")
+	album := seedAlbums(t, connPool, ownerID, []string{guestID}, 1, 1)[0]
+
+	var imageID string
+	err := connPool.Pool.QueryRow(ctx, `SELECT image_id FROM imagealbum WHERE album_id = $1`, album.AlbumID).Scan(&imageID)
+	if err != nil {
+		t.Fatalf("look up image: %v", err)
+	}
+
+	_, err = connPool.Pool.Exec(ctx, `UPDATE albums SET deleted_at = now() WHERE album_id = $1`, album.AlbumID)
+	if err != nil {
+		t.Fatalf("delete album: %v", err)
+	}
+
+	requests := map[string]func(w http.ResponseWriter){
+		"like": func(w http.ResponseWriter) {
+			POSTImageLike(ctx, w, httptest.NewRequest(http.MethodPost, "/image/like?image_id="+imageID, nil), connPool, rdb, guestAuthZeroID)
+		},
+		"unlike": func(w http.ResponseWriter) {
+			DELETEImageLike(ctx, w, httptest.NewRequest(http.MethodDelete, "/image/like?image_id="+imageID, nil), connPool, rdb, guestAuthZeroID)
+		},
+		"upvote": func(w http.ResponseWriter) {
+			POSTImageUpvote(ctx, w, httptest.NewRequest(http.MethodPost, "/image/upvote?image_id="+imageID, nil), connPool, rdb, guestAuthZeroID)
+		},
+		"comment": func(w http.ResponseWriter) {
+			body := `{"image_id": "` + imageID + `", "comment": "Nice"}`
+			POSTNewComment(ctx, w, httptest.NewRequest(http.MethodPost, "/image/comment", strings.NewReader(body)), connPool, rdb, guestAuthZeroID)
+		},
+		"comments": func(w http.ResponseWriter) {
+			GETImageComments(ctx, w, httptest.NewRequest(http.MethodGet, "/image/comment?image_id="+imageID, nil), connPool)
+		},
+		"image": func(w http.ResponseWriter) {
+			GETImageFromID(ctx, w, httptest.NewRequest(http.MethodGet, "/user/album/image?uid="+imageID, nil), connPool)
+		},
+	}
+
+	for name, request := range requests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request(w)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("status = %d: %s, want %d", w.Code, w.Body, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -3,14 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/redis/go-redis/v9"
+	"io"
+	"last_weekend_services/src/events"
 	m "last_weekend_services/src/models"
 	"log"
 	"net/http"
+	"time"
 )
 
 func NotificationsEndpointHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client) http.Handler {
@@ -23,24 +28,59 @@ func NotificationsEndpointHandler(ctx context.Context, connPool *m.PGPool, rdb *
 
 		switch r.Method {
 		case http.MethodGet:
-			GETExistingNotifications(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			switch r.URL.Path {
+			case "/notifications":
+				GETNotificationInbox(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			case "/notifications/summary":
+				GETNotificationSummaries(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			case "/notifications/legacy":
+				GETExistingNotifications(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			}
 		case http.MethodPatch:
-			PATCHMarkNotificationSeen(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			switch r.URL.Path {
+			case "/notifications":
+				PATCHMarkNotificationSeen(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			case "/notifications/read":
+				PATCHMarkNotificationsRead(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			}
 		}
 
 	})
 }
 
+// GETExistingNotifications is the notification response from before the inbox, served on /notifications/legacy until
+// every client reads GET /notifications
 func GETExistingNotifications(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
 	var notifications m.Notification
 
 	//searchDate := time.Now().AddDate(0, -6, 0).Format("2006-01-02 15:04:05")
 
-	friendRequests, _ := QueryFriendRequests(ctx, w, connPool, uid)
-	albumRequests, _ := QueryAlbumRequests(ctx, w, connPool, uid)
-	albumRequestsResponses, _ := QueryAlbumRequestResponses(ctx, w, connPool, uid)
-	engagementNotifications, _ := QueryEngagementNotifications(ctx, w, connPool, uid)
-	commentNotifications, _ := QueryCommentNotifications(ctx, w, connPool, uid)
+	// The query functions write their error to the response
+	friendRequests, err := QueryFriendRequests(ctx, w, connPool, uid)
+	if err != nil {
+		log.Printf("Unable to query friend requests: %v", err)
+		return
+	}
+	albumRequests, err := QueryAlbumRequests(ctx, w, connPool, uid)
+	if err != nil {
+		log.Printf("Unable to query album requests: %v", err)
+		return
+	}
+	albumRequestsResponses, err := QueryAlbumRequestResponses(ctx, w, connPool, uid)
+	if err != nil {
+		log.Printf("Unable to query album request responses: %v", err)
+		return
+	}
+	engagementNotifications, err := QueryEngagementNotifications(ctx, w, connPool, uid)
+	if err != nil {
+		log.Printf("Unable to query engagement notifications: %v", err)
+		return
+	}
+	commentNotifications, err := QueryCommentNotifications(ctx, w, connPool, uid)
+	if err != nil {
+		log.Printf("Unable to query comment notifications: %v", err)
+		return
+	}
 
 	notifications.FriendRequests = friendRequests
 	notifications.AlbumRequests = albumRequests
@@ -59,6 +99,272 @@ func GETExistingNotifications(ctx context.Context, w http.ResponseWriter, r *htt
 
 }
 
+const defaultInboxLimit = 25
+
+// GETNotificationInbox returns the user's notifications newest first. The page is picked with limit and the
+// next_cursor of the previous page, unread=true only returns unread notifications.
+func GETNotificationInbox(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	inbox := m.NotificationInbox{
+		Notifications: []m.InboxNotification{},
+		UnreadByType:  map[string]int{},
+	}
+
+	page, err := parsePageRequest(r, defaultInboxLimit)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	var userID string
+
+	err = connPool.Pool.QueryRow(ctx, `SELECT user_id FROM users WHERE auth_zero_id = $1`, uid).Scan(&userID)
+	if err != nil {
+		log.Printf("Unable to lookup requesting user: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	// Notifications about albums that were deleted are hidden until the album is purged and they cascade
+	inboxQuery := `SELECT n.notification_id, n.type, n.actor_id, n.album_id, n.subject_id, n.payload, n.read_at, n.created_at
+					FROM user_notifications n
+					LEFT JOIN albums a ON a.album_id = n.album_id
+					WHERE n.recipient_id = $1
+					AND a.deleted_at IS NULL
+					AND ($2::timestamptz IS NULL OR (n.created_at, n.notification_id) < ($2, $3::uuid))
+					AND (NOT $4 OR n.read_at IS NULL)
+					ORDER BY n.created_at DESC, n.notification_id DESC
+					LIMIT $5`
+
+	batch := &pgx.Batch{}
+	batch.Queue(inboxQuery, userID, page.cursorTime, page.cursorID, unreadOnly, page.queryLimit())
+	batch.Queue(unreadCountsQuery, userID)
+	batchResults := connPool.Pool.SendBatch(ctx, batch)
+	defer batchResults.Close()
+
+	rows, err := batchResults.Query()
+	if err != nil {
+		log.Printf("Unable to query notification inbox: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	for rows.Next() {
+		var notification m.InboxNotification
+
+		err = rows.Scan(&notification.NotificationID, &notification.Type, &notification.ActorID, &notification.AlbumID,
+			&notification.SubjectID, &notification.Payload, &notification.ReadAt, &notification.CreatedAt)
+		if err != nil {
+			log.Printf("Unable to scan notification: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+			return
+		}
+
+		notification.Read = notification.ReadAt != nil
+		inbox.Notifications = append(inbox.Notifications, notification)
+	}
+	if rows.Err() != nil {
+		log.Printf("Unable to read notification inbox: %v", rows.Err())
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	notifications := newPage(inbox.Notifications, page, func(notification m.InboxNotification) (time.Time, string) {
+		return notification.CreatedAt, notification.NotificationID
+	})
+	inbox.Notifications, inbox.NextCursor = notifications.Items, notifications.NextCursor
+
+	unreadRows, err := batchResults.Query()
+	if err != nil {
+		log.Printf("Unable to query unread counts: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	inbox.UnreadCount, inbox.UnreadByType, err = scanUnreadCounts(unreadRows)
+	if err != nil {
+		log.Printf("Unable to read unread counts: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(inbox, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal notification inbox: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notifications")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+// unreadCountsQuery counts the unread notifications of the user $1 by type, the badges of the app
+const unreadCountsQuery = `SELECT n.type, COUNT(*)
+							FROM user_notifications n
+							LEFT JOIN albums a ON a.album_id = n.album_id
+							WHERE n.recipient_id = $1
+							AND a.deleted_at IS NULL
+							AND n.read_at IS NULL
+							GROUP BY n.type`
+
+// queryUnreadCount is the total of the user's unread counts
+func queryUnreadCount(ctx context.Context, connPool *m.PGPool, userID string) (int, error) {
+	rows, err := connPool.Pool.Query(ctx, unreadCountsQuery, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	total, _, err := scanUnreadCounts(rows)
+	return total, err
+}
+
+func scanUnreadCounts(rows pgx.Rows) (int, map[string]int, error) {
+	defer rows.Close()
+
+	total := 0
+	byType := map[string]int{}
+
+	for rows.Next() {
+		var notificationType string
+		var count int
+
+		err := rows.Scan(&notificationType, &count)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		byType[notificationType] = count
+		total += count
+	}
+
+	return total, byType, rows.Err()
+}
+
+// PATCHMarkNotificationsRead marks every notification matching the request as read, in the inbox and in the tables the
+// legacy notifications are read from. The new badges are returned and sent to the user's other devices.
+func PATCHMarkNotificationsRead(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	var filter m.MarkReadRequest
+	var userID string
+
+	// An empty body marks everything as read
+	err := json.NewDecoder(r.Body).Decode(&filter)
+	if err != nil && !errors.Is(err, io.EOF) {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Unable to read the request")
+		log.Printf("Unable to decode mark read request: %v", err)
+		return
+	}
+
+	userQuery := `SELECT user_id FROM users WHERE auth_zero_id = $1`
+
+	// The legacy seen flags follow the inbox rows that were marked, so both views agree
+	markReadQuery := `WITH marked AS (
+							UPDATE user_notifications
+							SET read_at = $2
+							WHERE recipient_id = $1
+							AND read_at IS NULL
+							AND (cardinality($3::uuid[]) = 0 OR notification_id = ANY($3))
+							AND ($4::timestamptz IS NULL OR created_at <= $4)
+							AND ($5 = '' OR type = $5)
+							AND ($6 = '' OR album_id = NULLIF($6, '')::uuid)
+							RETURNING type, subject_id
+						), engagement AS (
+							UPDATE notifications
+							SET seen = true
+							WHERE receiver_id = $1
+							AND (media_id::text, CASE type WHEN 'liked' THEN 'image.liked' ELSE 'image.upvoted' END)
+								IN (SELECT subject_id, type FROM marked)
+						), comment AS (
+							UPDATE comments
+							SET seen = true
+							WHERE id::text IN (SELECT subject_id FROM marked WHERE type = 'comment.added')
+						), friend_request AS (
+							UPDATE friend_requests
+							SET seen = true
+							WHERE request_id::text IN (SELECT subject_id FROM marked
+														WHERE type IN ('friend_request.sent', 'friend_request.accepted'))
+						), album_request AS (
+							UPDATE album_requests
+							SET invite_seen = invite_seen OR request_id::text IN (SELECT subject_id FROM marked WHERE type = 'album_invite.sent'),
+								response_seen = response_seen OR request_id::text IN (SELECT subject_id FROM marked
+																						WHERE type IN ('album_invite.accepted', 'album_invite.denied'))
+							WHERE request_id::text IN (SELECT subject_id FROM marked WHERE type LIKE 'album_invite.%')
+						)
+						SELECT COUNT(*) FROM marked`
+
+	notificationIDs := filter.NotificationIDs
+	if notificationIDs == nil {
+		notificationIDs = []string{}
+	}
+
+	state := m.NotificationReadState{ReadAt: time.Now().UTC()}
+
+	// The read state and its sync event are committed together
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Couldn't start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, userQuery, uid).Scan(&userID)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Unable to lookup requesting user: %v", err)
+		return
+	}
+
+	err = tx.QueryRow(ctx, markReadQuery, userID, state.ReadAt, notificationIDs, filter.Before, filter.Type,
+		filter.AlbumID).Scan(&state.Marked)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Unable to mark notifications as read: %v", err)
+		return
+	}
+
+	unreadRows, err := tx.Query(ctx, unreadCountsQuery, userID)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Unable to query unread counts: %v", err)
+		return
+	}
+
+	state.UnreadCount, state.UnreadByType, err = scanUnreadCounts(unreadRows)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Unable to read unread counts: %v", err)
+		return
+	}
+
+	if state.Marked > 0 {
+		err = EnqueueEvent(ctx, tx, uuid.NewString(), events.NotificationsRead{UserID: userID, Filter: filter, State: state})
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+			log.Printf("Could not queue read event: %v", err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		log.Printf("Couldn't commit read state: %v", err)
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal read state: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mark notifications as read")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
 func QueryAlbumRequests(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, uid string) ([]m.AlbumRequestNotification, error) {
 	var albumRequests []m.AlbumRequestNotification
 
@@ -72,6 +378,7 @@ func QueryAlbumRequests(ctx context.Context, w http.ResponseWriter, connPool *m.
 						JOIN users u ON u.user_id = a.album_owner
 						JOIN users u2 ON ar.invited_id = u2.user_id
 						WHERE invited_id = (SELECT user_id FROM users WHERE auth_zero_id=$1)
+						AND a.deleted_at IS NULL
 						AND (ar.status = 'pending' OR (ar.status ='accepted') 
 						AND a.revealed_at > now() AT TIME ZONE 'utc'
 						AND a.album_owner != (SELECT user_id FROM users WHERE auth_zero_id=$1))`
@@ -115,7 +422,8 @@ func QueryAlbumRequestResponses(ctx context.Context, w http.ResponseWriter, conn
 									JOIN users u2 on a.album_owner = u2.user_id
 									WHERE (a.album_owner = (SELECT user_id FROM users WHERE auth_zero_id=$1)
 									AND ar.status='accepted')
-									AND ar.response_seen = false`
+									AND ar.response_seen = false
+									AND a.deleted_at IS NULL`
 
 	rows, err := connPool.Pool.Query(ctx, querySentAlbumInviteResponses, uid)
 	if err != nil {
@@ -217,6 +525,7 @@ func QueryCommentNotifications(ctx context.Context, w http.ResponseWriter, connP
 					ON ia.album_id = a.album_id
 					WHERE (i.image_owner=(SELECT user_id FROM users WHERE auth_zero_id=$1) 
 					           AND commenter_id != (SELECT user_id FROM users WHERE auth_zero_id=$1))
+					AND a.deleted_at IS NULL
 					LIMIT 25`
 	rows, err := connPool.Pool.Query(ctx, commentQuery, uid)
 	if err != nil {
@@ -242,56 +551,6 @@ func QueryCommentNotifications(ctx context.Context, w http.ResponseWriter, connP
 	return notifications, nil
 }
 
-func QuerySummaryNotifications(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, uid string, dateString string, searchType string) ([]m.SummaryNotification, error) {
-	var summaryNotifications []m.SummaryNotification
-	genericNotificationQuery := `WITH AlbumTotals AS (
-								SELECT album_id, COUNT(*) AS total
-								FROM notifications
-								WHERE type = $2
-								GROUP BY album_id)
-								
-								SELECT u.first_name, a.album_name, n.album_id, a.album_cover_id, n.type, n.received_at, at.total
-								FROM (
-								SELECT sender_id, album_id, seen, receiver_id, type, received_at,
-									row_number() OVER (PARTITION BY album_id ORDER BY received_at DESC) AS recent
-								FROM notifications) AS n
-								JOIN users u
-								ON sender_id = u.user_id
-								JOIN albums a
-								ON a.album_id = n.album_id
-								JOIN AlbumTotals at
-								ON n.album_id = at.album_id
-								WHERE recent <= 2
-								AND receiver_id = (SELECT user_id FROM users WHERE auth_zero_id=$1)
-								AND type = $2
-								AND received_at > $3`
-
-	rows, err := connPool.Pool.Query(ctx, genericNotificationQuery, uid, searchType, dateString)
-	if err != nil {
-		fmt.Fprintf(w, "Failed to get query DB: %v", err)
-		return nil, err
-	}
-
-	for rows.Next() {
-		var summary m.SummaryNotification
-
-		err := rows.Scan(&summary.NameOne, &summary.AlbumName, &summary.AlbumID, &summary.AlbumCoverID, &summary.NotificationType, &summary.ReceivedAt, &summary.AlbumTypeTotal)
-		if err != nil {
-			fmt.Fprintf(w, "Failed to insert data to object: %v", err)
-			return nil, err
-		}
-
-		existingSummary := lookupSummaryByAlbumAndType(summaryNotifications, summary.AlbumID, summary.NotificationType)
-		if existingSummary != nil {
-			existingSummary.NameTwo = summary.NameOne
-			continue
-		}
-		summaryNotifications = append(summaryNotifications, summary)
-	}
-
-	return summaryNotifications, nil
-}
-
 func QueryEngagementNotifications(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, uid string) ([]m.EngagementNotification, error) {
 	var notifications []m.EngagementNotification
 
@@ -303,6 +562,7 @@ func QueryEngagementNotifications(ctx context.Context, w http.ResponseWriter, co
 						JOIN albums a 
 						ON n.album_id = a.album_id
 						WHERE receiver_id=(SELECT user_id FROM users WHERE auth_zero_id=$1)
+						AND a.deleted_at IS NULL
 						LIMIT 25`
 
 	rows, err := connPool.Pool.Query(ctx, engagementQuery, uid)
@@ -328,14 +588,141 @@ func QueryEngagementNotifications(ctx context.Context, w http.ResponseWriter, co
 
 }
 
-// The following function will check to see if a summary for an Album and Noti type combination is already created to append to it
-func lookupSummaryByAlbumAndType(slice []m.SummaryNotification, id string, notificationType string) *m.SummaryNotification {
-	for _, item := range slice {
-		if item.AlbumID == id && item.NotificationType == notificationType {
-			return &item
+const (
+	defaultSummaryWindow = 24 * time.Hour
+	defaultSummarySince  = 7 * 24 * time.Hour
+	maxSummarySince      = 30 * 24 * time.Hour
+	// Names listed in a summary, the rest are counted in ActorTotal
+	summaryActorLimit = 3
+)
+
+// summaryTypes are the notifications that are aggregated, the rest stay one notification per event
+var summaryTypes = []string{string(events.ImageLikedType), string(events.ImageUpvotedType), string(events.CommentAddedType)}
+
+// GETNotificationSummaries returns the user's engagement grouped by album, type and window, newest first. window and
+// since are durations like 6h, type and album_id narrow the summaries down.
+func GETNotificationSummaries(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
+	var userID string
+
+	window, err := durationParam(r, "window", defaultSummaryWindow)
+	if err != nil || window < time.Minute {
+		WriteResponseWithCode(w, http.StatusBadRequest, "window has to be a duration of at least a minute")
+		return
+	}
+
+	since, err := durationParam(r, "since", defaultSummarySince)
+	if err != nil || since <= 0 || since > maxSummarySince {
+		WriteResponseWithCode(w, http.StatusBadRequest, "since has to be a duration of at most 30 days")
+		return
+	}
+
+	err = connPool.Pool.QueryRow(ctx, `SELECT user_id FROM users WHERE auth_zero_id = $1`, uid).Scan(&userID)
+	if err != nil {
+		log.Printf("Unable to lookup requesting user: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notification summaries")
+		return
+	}
+
+	summaries, err := QueryNotificationSummaries(ctx, connPool, userID, time.Now().Add(-since), window,
+		r.URL.Query().Get("album_id"), r.URL.Query().Get("type"))
+	if err != nil {
+		log.Printf("Unable to query notification summaries: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notification summaries")
+		return
+	}
+
+	responseBytes, err := json.MarshalIndent(summaries, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal notification summaries: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notification summaries")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+// QueryNotificationSummaries aggregates the user's engagement notifications since the given time. Windows are aligned
+// to the epoch, so an event always falls in the same window. An empty albumID or notificationType matches all.
+func QueryNotificationSummaries(ctx context.Context, connPool *m.PGPool, userID string, since time.Time, window time.Duration, albumID string, notificationType string) ([]m.SummaryNotification, error) {
+	summaries := []m.SummaryNotification{}
+
+	summaryQuery := `SELECT n.album_id, a.album_name, a.album_cover_id, n.type,
+							to_timestamp(floor(extract(epoch FROM n.created_at) / $3) * $3) AS window_start,
+							COUNT(*), COUNT(DISTINCT n.payload->>'image_id'), COUNT(DISTINCT n.actor_id),
+							MAX(n.created_at), bool_and(n.read_at IS NOT NULL),
+							array_agg(COALESCE(n.actor_id::text, '') ORDER BY n.created_at DESC),
+							array_agg(COALESCE(u.first_name, '') ORDER BY n.created_at DESC)
+					FROM user_notifications n
+					JOIN albums a ON a.album_id = n.album_id
+					LEFT JOIN users u ON u.user_id = n.actor_id
+					WHERE n.recipient_id = $1
+					AND n.created_at > $2
+					AND n.type = ANY($4)
+					AND ($5 = '' OR n.type = $5)
+					AND ($6 = '' OR n.album_id = NULLIF($6, '')::uuid)
+					AND a.deleted_at IS NULL
+					GROUP BY n.album_id, a.album_name, a.album_cover_id, n.type, window_start
+					ORDER BY MAX(n.created_at) DESC`
+
+	rows, err := connPool.Pool.Query(ctx, summaryQuery, userID, since, window.Seconds(), summaryTypes, notificationType, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary m.SummaryNotification
+		var actorIDs, actorNames []string
+
+		err = rows.Scan(&summary.AlbumID, &summary.AlbumName, &summary.AlbumCoverID, &summary.NotificationType,
+			&summary.WindowStart, &summary.AlbumTypeTotal, &summary.PhotoTotal, &summary.ActorTotal, &summary.ReceivedAt,
+			&summary.RequestSeen, &actorIDs, &actorNames)
+		if err != nil {
+			return nil, err
+		}
+
+		summary.Actors = summaryActors(actorIDs, actorNames)
+		if len(summary.Actors) > 0 {
+			summary.NameOne = summary.Actors[0].FirstName
+		}
+		if len(summary.Actors) > 1 {
+			summary.NameTwo = summary.Actors[1].FirstName
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
+
+// summaryActors lists the distinct actors of a summary, most recent first. An actor that engaged several times is
+// listed at their latest engagement.
+func summaryActors(actorIDs []string, actorNames []string) []m.SummaryActor {
+	actors := []m.SummaryActor{}
+	listed := make(map[string]bool)
+
+	for i, actorID := range actorIDs {
+		if actorID == "" || listed[actorID] {
+			continue
 		}
+		listed[actorID] = true
+
+		actors = append(actors, m.SummaryActor{UserID: actorID, FirstName: actorNames[i]})
+		if len(actors) == summaryActorLimit {
+			break
+		}
+	}
+
+	return actors
+}
+
+func durationParam(r *http.Request, name string, fallback time.Duration) (time.Duration, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return fallback, nil
 	}
-	return nil
+	return time.ParseDuration(param)
 }
 
 func PATCHMarkNotificationSeen(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, uid string) {
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"log"
+	"time"
+)
+
+const (
+	outboxDestinationEvents = "event"
+
+	outboxBatchSize     = 50
+	outboxMaxAttempts   = 10
+	outboxMaxBackoff    = 10 * time.Minute
+	outboxDeliveredTTL  = 24 * time.Hour
+	outboxDeliveredKeys = "outbox:delivered:"
+	// A claimed message is left to its dispatcher for this long, far longer than a batch takes to deliver
+	outboxClaimTimeout = time.Minute
+)
+
+type outboxMessage struct {
+	EventID        string
+	IdempotencyKey string
+	Destination    string
+	Channel        string
+	Payload        []byte
+	Attempts       int
+}
+
+// EnqueueEvent stores an event to be published on the event bus once the transaction commits. The id is used as the
+// idempotency key of the outbox entry and as the ID subscribers see, so it should be derived from the change that
+// caused the event whenever possible.
+func EnqueueEvent(ctx context.Context, tx pgx.Tx, id string, event events.Event) error {
+	encoded, err := events.Encode(events.NewMessage(id, event))
+	if err != nil {
+		return err
+	}
+
+	return enqueueOutboxMessage(ctx, tx, outboxDestinationEvents, string(event.EventType()), encoded, id)
+}
+
+func enqueueOutboxMessage(ctx context.Context, tx pgx.Tx, destination string, channel string, payload []byte, idempotencyKey string) error {
+	insertQuery := `INSERT INTO outbox (idempotency_key, destination, channel, payload)
+					VALUES ($1, $2, $3, $4)
+					ON CONFLICT (idempotency_key) DO NOTHING`
+
+	_, err := tx.Exec(ctx, insertQuery, idempotencyKey, destination, channel, payload)
+	return err
+}
+
+// OutboxDispatcher publishes pending outbox messages to the event bus, checking every interval until the context is
+// cancelled. Failed deliveries are retried with an exponential backoff up to outboxMaxAttempts. A message is delivered
+// once the bus accepted it, the bus keeps redelivering it until every subscriber handled it.
+func OutboxDispatcher(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, publisher events.Publisher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				dispatched, err := dispatchOutboxBatch(ctx, connPool, rdb, publisher)
+				if err != nil {
+					log.Printf("Outbox dispatcher: %v", err)
+					break
+				}
+				if dispatched < outboxBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// dispatchOutboxBatch claims a batch of pending messages, delivers them and records the outcome. Claiming pushes the
+// next attempt of the messages past outboxClaimTimeout, so other instances of the service skip them while they are
+// delivered without holding a transaction open. Messages of a dispatcher that stopped before recording the outcome
+// are claimed again once the claim runs out, the delivered markers keep them from being sent twice.
+func dispatchOutboxBatch(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, publisher events.Publisher) (int, error) {
+	var messages []outboxMessage
+
+	claimQuery := `WITH claimed AS (
+						UPDATE outbox
+						SET next_attempt_at = (now() AT TIME ZONE 'utc'::text) + make_interval(secs => $3)
+						WHERE event_id IN (SELECT event_id
+											FROM outbox
+											WHERE delivered_at IS NULL
+											AND attempts < $1
+											AND next_attempt_at <= (now() AT TIME ZONE 'utc'::text)
+											ORDER BY created_at
+											LIMIT $2
+											FOR UPDATE SKIP LOCKED)
+						RETURNING event_id, idempotency_key, destination, channel, payload, attempts, created_at
+					)
+					SELECT event_id, idempotency_key, destination, channel, payload, attempts
+					FROM claimed
+					ORDER BY created_at`
+
+	deliveredQuery := `UPDATE outbox
+						SET delivered_at = (now() AT TIME ZONE 'utc'::text), attempts = attempts + 1, last_error = NULL
+						WHERE event_id = $1`
+
+	failedQuery := `UPDATE outbox
+					SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3
+					WHERE event_id = $1`
+
+	rows, err := connPool.Pool.Query(ctx, claimQuery, outboxMaxAttempts, outboxBatchSize, outboxClaimTimeout.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	for rows.Next() {
+		var message outboxMessage
+
+		err = rows.Scan(&message.EventID, &message.IdempotencyKey, &message.Destination, &message.Channel,
+			&message.Payload, &message.Attempts)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		messages = append(messages, message)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	deliveryErrs := make([]error, len(messages))
+	for i, message := range messages {
+		deliveryErrs[i] = deliverOutboxMessage(ctx, rdb, publisher, message)
+	}
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	for i, message := range messages {
+		deliveryErr := deliveryErrs[i]
+		if deliveryErr != nil {
+			log.Printf("Outbox delivery of %v failed on attempt %d: %v", message.IdempotencyKey, message.Attempts+1, deliveryErr)
+
+			_, err = tx.Exec(ctx, failedQuery, message.EventID, time.Now().UTC().Add(outboxBackoff(message.Attempts)),
+				deliveryErr.Error())
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		_, err = tx.Exec(ctx, deliveredQuery, message.EventID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(messages), tx.Commit(ctx)
+}
+
+// deliverOutboxMessage sends a single message. A marker is kept in Redis for every delivered idempotency key so a
+// message that was delivered but not marked in Postgres (e.g. the dispatcher crashed) is not sent a second time.
+func deliverOutboxMessage(ctx context.Context, rdb *redis.Client, publisher events.Publisher, message outboxMessage) error {
+	deliveredKey := outboxDeliveredKeys + message.IdempotencyKey
+
+	delivered, err := rdb.Exists(ctx, deliveredKey).Result()
+	if err != nil {
+		return err
+	}
+	if delivered > 0 {
+		return nil
+	}
+
+	switch message.Destination {
+	case outboxDestinationEvents:
+		var eventMessage events.Message
+
+		eventMessage, err = events.Decode(message.Payload)
+		if err != nil {
+			return err
+		}
+
+		err = publisher.Publish(ctx, eventMessage)
+	default:
+		err = fmt.Errorf("unknown outbox destination: %v", message.Destination)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = rdb.Set(ctx, deliveredKey, 1, outboxDeliveredTTL).Err()
+	if err != nil {
+		log.Printf("Unable to mark outbox message %v as delivered: %v", message.IdempotencyKey, err)
+	}
+
+	return nil
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Duration(1<<attempts) * time.Second
+	if backoff > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return backoff
+}
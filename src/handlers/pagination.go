@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	m "last_weekend_services/src/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Lists are ordered newest first by (created_at, id), the cursor is the position of the last item of a page
+const maxPageLimit = 100
+
+var errInvalidCursor = errors.New("cursor is not valid")
+
+// pageRequest is the page a list endpoint was asked for. The cursor is nil for the first page and limit is zero when
+// the whole list is wanted. Clients opt in to pages by sending limit or cursor, paginated is false for the ones that
+// don't and still expect the whole list as a plain array, see listBody.
+type pageRequest struct {
+	limit      int
+	cursorTime *time.Time
+	cursorID   *string
+	paginated  bool
+}
+
+// queryLimit is the LIMIT of the page query, one more row than the page tells whether there is a next page. Zero is
+// passed as NULL, which is no limit.
+func (page pageRequest) queryLimit() *int {
+	if page.limit == 0 {
+		return nil
+	}
+
+	limit := page.limit + 1
+	return &limit
+}
+
+// parsePageRequest reads the limit and cursor parameters, limit is capped at maxPageLimit
+func parsePageRequest(r *http.Request, defaultLimit int) (pageRequest, error) {
+	var err error
+	page := pageRequest{paginated: true}
+
+	page.limit, err = parseLimit(r, defaultLimit)
+	if err != nil {
+		return page, err
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return page, errInvalidCursor
+		}
+		page.cursorTime, page.cursorID = &createdAt, &id
+	}
+
+	return page, nil
+}
+
+// parseListRequest is parsePageRequest for the endpoints that returned whole lists before they were paginated. They
+// keep doing so for clients that send neither limit nor cursor.
+func parseListRequest(r *http.Request, defaultLimit int) (pageRequest, error) {
+	if !requestsPage(r) {
+		return pageRequest{}, nil
+	}
+	return parsePageRequest(r, defaultLimit)
+}
+
+// requestsPage reports whether the client asked for a page rather than the whole list
+func requestsPage(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Has("limit") || query.Has("cursor")
+}
+
+// parseLimit reads the limit parameter, capped at maxPageLimit
+func parseLimit(r *http.Request, defaultLimit int) (int, error) {
+	limitParam := r.URL.Query().Get("limit")
+	if limitParam == "" {
+		return defaultLimit, nil
+	}
+
+	parsedLimit, err := strconv.Atoi(limitParam)
+	if err != nil || parsedLimit < 1 {
+		return 0, errors.New("limit has to be a positive number")
+	}
+	return min(parsedLimit, maxPageLimit), nil
+}
+
+// newPage trims the extra row of the page query and sets the cursor of the next page if there is one
+func newPage[T any](items []T, page pageRequest, position func(item T) (time.Time, string)) m.Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	if page.limit == 0 || len(items) <= page.limit {
+		return m.Page[T]{Items: items}
+	}
+
+	items = items[:page.limit]
+	return m.Page[T]{Items: items, NextCursor: encodeCursor(position(items[page.limit-1]))}
+}
+
+// listBody is the response of a list endpoint, the page with its cursor when the client asked for one and the items
+// alone otherwise
+func listBody[T any](paginated bool, list m.Page[T]) interface{} {
+	if !paginated {
+		return list.Items
+	}
+	return list
+}
+
+// Cursors are opaque to clients
+func encodeCursor(createdAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "," + id))
+}
+
+func decodeCursor(cursor string) (time.Time, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	createdAtPart, id, found := strings.Cut(string(decoded), ",")
+	if !found {
+		return time.Time{}, "", errors.New("cursor is missing the id")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtPart)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	_, err = uuid.Parse(id)
+	return createdAt, id, err
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/base64"
+	m "last_weekend_services/src/models"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type pageItem struct {
+	createdAt time.Time
+	id        string
+}
+
+func pageItemPosition(item pageItem) (time.Time, string) {
+	return item.createdAt, item.id
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 5, 17, 21, 3, 4, 123456789, time.UTC)
+	id := "0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"
+
+	decodedAt, decodedID, err := decodeCursor(encodeCursor(createdAt, id))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !decodedAt.Equal(createdAt) || decodedID != id {
+		t.Errorf("decoded (%v, %v), want (%v, %v)", decodedAt, decodedID, createdAt, id)
+	}
+}
+
+func TestRankedCursorRoundTrip(t *testing.T) {
+	asOf := time.Date(2024, 5, 17, 21, 3, 4, 123456789, time.UTC)
+
+	decoded, err := decodeRankedCursor(rankedCursor{asOf: asOf, offset: 20}.encode())
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !decoded.asOf.Equal(asOf) || decoded.offset != 20 {
+		t.Errorf("decoded (%v, %v), want (%v, %v)", decoded.asOf, decoded.offset, asOf, 20)
+	}
+
+	_, err = decodeRankedCursor(encodeCursor(asOf, "0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"))
+	if err == nil {
+		t.Error("a chronological cursor was accepted")
+	}
+}
+
+func TestDecodeCursorRejectsBadCursors(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	cursors := map[string]string{
+		"not base64":   "not a cursor!",
+		"no id":        encode("2024-05-17T21:03:04Z"),
+		"bad time":     encode("yesterday,0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"),
+		"bad id":       encode("2024-05-17T21:03:04Z,42"),
+		"empty":        encode(""),
+		"ranked":       encode("2024-05-17T21:03:04Z,0.5,0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"),
+		"sql injected": encode("2024-05-17T21:03:04Z,'; DROP TABLE albums; --"),
+	}
+
+	for name, cursor := range cursors {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := decodeCursor(cursor)
+			if err == nil {
+				t.Errorf("%q was accepted", cursor)
+			}
+		})
+	}
+}
+
+func TestParsePageRequest(t *testing.T) {
+	createdAt := time.Date(2024, 5, 17, 21, 3, 4, 0, time.UTC)
+	id := "0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11"
+	cursor := encodeCursor(createdAt, id)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantLimit  int
+		wantCursor bool
+		wantErr    bool
+	}{
+		{name: "defaults", query: "", wantLimit: 20},
+		{name: "limit", query: "limit=5", wantLimit: 5},
+		{name: "capped limit", query: "limit=1000", wantLimit: maxPageLimit},
+		{name: "cursor", query: "cursor=" + cursor, wantLimit: 20, wantCursor: true},
+		{name: "limit and cursor", query: "limit=3&cursor=" + cursor, wantLimit: 3, wantCursor: true},
+		{name: "zero limit", query: "limit=0", wantErr: true},
+		{name: "negative limit", query: "limit=-1", wantErr: true},
+		{name: "non numeric limit", query: "limit=ten", wantErr: true},
+		{name: "bad cursor", query: "cursor=garbage", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := parsePageRequest(httptest.NewRequest("GET", "/feed?"+test.query, nil), 20)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("the request was accepted: %+v", page)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			if page.limit != test.wantLimit {
+				t.Errorf("limit = %d, want %d", page.limit, test.wantLimit)
+			}
+			if !page.paginated {
+				t.Error("the request is not paginated")
+			}
+			if test.wantCursor {
+				if page.cursorTime == nil || !page.cursorTime.Equal(createdAt) || page.cursorID == nil || *page.cursorID != id {
+					t.Errorf("cursor = (%v, %v), want (%v, %v)", page.cursorTime, page.cursorID, createdAt, id)
+				}
+			} else if page.cursorTime != nil || page.cursorID != nil {
+				t.Errorf("the first page has a cursor")
+			}
+		})
+	}
+}
+
+func TestParseListRequest(t *testing.T) {
+	page, err := parseListRequest(httptest.NewRequest("GET", "/user/album", nil), 20)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if page.paginated || page.limit != 0 || page.queryLimit() != nil {
+		t.Errorf("a request without limit or cursor asked for a page: %+v", page)
+	}
+
+	page, err = parseListRequest(httptest.NewRequest("GET", "/user/album?limit=2", nil), 20)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !page.paginated || page.limit != 2 || *page.queryLimit() != 3 {
+		t.Errorf("page = %+v, want a page of 2 queried with one extra row", page)
+	}
+
+	_, err = parseListRequest(httptest.NewRequest("GET", "/user/album?cursor=garbage", nil), 20)
+	if err != errInvalidCursor {
+		t.Errorf("err = %v, want %v", err, errInvalidCursor)
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	start := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+	items := make([]pageItem, 4)
+	for i := range items {
+		items[i] = pageItem{createdAt: start.Add(-time.Duration(i) * time.Hour), id: string(rune('a' + i))}
+	}
+
+	tests := []struct {
+		name       string
+		items      []pageItem
+		limit      int
+		wantItems  []pageItem
+		wantCursor string
+	}{
+		{name: "whole list", items: items, limit: 0, wantItems: items},
+		{name: "short page", items: items[:2], limit: 3, wantItems: items[:2]},
+		{name: "exact page", items: items[:3], limit: 3, wantItems: items[:3]},
+		{name: "extra row", items: items, limit: 3, wantItems: items[:3], wantCursor: encodeCursor(items[2].createdAt, items[2].id)},
+		{name: "empty", items: nil, limit: 3, wantItems: []pageItem{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := newPage(test.items, pageRequest{limit: test.limit}, pageItemPosition)
+
+			if !reflect.DeepEqual(page.Items, test.wantItems) {
+				t.Errorf("items = %v, want %v", page.Items, test.wantItems)
+			}
+			if page.NextCursor != test.wantCursor {
+				t.Errorf("next cursor = %q, want %q", page.NextCursor, test.wantCursor)
+			}
+		})
+	}
+}
+
+func TestListBody(t *testing.T) {
+	list := m.Page[string]{Items: []string{"a", "b"}, NextCursor: "next"}
+
+	if body, ok := listBody(false, list).([]string); !ok || !reflect.DeepEqual(body, list.Items) {
+		t.Errorf("unpaginated body = %#v, want the items", listBody(false, list))
+	}
+	if body, ok := listBody(true, list).(m.Page[string]); !ok || !reflect.DeepEqual(body, list) {
+		t.Errorf("paginated body = %#v, want the page", listBody(true, list))
+	}
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	"last_weekend_services/src/events"
+	m "last_weekend_services/src/models"
+	"last_weekend_services/src/push"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	InAppChannel = "in_app"
+	PushChannel  = "push"
+	EmailChannel = "email"
+)
+
+var notificationChannels = []string{InAppChannel, PushChannel, EmailChannel}
+
+// notificationPreferenceTypes are the notifications a user can opt out of
+var notificationPreferenceTypes = []events.Type{
+	events.ImageLikedType,
+	events.ImageUpvotedType,
+	events.CommentAddedType,
+	events.FriendRequestSentType,
+	events.FriendRequestAcceptedType,
+	events.AlbumInviteSentType,
+	events.AlbumInviteAcceptedType,
+	events.AlbumInviteDeniedType,
+	events.ImageUploadedType,
+	events.AlbumRevealedType,
+	WeeklyDigestType,
+}
+
+func PreferencesEndpointHandler(ctx context.Context, connPool *m.PGPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		if !ok {
+			log.Printf("Failed to get validated claims")
+			return
+		}
+
+		switch r.URL.Path {
+		case "/user/notifications/preferences":
+			switch r.Method {
+			case http.MethodGet:
+				GETNotificationSettings(ctx, w, connPool, claims.RegisteredClaims.Subject)
+			case http.MethodPatch:
+				PATCHNotificationSettings(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			}
+		case "/album/mute":
+			switch r.Method {
+			case http.MethodPost:
+				POSTAlbumMute(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			case http.MethodDelete:
+				DELETEAlbumMute(ctx, w, r, connPool, claims.RegisteredClaims.Subject)
+			}
+		}
+	})
+}
+
+// NotificationAllowed reports whether the user wants a notification of the type over the channel. A muted album
+// silences every channel and quiet hours hold back pushes. albumID is empty for notifications outside of an album.
+func NotificationAllowed(ctx context.Context, connPool *m.PGPool, userID string, notificationType string, channel string, albumID string) (bool, error) {
+	return notificationAllowedAt(ctx, connPool, userID, notificationType, channel, albumID, time.Now())
+}
+
+// notificationAllowedAt is NotificationAllowed at the given time
+func notificationAllowedAt(ctx context.Context, connPool *m.PGPool, userID string, notificationType string, channel string, albumID string, at time.Time) (bool, error) {
+	var allowed bool
+
+	allowedQuery := `SELECT COALESCE((SELECT enabled
+										FROM notification_preferences
+										WHERE user_id = $1 AND type = $2 AND channel = $3), true)
+						AND NOT EXISTS (SELECT 1
+										FROM album_mutes
+										WHERE user_id = $1
+										AND album_id = NULLIF($4, '')::uuid
+										AND (muted_until IS NULL OR muted_until > $5))
+						AND ($3 != 'push' OR NOT EXISTS (
+							SELECT 1
+							FROM user_notification_settings s,
+								LATERAL (SELECT ($5::timestamptz AT TIME ZONE s.timezone)::time AS local_time) l
+							WHERE s.user_id = $1
+							AND s.quiet_hours_start IS NOT NULL
+							AND s.quiet_hours_end IS NOT NULL
+							AND CASE
+									WHEN s.quiet_hours_start <= s.quiet_hours_end
+										THEN l.local_time >= s.quiet_hours_start AND l.local_time < s.quiet_hours_end
+									ELSE l.local_time >= s.quiet_hours_start OR l.local_time < s.quiet_hours_end
+								END))`
+
+	err := connPool.Pool.QueryRow(ctx, allowedQuery, userID, notificationType, channel, albumID, at).Scan(&allowed)
+	return allowed, err
+}
+
+func GETNotificationSettings(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, authZeroID string) {
+	settings, err := queryNotificationSettings(ctx, connPool, authZeroID)
+	if err != nil {
+		log.Printf("Unable to query notification settings: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notification settings")
+		return
+	}
+
+	writeNotificationSettings(w, settings)
+}
+
+func PATCHNotificationSettings(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var update m.NotificationSettingsUpdate
+
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Unable to read notification settings")
+		log.Printf("Unable to decode notification settings: %v", err)
+		return
+	}
+
+	for _, preference := range update.Preferences {
+		if !validPreference(preference) {
+			WriteResponseWithCode(w, http.StatusBadRequest, "Unknown notification type or channel")
+			return
+		}
+	}
+
+	// Quiet hours are evaluated by Postgres, so the timezone has to be one it knows
+	if update.Timezone != nil {
+		var known bool
+
+		err = connPool.Pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = $1)`,
+			*update.Timezone).Scan(&known)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update notification settings")
+			log.Printf("Unable to look up the timezone: %v", err)
+			return
+		}
+		if !known {
+			WriteResponseWithCode(w, http.StatusBadRequest, "Unknown timezone")
+			return
+		}
+	}
+
+	// Locales are language tags like en or es-MX, pushes fall back to English for languages without templates
+	if update.Locale != nil && !validLocale(*update.Locale) {
+		WriteResponseWithCode(w, http.StatusBadRequest, "Locale has to be a language tag like en or es-MX")
+		return
+	}
+
+	// Quiet hours are set or cleared together, an empty string clears them
+	if (update.QuietHoursStart == nil) != (update.QuietHoursEnd == nil) {
+		WriteResponseWithCode(w, http.StatusBadRequest, "quiet_hours_start and quiet_hours_end have to be set together")
+		return
+	}
+	setQuietHours := update.QuietHoursStart != nil
+	var quietHoursStart, quietHoursEnd string
+	if setQuietHours {
+		quietHoursStart, quietHoursEnd = *update.QuietHoursStart, *update.QuietHoursEnd
+
+		if (quietHoursStart == "") != (quietHoursEnd == "") || !validTimeOfDay(quietHoursStart) || !validTimeOfDay(quietHoursEnd) {
+			WriteResponseWithCode(w, http.StatusBadRequest, "Quiet hours have to be times like 22:00")
+			return
+		}
+	}
+
+	preferenceQuery := `INSERT INTO notification_preferences (user_id, type, channel, enabled)
+						VALUES ((SELECT user_id FROM users WHERE auth_zero_id = $1), $2, $3, $4)
+						ON CONFLICT (user_id, type, channel)
+						DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = (now() AT TIME ZONE 'utc'::text)`
+
+	settingsQuery := `INSERT INTO user_notification_settings (user_id, timezone, quiet_hours_start, quiet_hours_end, locale)
+						VALUES ((SELECT user_id FROM users WHERE auth_zero_id = $1), COALESCE($2, 'UTC'),
+								NULLIF($4, '')::time, NULLIF($5, '')::time, COALESCE($6, 'en'))
+						ON CONFLICT (user_id) DO UPDATE
+						SET timezone = COALESCE($2, user_notification_settings.timezone),
+							locale = COALESCE($6, user_notification_settings.locale),
+							quiet_hours_start = CASE WHEN $3 THEN NULLIF($4, '')::time ELSE user_notification_settings.quiet_hours_start END,
+							quiet_hours_end = CASE WHEN $3 THEN NULLIF($5, '')::time ELSE user_notification_settings.quiet_hours_end END,
+							updated_at = (now() AT TIME ZONE 'utc'::text)`
+
+	tx, err := connPool.Pool.Begin(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update notification settings")
+		log.Printf("Couldn't start transaction: %v", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	for _, preference := range update.Preferences {
+		_, err = tx.Exec(ctx, preferenceQuery, authZeroID, preference.Type, preference.Channel, preference.Enabled)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update notification settings")
+			log.Printf("Unable to update notification preference: %v", err)
+			return
+		}
+	}
+
+	if update.Timezone != nil || update.Locale != nil || setQuietHours {
+		_, err = tx.Exec(ctx, settingsQuery, authZeroID, update.Timezone, setQuietHours, quietHoursStart, quietHoursEnd,
+			update.Locale)
+		if err != nil {
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update notification settings")
+			log.Printf("Unable to update notification settings: %v", err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to update notification settings")
+		log.Printf("Couldn't commit notification settings: %v", err)
+		return
+	}
+
+	GETNotificationSettings(ctx, w, connPool, authZeroID)
+}
+
+// POSTAlbumMute mutes the album, until is an optional RFC 3339 time after which the album is unmuted
+func POSTAlbumMute(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	var mutedUntil *time.Time
+	albumID := r.URL.Query().Get("album_id")
+
+	if until := r.URL.Query().Get("until"); until != "" {
+		parsedUntil, err := time.Parse(time.RFC3339, until)
+		if err != nil || parsedUntil.Before(time.Now()) {
+			WriteResponseWithCode(w, http.StatusBadRequest, "until has to be a time in the future")
+			return
+		}
+		mutedUntil = &parsedUntil
+	}
+
+	hasAccess, err := userHasAlbumAccess(ctx, connPool, albumID, authZeroID)
+	if err != nil || !hasAccess {
+		WriteResponseWithCode(w, http.StatusNotFound, "User does not have access to event")
+		return
+	}
+
+	muteQuery := `INSERT INTO album_mutes (user_id, album_id, muted_until)
+					VALUES ((SELECT user_id FROM users WHERE auth_zero_id = $1), $2, $3)
+					ON CONFLICT (user_id, album_id) DO UPDATE SET muted_until = EXCLUDED.muted_until`
+
+	_, err = connPool.Pool.Exec(ctx, muteQuery, authZeroID, albumID, mutedUntil)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to mute album")
+		log.Printf("Unable to mute album: %v", err)
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Album muted")
+}
+
+func DELETEAlbumMute(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, authZeroID string) {
+	albumID := r.URL.Query().Get("album_id")
+
+	unmuteQuery := `DELETE FROM album_mutes
+					WHERE user_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)
+					AND album_id = $2`
+
+	_, err := connPool.Pool.Exec(ctx, unmuteQuery, authZeroID, albumID)
+	if err != nil {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to unmute album")
+		log.Printf("Unable to unmute album: %v", err)
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Album unmuted")
+}
+
+// queryNotificationSettings returns every type and channel combination, the ones the user never changed are enabled
+func queryNotificationSettings(ctx context.Context, connPool *m.PGPool, authZeroID string) (m.NotificationSettings, error) {
+	settings := m.NotificationSettings{Timezone: "UTC", Locale: push.DefaultLocale, MutedAlbums: []m.AlbumMute{}}
+
+	preferencesQuery := `SELECT type, channel, enabled
+						FROM notification_preferences
+						WHERE user_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)`
+
+	settingsQuery := `SELECT timezone, locale, COALESCE(to_char(quiet_hours_start, 'HH24:MI'), ''),
+							COALESCE(to_char(quiet_hours_end, 'HH24:MI'), '')
+						FROM user_notification_settings
+						WHERE user_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)`
+
+	mutesQuery := `SELECT am.album_id, a.album_name, am.muted_until
+					FROM album_mutes am
+					JOIN albums a ON a.album_id = am.album_id
+					WHERE am.user_id = (SELECT user_id FROM users WHERE auth_zero_id = $1)
+					AND (am.muted_until IS NULL OR am.muted_until > (now() AT TIME ZONE 'utc'::text))
+					AND a.deleted_at IS NULL`
+
+	rows, err := connPool.Pool.Query(ctx, preferencesQuery, authZeroID)
+	if err != nil {
+		return settings, err
+	}
+
+	changed := make(map[[2]string]bool)
+	for rows.Next() {
+		var preference m.NotificationPreference
+
+		err = rows.Scan(&preference.Type, &preference.Channel, &preference.Enabled)
+		if err != nil {
+			rows.Close()
+			return settings, err
+		}
+		changed[[2]string{preference.Type, preference.Channel}] = preference.Enabled
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return settings, rows.Err()
+	}
+
+	for _, notificationType := range notificationPreferenceTypes {
+		for _, channel := range notificationChannels {
+			enabled, ok := changed[[2]string{string(notificationType), channel}]
+			settings.Preferences = append(settings.Preferences, m.NotificationPreference{
+				Type:    string(notificationType),
+				Channel: channel,
+				Enabled: !ok || enabled,
+			})
+		}
+	}
+
+	err = connPool.Pool.QueryRow(ctx, settingsQuery, authZeroID).Scan(&settings.Timezone, &settings.Locale, &settings.QuietHoursStart,
+		&settings.QuietHoursEnd)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return settings, err
+	}
+
+	rows, err = connPool.Pool.Query(ctx, mutesQuery, authZeroID)
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mute m.AlbumMute
+
+		err = rows.Scan(&mute.AlbumID, &mute.AlbumName, &mute.MutedUntil)
+		if err != nil {
+			return settings, err
+		}
+		settings.MutedAlbums = append(settings.MutedAlbums, mute)
+	}
+
+	return settings, rows.Err()
+}
+
+func writeNotificationSettings(w http.ResponseWriter, settings m.NotificationSettings) {
+	responseBytes, err := json.MarshalIndent(settings, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal notification settings: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to query notification settings")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(responseBytes)
+}
+
+func validPreference(preference m.NotificationPreference) bool {
+	validType, validChannel := false, false
+
+	for _, notificationType := range notificationPreferenceTypes {
+		validType = validType || string(notificationType) == preference.Type
+	}
+	for _, channel := range notificationChannels {
+		validChannel = validChannel || channel == preference.Channel
+	}
+
+	return validType && validChannel
+}
+
+func validLocale(locale string) bool {
+	if locale == "" || len(locale) > 35 {
+		return false
+	}
+
+	for _, r := range locale {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func validTimeOfDay(timeOfDay string) bool {
+	if timeOfDay == "" {
+		return true
+	}
+
+	_, err := time.Parse("15:04", timeOfDay)
+	return err == nil
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	m "last_weekend_services/src/models"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	presenceKeyPrefix = "presence:"
+	// Set of the albums that have presence entries, walked by AlbumPresenceSweeper
+	presenceAlbumsKey = "presence:albums"
+	// A connection that has not sent a heartbeat for this long is no longer present. Heartbeats are the pongs, so this
+	// is a little over two ping periods.
+	presenceTTL = 2 * wsPongWait
+)
+
+func presenceKey(albumID string) string {
+	return presenceKeyPrefix + albumID
+}
+
+// presenceMember is a member of presence:<album_id>, scored by its last heartbeat. Presence is tracked per connection
+// and a user is present while any of their connections is, so a second tab joining or leaving is not broadcast.
+func presenceMember(userID string, connectionID string) string {
+	return userID + ":" + connectionID
+}
+
+func userIDFromPresenceMember(member string) string {
+	userID, _, _ := strings.Cut(member, ":")
+	return userID
+}
+
+// joinAlbumPresence marks the connection as present in the album, it is called on subscribe and on every heartbeat.
+// JOIN is broadcast when the user was not present through another connection.
+func (connectionState *ConnectionState) joinAlbumPresence(ctx context.Context, albumID string) error {
+	rdb := connectionState.rdb
+	now := time.Now().UTC()
+
+	added, err := rdb.ZAdd(ctx, presenceKey(albumID), redis.Z{
+		Score:  float64(now.Unix()),
+		Member: presenceMember(connectionState.UserID, connectionState.ID),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return nil
+	}
+
+	err = rdb.SAdd(ctx, presenceAlbumsKey, albumID).Err()
+	if err != nil {
+		return err
+	}
+
+	present, err := userPresentElsewhere(ctx, rdb, albumID, connectionState.UserID, connectionState.ID)
+	if err != nil || present {
+		return err
+	}
+
+	return publishPresence(ctx, rdb, "JOIN", albumID, m.AlbumPresence{
+		ID:         connectionState.UserID,
+		FirstName:  connectionState.FirstName,
+		LastName:   connectionState.LastName,
+		LastActive: now,
+	})
+}
+
+func (connectionState *ConnectionState) leaveAlbumPresence(ctx context.Context, albumID string) error {
+	return leaveAlbumPresence(ctx, connectionState.rdb, albumID, presenceMember(connectionState.UserID, connectionState.ID))
+}
+
+// refreshPresence is the heartbeat of the connection's albums
+func (connectionState *ConnectionState) refreshPresence(ctx context.Context) {
+	for _, albumID := range connectionState.albumIDs() {
+		err := connectionState.joinAlbumPresence(ctx, albumID)
+		if err != nil {
+			log.Printf("Unable to refresh album presence: %v", err)
+		}
+	}
+}
+
+// leaveAlbumPresence removes a connection from the album and broadcasts LEAVE when it was the user's last one
+func leaveAlbumPresence(ctx context.Context, rdb *redis.Client, albumID string, member string) error {
+	removed, err := rdb.ZRem(ctx, presenceKey(albumID), member).Result()
+	if err != nil || removed == 0 {
+		return err
+	}
+
+	userID, connectionID, _ := strings.Cut(member, ":")
+
+	present, err := userPresentElsewhere(ctx, rdb, albumID, userID, connectionID)
+	if err != nil || present {
+		return err
+	}
+
+	return publishPresence(ctx, rdb, "LEAVE", albumID, m.AlbumPresence{ID: userID, LastActive: time.Now().UTC()})
+}
+
+func userPresentElsewhere(ctx context.Context, rdb *redis.Client, albumID string, userID string, connectionID string) (bool, error) {
+	members, err := rdb.ZRangeByScore(ctx, presenceKey(albumID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-presenceTTL).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return false, err
+	}
+
+	for _, member := range members {
+		if userIDFromPresenceMember(member) == userID && member != presenceMember(userID, connectionID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// publishPresence broadcasts a presence change on the album channel. Presence is not added to the album's stream, a
+// reconnecting client loads the snapshot instead of replaying old joins and leaves.
+func publishPresence(ctx context.Context, rdb *redis.Client, operation string, albumID string, presence m.AlbumPresence) error {
+	return PublishLiveWebSocketPayload(ctx, rdb, albumID, WebSocketPayload{
+		Operation: operation,
+		Type:      "presence",
+		UserID:    presence.ID,
+		AlbumID:   albumID,
+		Payload:   presence,
+	})
+}
+
+// AlbumPresenceSweeper removes the connections that stopped sending heartbeats without leaving, e.g. because the
+// instance serving them went down, and broadcasts their LEAVE.
+func AlbumPresenceSweeper(ctx context.Context, rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := sweepAlbumPresence(ctx, rdb)
+			if err != nil {
+				log.Printf("Album presence sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+func sweepAlbumPresence(ctx context.Context, rdb *redis.Client) error {
+	albumIDs, err := rdb.SMembers(ctx, presenceAlbumsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	cutoff := strconv.FormatInt(time.Now().Add(-presenceTTL).Unix(), 10)
+
+	for _, albumID := range albumIDs {
+		expired, err := rdb.ZRangeByScore(ctx, presenceKey(albumID), &redis.ZRangeBy{Min: "-inf", Max: "(" + cutoff}).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, member := range expired {
+			err = leaveAlbumPresence(ctx, rdb, albumID, member)
+			if err != nil {
+				log.Printf("Unable to expire presence of %v in %v: %v", member, albumID, err)
+			}
+		}
+
+		remaining, err := rdb.ZCard(ctx, presenceKey(albumID)).Result()
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			err = rdb.SRem(ctx, presenceAlbumsKey, albumID).Err()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GETAlbumPresence returns the users that currently have the album open
+func GETAlbumPresence(ctx context.Context, w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, authZeroID string) {
+	albumID := r.URL.Query().Get("album_id")
+
+	hasAccess, err := userHasAlbumAccess(ctx, connPool, albumID, authZeroID)
+	if err != nil {
+		log.Printf("Error getting access to album: %v", err)
+		WriteResponseWithCode(w, http.StatusNotFound, "Error getting access to album")
+		return
+	}
+	if !hasAccess {
+		WriteResponseWithCode(w, http.StatusNotFound, "User does not have access to event")
+		return
+	}
+
+	members, err := rdb.ZRangeByScoreWithScores(ctx, presenceKey(albumID), &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Add(-presenceTTL).Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.Printf("Unable to read album presence: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to read album presence")
+		return
+	}
+
+	// A user with several connections is listed once with their latest heartbeat
+	lastActive := make(map[string]time.Time)
+	for _, member := range members {
+		userID := userIDFromPresenceMember(member.Member.(string))
+		heartbeat := time.Unix(int64(member.Score), 0).UTC()
+		if heartbeat.After(lastActive[userID]) {
+			lastActive[userID] = heartbeat
+		}
+	}
+
+	userIDs := make([]string, 0, len(lastActive))
+	for userID := range lastActive {
+		userIDs = append(userIDs, userID)
+	}
+
+	presentUsers := []m.AlbumPresence{}
+
+	if len(userIDs) > 0 {
+		usersQuery := `SELECT user_id, first_name, last_name
+						FROM users
+						WHERE user_id = ANY($1::uuid[])
+						ORDER BY first_name, last_name`
+
+		rows, err := connPool.Pool.Query(ctx, usersQuery, userIDs)
+		if err != nil {
+			log.Printf("Unable to query present users: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to read album presence")
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var presence m.AlbumPresence
+
+			err = rows.Scan(&presence.ID, &presence.FirstName, &presence.LastName)
+			if err != nil {
+				log.Printf("Unable to scan present user: %v", err)
+				WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to read album presence")
+				return
+			}
+
+			presence.LastActive = lastActive[presence.ID]
+			presentUsers = append(presentUsers, presence)
+		}
+	}
+
+	responseBytes, err := json.MarshalIndent(presentUsers, "", "\t")
+	if err != nil {
+		log.Printf("Unable to marshal album presence: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to read album presence")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(responseBytes)
+	if err != nil {
+		log.Printf("Failed to Write: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
+	"github.com/auth0/go-jwt-middleware/v2/validator"
+	m "last_weekend_services/src/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// Comments are sent this often so proxies keep the stream open, they double as the presence heartbeat
+const sseKeepAlivePeriod = 30 * time.Second
+
+func EventStreamEndpointHandler(connPool *m.PGPool, rdb *redis.Client, registry *ConnectionRegistry, ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		if !ok {
+			log.Printf("Failed to get validated claims")
+			return
+		}
+
+		revoked, err := sessionRevoked(ctx, connPool, claims.RegisteredClaims.Subject, claims.RegisteredClaims.IssuedAt)
+		if err != nil {
+			log.Printf("Unable to check the session: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to check the session")
+			return
+		}
+		if revoked {
+			WriteResponseWithCode(w, http.StatusUnauthorized, "Session was signed out")
+			return
+		}
+
+		EventStream(w, r, connPool, rdb, registry, ctx, claims.RegisteredClaims.Subject)
+	})
+}
+
+// EventStream streams the same payloads as the WebSocket for clients that cannot keep one open. The stream always
+// carries the user's notifications and the albums passed as album_id, which can be repeated. Payloads with a sequence
+// are sent with the last sequence of every stream as their event ID, so a reconnecting client's Last-Event-ID replays
+// what it missed on each of them.
+func EventStream(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, registry *ConnectionRegistry, ctx context.Context, auth0UID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	connection, err := newUserConnection(ctx, connPool, rdb, nil, auth0UID)
+	if err != nil {
+		WriteErrorToWriter(w, "Error: Unable to lookup requesting user")
+		log.Printf("Unable to lookup requesting user: %v", err)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_seen")
+	}
+	lastSeen := parseStreamCursors(lastEventID)
+
+	err = connection.subscribe(ctx, UserChannel(connection.UserID), notificationsSubscription)
+	if err != nil {
+		log.Printf("Unable to subscribe event stream: %v", err)
+		connection.release(ctx)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to open event stream")
+		return
+	}
+
+	for _, albumID := range r.URL.Query()["album_id"] {
+		hasAccess, err := connection.subscribeAuthorizedAlbum(ctx, connPool, albumID)
+		if err != nil {
+			log.Printf("Unable to subscribe event stream to album: %v", err)
+			connection.release(ctx)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to open event stream")
+			return
+		}
+		if !hasAccess {
+			connection.release(ctx)
+			WriteResponseWithCode(w, http.StatusForbidden, "User does not have access to event")
+			return
+		}
+	}
+
+	err = registry.Register(ctx, connection)
+	if err != nil {
+		log.Printf("Unable to register event stream: %v", err)
+	}
+	defer registry.Unregister(ctx, connection)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The streams without a new event keep the cursor the client reconnected with
+	cursors := streamCursors{}
+	subscriptions := []string{notificationsSubscription}
+	for _, albumID := range connection.albumIDs() {
+		subscriptions = append(subscriptions, albumSubscriptionPrefix+albumID)
+	}
+	for _, subscription := range subscriptions {
+		if sequence := lastSeen.of(subscription); sequence != "" {
+			cursors[subscription] = sequence
+		}
+	}
+
+	// The replay runs alongside the writer since several streams can hold more than the send queue
+	forwarding := make(chan struct{})
+	go func() {
+		defer close(forwarding)
+
+		err := connection.replay(ctx, UserChannel(connection.UserID), notificationsSubscription,
+			lastSeen.of(notificationsSubscription))
+		for _, albumID := range connection.albumIDs() {
+			if err != nil {
+				break
+			}
+			err = connection.replay(ctx, albumID, albumSubscriptionPrefix+albumID, lastSeen.of(albumSubscriptionPrefix+albumID))
+		}
+		if err != nil {
+			log.Printf("Unable to replay event stream: %v", err)
+		}
+
+		connection.forwardMessages(ctx, connPool)
+	}()
+
+	connection.writeEvents(ctx, w, flusher, cursors, r.Context().Done())
+	connection.Shutdown(websocket.CloseNormalClosure, "")
+	<-forwarding
+
+	connection.release(ctx)
+}
+
+// writeEvents plays the part of writeMessages for an event stream, it writes until the client goes away or the
+// connection is shut down. cursors holds the last sequence sent of every stream.
+func (connectionState *ConnectionState) writeEvents(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, cursors streamCursors, clientGone <-chan struct{}) {
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case <-connectionState.done:
+			return
+		case data := <-connectionState.send:
+			err := writeEvent(w, data, cursors)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			_, err := w.Write([]byte(": keep-alive\n\n"))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+			connectionState.refreshPresence(ctx)
+		}
+	}
+}
+
+// writeEvent writes a payload as a single event. Payloads are indented JSON, every line becomes a data line and the
+// client joins them back together. A payload with a sequence moves the cursor of its stream and is sent with all of
+// them as its ID.
+func writeEvent(w http.ResponseWriter, data []byte, cursors streamCursors) error {
+	var event bytes.Buffer
+
+	var sequenced struct {
+		Subscription string `json:"subscription"`
+		Sequence     string `json:"sequence"`
+	}
+	if json.Unmarshal(data, &sequenced) == nil && sequenced.Sequence != "" {
+		cursors[sequenced.Subscription] = sequenced.Sequence
+		event.WriteString("id: " + cursors.eventID() + "\n")
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteString("\n")
+	}
+	event.WriteString("\n")
+
+	_, err := w.Write(event.Bytes())
+	return err
+}
+
+// streamCursors is the last sequence seen of each stream of an event stream, by subscription. Its event ID form is
+// the subscription:sequence pairs separated by commas, subscriptions can contain colons but sequences cannot.
+type streamCursors map[string]string
+
+func (cursors streamCursors) eventID() string {
+	subscriptions := make([]string, 0, len(cursors))
+	for subscription := range cursors {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Strings(subscriptions)
+
+	pairs := make([]string, len(subscriptions))
+	for i, subscription := range subscriptions {
+		pairs[i] = subscription + ":" + cursors[subscription]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// of is the sequence to replay the stream of the subscription from, empty when the client has not seen any of it
+func (cursors streamCursors) of(subscription string) string {
+	return cursors[subscription]
+}
+
+// parseStreamCursors reads an event ID back, pairs that are not a subscription and a stream ID are dropped so the
+// stream is replayed from its live end instead. An event ID that is none of them is no cursor at all.
+func parseStreamCursors(eventID string) streamCursors {
+	cursors := streamCursors{}
+	if eventID == "" {
+		return cursors
+	}
+
+	for _, pair := range strings.Split(eventID, ",") {
+		separator := strings.LastIndex(pair, ":")
+		if separator <= 0 || !isStreamID(pair[separator+1:]) {
+			continue
+		}
+		cursors[pair[:separator]] = pair[separator+1:]
+	}
+	return cursors
+}
+
+// isStreamID reports whether id is a Redis stream ID of the form <milliseconds>-<sequence>
+func isStreamID(id string) bool {
+	timePart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return false
+	}
+
+	_, err := strconv.ParseUint(timePart, 10, 64)
+	if err != nil {
+		return false
+	}
+	_, err = strconv.ParseUint(seqPart, 10, 64)
+	return err == nil
+}
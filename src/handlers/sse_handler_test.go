@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamCursorsRoundTrip(t *testing.T) {
+	cursors := streamCursors{
+		notificationsSubscription: "1715979784000-0",
+		albumSubscriptionPrefix + "0b6e3c1e-5d4f-4f3b-9a53-8c1a7c0f2d11": "1715979790000-3",
+	}
+
+	parsed := parseStreamCursors(cursors.eventID())
+	if !reflect.DeepEqual(parsed, cursors) {
+		t.Errorf("parsed %v, want %v", parsed, cursors)
+	}
+	for subscription, sequence := range cursors {
+		if parsed.of(subscription) != sequence {
+			t.Errorf("%v replays from %q, want %q", subscription, parsed.of(subscription), sequence)
+		}
+	}
+	if sequence := parsed.of(albumSubscriptionPrefix + "other"); sequence != "" {
+		t.Errorf("an album the client has not seen replays from %q", sequence)
+	}
+}
+
+func TestParseStreamCursors(t *testing.T) {
+	ids := map[string]streamCursors{
+		"":                                 {},
+		"garbage":                          {},
+		"1715979784000-0":                  {},
+		"notifications:garbage":            {},
+		"notifications:1-0,:2-0,album:x:3": {notificationsSubscription: "1-0"},
+	}
+	for id, want := range ids {
+		if got := parseStreamCursors(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("parseStreamCursors(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestWriteEventCarriesEveryCursor(t *testing.T) {
+	cursors := streamCursors{notificationsSubscription: "1-0"}
+	w := httptest.NewRecorder()
+
+	err := writeEvent(w, []byte(`{"subscription":"album:a","sequence":"2-0","type":"IMAGE"}`), cursors)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !strings.HasPrefix(w.Body.String(), "id: album:a:2-0,notifications:1-0\n") {
+		t.Errorf("event = %q, want the cursors of both streams as its ID", w.Body)
+	}
+
+	// Live signals have no sequence and leave the cursors alone
+	w = httptest.NewRecorder()
+	err = writeEvent(w, []byte(`{"subscription":"album:a","type":"TYPING"}`), cursors)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if strings.Contains(w.Body.String(), "id:") {
+		t.Errorf("event = %q, want no ID", w.Body)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
@@ -12,6 +13,7 @@ import (
 	"net/http"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
 )
 
 func UserEndpointHandler(connPool *m.PGPool, ctx context.Context) http.HandlerFunc {
@@ -180,7 +182,8 @@ func GETUserByUID(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 								FROM albumuser au
 								JOIN albums a ON au.album_id = a.album_id
 								WHERE au.user_id = $1
-								AND a.revealed_at < CURRENT_DATE;`
+								AND a.revealed_at < CURRENT_DATE
+								AND a.deleted_at IS NULL AND a.archived_at IS NULL;`
 	batch.Queue(usersRevealedAlbumQuery, friendID)
 
 	// Execute the batch
@@ -236,3 +239,60 @@ func GETUserByUID(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(responseBytes)
 }
+
+func SessionEndpointHandler(ctx context.Context, connPool *m.PGPool, rdb *redis.Client) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		if !ok {
+			log.Printf("Failed to get validated claims")
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			switch r.URL.Path {
+			case "/user/sessions":
+				DELETEUserSessions(ctx, w, connPool, rdb, claims.RegisteredClaims.Subject)
+			}
+		}
+	})
+}
+
+// DELETEUserSessions signs the user out everywhere. Every token issued until now is revoked for WebSockets and event
+// streams and the ones that are open are closed. Push tokens are removed per device on /fcm.
+func DELETEUserSessions(ctx context.Context, w http.ResponseWriter, connPool *m.PGPool, rdb *redis.Client, authZeroID string) {
+	var userID string
+
+	query := `UPDATE users SET sessions_revoked_at = now() WHERE auth_zero_id = $1 RETURNING user_id`
+
+	err := connPool.Pool.QueryRow(ctx, query, authZeroID).Scan(&userID)
+	if err != nil {
+		log.Printf("Unable to revoke sessions: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to sign out")
+		return
+	}
+
+	err = DisconnectUser(ctx, rdb, userID, "signed out")
+	if err != nil {
+		log.Printf("Unable to disconnect signed out user: %v", err)
+		WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to close the user's connections")
+		return
+	}
+
+	WriteResponseWithCode(w, http.StatusOK, "Signed out everywhere")
+}
+
+// sessionRevoked tells if the token issued at issuedAt, in Unix seconds, was revoked by the user signing out
+// everywhere. iat only has seconds, so a token issued within the second of the sign out is revoked as well.
+func sessionRevoked(ctx context.Context, connPool *m.PGPool, authZeroID string, issuedAt int64) (bool, error) {
+	var revoked bool
+
+	query := `SELECT coalesce(to_timestamp($2) <= sessions_revoked_at, false) FROM users WHERE auth_zero_id = $1`
+
+	err := connPool.Pool.QueryRow(ctx, query, authZeroID, issuedAt).Scan(&revoked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+
+	return revoked, err
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignOutEverywhereRevokesSessions(t *testing.T) {
+	ctx := context.Background()
+	connPool := testPool(t)
+	rdb := newTestRedis(t)
+
+	userID, authZeroID := seedUser(t, connPool, "Ana")
+	seedPushToken(t, connPool, userID)
+	issuedAt := time.Now().Add(-time.Hour).Unix()
+
+	revoked, err := sessionRevoked(ctx, connPool, authZeroID, issuedAt)
+	if err != nil || revoked {
+		t.Fatalf("revoked = %v, %v before signing out", revoked, err)
+	}
+
+	control := rdb.Subscribe(ctx, wsControlChannel)
+	defer control.Close()
+	_, err = control.Receive(ctx)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	DELETEUserSessions(ctx, w, connPool, rdb, authZeroID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	select {
+	case message := <-control.Channel():
+		if !strings.Contains(message.Payload, `"disconnect"`) || !strings.Contains(message.Payload, userID) {
+			t.Errorf("control message = %s, want a disconnect of the user", message.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Error("the user's connections were not closed")
+	}
+
+	tokens := map[string]struct {
+		issuedAt int64
+		revoked  bool
+	}{
+		"issued before": {issuedAt, true},
+		"issued after":  {time.Now().Add(time.Minute).Unix(), false},
+	}
+	for name, token := range tokens {
+		revoked, err := sessionRevoked(ctx, connPool, authZeroID, token.issuedAt)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if revoked != token.revoked {
+			t.Errorf("%v: revoked = %v, want %v", name, revoked, token.revoked)
+		}
+	}
+
+	// Signing out everywhere is about sessions, the devices keep their push tokens until they are removed on /fcm
+	var pushTokens int
+	err = connPool.Pool.QueryRow(ctx, `SELECT count(*) FROM firebase_tokens WHERE user_id = $1`, userID).Scan(&pushTokens)
+	if err != nil {
+		t.Fatalf("count tokens: %v", err)
+	}
+	if pushTokens != 1 {
+		t.Errorf("%d push tokens left, want 1", pushTokens)
+	}
+}
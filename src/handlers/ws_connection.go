@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	m "last_weekend_services/src/models"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Time allowed to write a frame to the client
+	wsWriteWait = 10 * time.Second
+	// Time allowed between pongs before the client is considered gone
+	wsPongWait = 60 * time.Second
+	// Pings are sent before the pong wait runs out
+	wsPingPeriod = (wsPongWait * 9) / 10
+	// Commands are small, anything bigger is not a command
+	wsMaxCommandSize = 4096
+	// Frames waiting to be written, a client that falls this far behind is disconnected
+	wsSendQueueSize = 256
+)
+
+// SlowConsumerCloseCode is sent when a client does not read its frames fast enough
+const SlowConsumerCloseCode = 4429
+
+var errConnectionClosed = errors.New("connection closed")
+
+// ConnectionState is a single client connection. Every connection receives the user's notifications and can
+// subscribe to any number of albums through WebSocketCommand messages.
+//
+// The connection is served by three goroutines: Serve reads commands, forwardMessages queues Redis messages and
+// writeMessages is the only one writing to the socket. Closing done stops all of them. Event streams use the same
+// state without a socket, see EventStream.
+type ConnectionState struct {
+	ID         string
+	Conn       *websocket.Conn
+	UserID     string
+	AuthZeroID string
+	FirstName  string
+	LastName   string
+
+	rdb    *redis.Client
+	pubSub *redis.PubSub
+	send   chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+
+	subMu sync.Mutex
+	// subscriptions maps the Redis channels of the connection to the subscription their frames are tagged with
+	subscriptions map[string]string
+	// replayedUpTo holds the last replayed sequence of channels that are still catching up
+	replayedUpTo map[string]string
+	// replayBuffers holds the live frames of channels that are being replayed, they are sent after the replay
+	replayBuffers map[string][]liveFrame
+}
+
+func NewConnectionState(conn *websocket.Conn, rdb *redis.Client, ctx context.Context, userID string, authZeroID string) *ConnectionState {
+	return &ConnectionState{
+		ID:            uuid.NewString(),
+		Conn:          conn,
+		UserID:        userID,
+		AuthZeroID:    authZeroID,
+		rdb:           rdb,
+		pubSub:        rdb.Subscribe(ctx),
+		send:          make(chan []byte, wsSendQueueSize),
+		done:          make(chan struct{}),
+		closeCode:     websocket.CloseNormalClosure,
+		subscriptions: make(map[string]string),
+		replayedUpTo:  make(map[string]string),
+		replayBuffers: make(map[string][]liveFrame),
+	}
+}
+
+// Serve runs the connection until the client goes away, the connection is shut down or the context is cancelled. It
+// returns once every goroutine of the connection has stopped.
+func (connectionState *ConnectionState) Serve(ctx context.Context, connPool *m.PGPool, rdb *redis.Client) {
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		connectionState.writeMessages()
+	}()
+	go func() {
+		defer wg.Done()
+		connectionState.forwardMessages(ctx, connPool)
+	}()
+
+	connectionState.readCommands(ctx, connPool, rdb)
+	connectionState.Shutdown(websocket.CloseNormalClosure, "")
+	wg.Wait()
+
+	connectionState.release(ctx)
+	connectionState.Conn.Close()
+}
+
+// release leaves the presence of the connection's albums and closes its Redis subscriptions, it is called once the
+// connection has stopped
+func (connectionState *ConnectionState) release(ctx context.Context) {
+	for _, albumID := range connectionState.albumIDs() {
+		err := connectionState.leaveAlbumPresence(ctx, albumID)
+		if err != nil {
+			log.Printf("Unable to leave album presence: %v", err)
+		}
+	}
+
+	err := connectionState.pubSub.Close()
+	if err != nil {
+		log.Printf("Error closing redis subscriptions: %v", err)
+	}
+}
+
+// Shutdown closes the connection with the given close code, only the first call has any effect
+func (connectionState *ConnectionState) Shutdown(code int, reason string) {
+	connectionState.closeOnce.Do(func() {
+		connectionState.closeCode = code
+		connectionState.closeText = reason
+		close(connectionState.done)
+	})
+}
+
+// Enqueue queues a frame for the writer. A client whose queue is full is too slow to keep up and is disconnected
+// rather than holding up the Redis subscription.
+func (connectionState *ConnectionState) Enqueue(data []byte) error {
+	select {
+	case <-connectionState.done:
+		return errConnectionClosed
+	default:
+	}
+
+	select {
+	case connectionState.send <- data:
+		return nil
+	default:
+		connectionState.Shutdown(SlowConsumerCloseCode, "client is not keeping up")
+		return errConnectionClosed
+	}
+}
+
+// readCommands handles the commands sent by the client. The read deadline is pushed back by every pong, so a client
+// that stops answering pings is dropped. Pongs are also the heartbeat of the connection's album presence.
+func (connectionState *ConnectionState) readCommands(ctx context.Context, connPool *m.PGPool, rdb *redis.Client) {
+	conn := connectionState.Conn
+
+	conn.SetReadLimit(wsMaxCommandSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		connectionState.refreshPresence(ctx)
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket Error: %v", err)
+			}
+			return
+		}
+
+		var command WebSocketCommand
+		err = json.Unmarshal(data, &command)
+		if err != nil {
+			connectionState.writeError(command, "", http.StatusBadRequest, "command could not be read")
+			continue
+		}
+
+		connectionState.handleCommand(ctx, connPool, rdb, command)
+	}
+}
+
+// forwardMessages queues the messages of every subscribed channel, tagged with their subscription. Messages on an
+// album access channel are not forwarded, they make the connection re-check whether the user can still see the album.
+func (connectionState *ConnectionState) forwardMessages(ctx context.Context, connPool *m.PGPool) {
+	messages := connectionState.pubSub.Channel(redis.WithChannelSize(250))
+
+	for {
+		select {
+		case <-ctx.Done():
+			connectionState.Shutdown(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-connectionState.done:
+			return
+		case message, ok := <-messages:
+			if !ok {
+				connectionState.Shutdown(websocket.CloseInternalServerErr, "subscription closed")
+				return
+			}
+
+			subscription, ok := connectionState.subscription(message.Channel)
+			if !ok {
+				// The channel was unsubscribed while the message was in flight
+				continue
+			}
+
+			if albumID, isAccessChannel := albumIDFromAccessChannel(message.Channel); isAccessChannel {
+				connectionState.recheckAlbumAccess(ctx, connPool, albumID)
+				continue
+			}
+
+			if isOwnTyping(message.Payload, connectionState.UserID) {
+				continue
+			}
+
+			frame := liveFrame{payload: message.Payload, data: tagFrame([]byte(message.Payload), "subscription", subscription)}
+			if !connectionState.passLive(message.Channel, frame) {
+				continue
+			}
+
+			err := connectionState.Enqueue(frame.data)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeMessages is the only goroutine writing to the socket. It sends the queued frames and the pings, and once the
+// connection is shut down it sends the close frame and closes the socket so the reader stops as well.
+func (connectionState *ConnectionState) writeMessages() {
+	conn := connectionState.Conn
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-connectionState.send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+
+			err := conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				log.Printf("Unable to write to websocket: %v", err)
+				connectionState.Shutdown(websocket.CloseAbnormalClosure, "")
+				conn.Close()
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+
+			err := conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				connectionState.Shutdown(websocket.CloseAbnormalClosure, "")
+				conn.Close()
+				return
+			}
+		case <-connectionState.done:
+			if connectionState.closeCode != websocket.CloseAbnormalClosure {
+				sendCloseFrame(conn, connectionState.closeCode, connectionState.closeText)
+			}
+			conn.Close()
+			return
+		}
+	}
+}
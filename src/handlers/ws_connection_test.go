@@ -0,0 +1,625 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	m "last_weekend_services/src/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const testUserID = "user-1"
+
+// testFrame is the part of a frame the tests look at, Payload is the number the test published
+type testFrame struct {
+	Operation    string `json:"operation"`
+	Sequence     string `json:"sequence"`
+	Subscription string `json:"subscription"`
+	Payload      int    `json:"payload"`
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// testConnection is a served connection and the client end of its socket
+type testConnection struct {
+	client *websocket.Conn
+	cancel context.CancelFunc
+	// served is closed once Serve returned and the connection was unregistered
+	served chan struct{}
+}
+
+// serveTestConnection serves a connection of testUserID subscribed to the user's notifications, the way WebSocket
+// does without the user lookup
+func serveTestConnection(t *testing.T, rdb *redis.Client, registry *ConnectionRegistry) *testConnection {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	subscribed := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer close(served)
+
+		connection := NewConnectionState(conn, rdb, ctx, testUserID, "auth-1")
+		err = connection.subscribe(ctx, UserChannel(testUserID), notificationsSubscription)
+		if err != nil {
+			t.Errorf("subscribe: %v", err)
+			return
+		}
+		err = registry.Register(ctx, connection)
+		if err != nil {
+			t.Errorf("register: %v", err)
+			return
+		}
+		defer registry.Unregister(context.Background(), connection)
+		close(subscribed)
+
+		connection.Serve(ctx, nil, rdb)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case <-subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not subscribed")
+	}
+
+	connection := &testConnection{client: client, cancel: cancel, served: served}
+	t.Cleanup(func() {
+		cancel()
+		connection.waitServed(t)
+	})
+	return connection
+}
+
+func (connection *testConnection) waitServed(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-connection.served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+}
+
+// readClose reads until the server closes the connection and returns the close code
+func (connection *testConnection) readClose(t *testing.T) int {
+	t.Helper()
+
+	connection.client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := connection.client.ReadMessage()
+		if err == nil {
+			continue
+		}
+
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("the connection ended without a close frame: %v", err)
+		}
+		return closeErr.Code
+	}
+}
+
+func publishTestPayload(t *testing.T, rdb *redis.Client, channel string, number int) {
+	t.Helper()
+
+	err := PublishWebSocketPayload(context.Background(), rdb, channel, WebSocketPayload{Operation: "TEST", Payload: number})
+	if err != nil {
+		t.Errorf("publish: %v", err)
+	}
+}
+
+func TestConnectionForwardsConcurrentPublishes(t *testing.T) {
+	rdb := newTestRedis(t)
+	connection := serveTestConnection(t, rdb, NewConnectionRegistry(rdb))
+
+	const publishers, perPublisher = 8, 25
+
+	var wg sync.WaitGroup
+	for publisher := 0; publisher < publishers; publisher++ {
+		wg.Add(1)
+		go func(publisher int) {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				publishTestPayload(t, rdb, UserChannel(testUserID), publisher*perPublisher+i)
+			}
+		}(publisher)
+	}
+
+	// Commands are answered by the reader while the forwarder queues messages, both go through the one writer
+	const pings = 20
+	for i := 0; i < pings; i++ {
+		err := connection.client.WriteJSON(WebSocketCommand{Command: "ping"})
+		if err != nil {
+			t.Fatalf("ping: %v", err)
+		}
+	}
+
+	seen := make(map[int]bool)
+	last := make(map[int]int)
+	pongs := 0
+
+	connection.client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for len(seen) < publishers*perPublisher || pongs < pings {
+		var frame testFrame
+		err := connection.client.ReadJSON(&frame)
+		if err != nil {
+			t.Fatalf("read after %d frames and %d pongs: %v", len(seen), pongs, err)
+		}
+
+		if frame.Operation == "PONG" {
+			pongs++
+			continue
+		}
+		if frame.Subscription != notificationsSubscription {
+			t.Errorf("subscription = %q", frame.Subscription)
+		}
+		if seen[frame.Payload] {
+			t.Errorf("%d was sent twice", frame.Payload)
+		}
+		seen[frame.Payload] = true
+
+		// Every publisher's messages arrive in the order they were published
+		publisher := frame.Payload / perPublisher
+		if previous, ok := last[publisher]; ok && previous > frame.Payload {
+			t.Errorf("%d arrived after %d", frame.Payload, previous)
+		}
+		last[publisher] = frame.Payload
+	}
+
+	wg.Wait()
+}
+
+func TestConnectionDisconnectedByRegistry(t *testing.T) {
+	rdb := newTestRedis(t)
+	registry := NewConnectionRegistry(rdb)
+	connection := serveTestConnection(t, rdb, registry)
+
+	registry.disconnectLocal(testUserID, "tokens revoked")
+
+	if code := connection.readClose(t); code != ForceDisconnectCloseCode {
+		t.Errorf("close code = %d, want %d", code, ForceDisconnectCloseCode)
+	}
+	connection.waitServed(t)
+
+	connections, err := QueryUserConnections(context.Background(), rdb, testUserID)
+	if err != nil {
+		t.Fatalf("query connections: %v", err)
+	}
+	if len(connections) != 0 {
+		t.Errorf("%d connections left in the registry, want 0", len(connections))
+	}
+}
+
+func TestDisconnectUserReachesOtherNodes(t *testing.T) {
+	rdb := newTestRedis(t)
+	registry := NewConnectionRegistry(rdb)
+	connection := serveTestConnection(t, rdb, registry)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		registry.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-running
+	}()
+
+	// The control channel is only listened to once Run subscribed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listeners, err := rdb.PubSubNumSub(ctx, wsControlChannel).Result()
+		if err != nil {
+			t.Fatalf("count listeners: %v", err)
+		}
+		if listeners[wsControlChannel] > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the registry did not subscribe to the control channel")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err := DisconnectUser(ctx, rdb, testUserID, "account deleted")
+	if err != nil {
+		t.Fatalf("disconnect: %v", err)
+	}
+
+	if code := connection.readClose(t); code != ForceDisconnectCloseCode {
+		t.Errorf("close code = %d, want %d", code, ForceDisconnectCloseCode)
+	}
+	connection.waitServed(t)
+}
+
+func TestConnectionClosedOnShutdown(t *testing.T) {
+	rdb := newTestRedis(t)
+	connection := serveTestConnection(t, rdb, NewConnectionRegistry(rdb))
+
+	connection.cancel()
+
+	if code := connection.readClose(t); code != websocket.CloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, websocket.CloseGoingAway)
+	}
+	connection.waitServed(t)
+}
+
+func TestConnectionStopsWhenTheClientLeaves(t *testing.T) {
+	rdb := newTestRedis(t)
+	connection := serveTestConnection(t, rdb, NewConnectionRegistry(rdb))
+
+	err := connection.client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	connection.waitServed(t)
+
+	// Publishing after the connection is gone must not block or panic
+	publishTestPayload(t, rdb, UserChannel(testUserID), 1)
+}
+
+func TestEnqueueDisconnectsSlowConsumers(t *testing.T) {
+	rdb := newTestRedis(t)
+	connection := NewConnectionState(nil, rdb, context.Background(), testUserID, "auth-1")
+	defer connection.pubSub.Close()
+
+	// Nothing writes the queue out, so it fills up
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	refused := 0
+	for writer := 0; writer < 4; writer++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < wsSendQueueSize; i++ {
+				err := connection.Enqueue([]byte("{}"))
+				if err != nil {
+					mu.Lock()
+					refused++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-connection.done:
+	default:
+		t.Fatal("the slow consumer was not shut down")
+	}
+	if connection.closeCode != SlowConsumerCloseCode {
+		t.Errorf("close code = %d, want %d", connection.closeCode, SlowConsumerCloseCode)
+	}
+	if refused != 3*wsSendQueueSize {
+		t.Errorf("%d frames refused, want %d", refused, 3*wsSendQueueSize)
+	}
+}
+
+func TestShutdownKeepsTheFirstCloseCode(t *testing.T) {
+	rdb := newTestRedis(t)
+	connection := NewConnectionState(nil, rdb, context.Background(), testUserID, "auth-1")
+	defer connection.pubSub.Close()
+
+	codes := []int{websocket.CloseGoingAway, ForceDisconnectCloseCode, SlowConsumerCloseCode, AlbumAccessDeniedCloseCode}
+
+	var wg sync.WaitGroup
+	for _, code := range codes {
+		wg.Add(1)
+		go func(code int) {
+			defer wg.Done()
+			connection.Shutdown(code, "")
+		}(code)
+	}
+	wg.Wait()
+
+	<-connection.done
+	found := false
+	for _, code := range codes {
+		found = found || connection.closeCode == code
+	}
+	if !found {
+		t.Errorf("close code = %d, want one of %v", connection.closeCode, codes)
+	}
+}
+
+func TestRegistryConcurrentRegistrations(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	registry := NewConnectionRegistry(rdb)
+
+	const connections = 20
+	states := make([]*ConnectionState, connections)
+	for i := range states {
+		states[i] = NewConnectionState(nil, rdb, ctx, testUserID, "auth-1")
+		defer states[i].pubSub.Close()
+	}
+
+	// Half of the connections come and go while the user is disconnected
+	var wg sync.WaitGroup
+	for i, connection := range states {
+		wg.Add(1)
+		go func(i int, connection *ConnectionState) {
+			defer wg.Done()
+
+			err := registry.Register(ctx, connection)
+			if err != nil {
+				t.Errorf("register: %v", err)
+			}
+			if i%2 == 0 {
+				registry.Unregister(ctx, connection)
+			}
+		}(i, connection)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		registry.disconnectLocal(testUserID, "tokens revoked")
+	}()
+	wg.Wait()
+
+	userConnections, err := QueryUserConnections(ctx, rdb, testUserID)
+	if err != nil {
+		t.Fatalf("query connections: %v", err)
+	}
+	if len(userConnections) != connections/2 {
+		t.Errorf("%d connections registered, want %d", len(userConnections), connections/2)
+	}
+
+	stats, err := QueryConnectionStats(ctx, rdb)
+	if err != nil {
+		t.Fatalf("query stats: %v", err)
+	}
+	// The node only shows up in the stats once it sent a heartbeat
+	if stats.Connections != 0 {
+		t.Errorf("%d connections counted before the first heartbeat, want 0", stats.Connections)
+	}
+
+	registry.heartbeat(ctx)
+	stats, err = QueryConnectionStats(ctx, rdb)
+	if err != nil {
+		t.Fatalf("query stats: %v", err)
+	}
+	if stats.Connections != connections/2 || len(stats.Nodes) != 1 || stats.Nodes[0].Users != 1 {
+		t.Errorf("stats = %+v, want %d connections of 1 user on 1 node", stats, connections/2)
+	}
+
+	// Every registered connection is told to close, the later ones as well
+	registry.disconnectLocal(testUserID, "tokens revoked")
+	for i, connection := range states {
+		if i%2 == 0 {
+			continue
+		}
+		select {
+		case <-connection.done:
+		default:
+			t.Errorf("connection %d was not shut down", i)
+		}
+	}
+}
+
+func TestTypingIsNotSentBackToTheTypist(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	connection := serveTestConnection(t, rdb, NewConnectionRegistry(rdb))
+
+	for _, typistID := range []string{testUserID, "user-2"} {
+		err := PublishLiveWebSocketPayload(ctx, rdb, UserChannel(testUserID), WebSocketPayload{
+			Operation: "TYPING",
+			Type:      "comment",
+			UserID:    typistID,
+			Payload:   m.TypingIndicator{UserID: typistID, ImageID: "image-1"},
+		})
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+
+	var frame WebSocketPayload
+	connection.client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err := connection.client.ReadJSON(&frame)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if frame.Operation != "TYPING" || frame.UserID != "user-2" {
+		t.Errorf("frame = %+v, want the typing of the other user only", frame)
+	}
+}
+
+func TestReplayHoldsBackLiveFrames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rdb := newTestRedis(t)
+	connection := NewConnectionState(nil, rdb, ctx, testUserID, "auth-1")
+	defer connection.pubSub.Close()
+
+	channel := UserChannel(testUserID)
+
+	// 1 was seen by the client before it reconnected, 2 was missed
+	publishTestPayload(t, rdb, channel, 1)
+	publishTestPayload(t, rdb, channel, 2)
+	lastSeen, err := rdb.XRevRangeN(ctx, wsStreamKeyPrefix+channel, "+", "-", 2).Result()
+	if err != nil || len(lastSeen) != 2 {
+		t.Fatalf("read the stream: %v", err)
+	}
+
+	forwarding := make(chan struct{})
+	go func() {
+		defer close(forwarding)
+		connection.forwardMessages(ctx, nil)
+	}()
+	defer func() {
+		connection.Shutdown(websocket.CloseNormalClosure, "")
+		<-forwarding
+	}()
+
+	connection.beginReplay(channel)
+	err = connection.subscribe(ctx, channel, notificationsSubscription)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	// 3 is published after the subscription, so it is part of the replay and arrives live as well
+	publishTestPayload(t, rdb, channel, 3)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		connection.subMu.Lock()
+		buffered := len(connection.replayBuffers[channel])
+		connection.subMu.Unlock()
+		if buffered > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the live frame was not held back")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Publishers keep going while the replay is sent
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for number := 4; number <= 10; number++ {
+			publishTestPayload(t, rdb, channel, number)
+		}
+	}()
+
+	err = connection.replay(ctx, channel, notificationsSubscription, lastSeen[1].ID)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	wg.Wait()
+
+	for want := 2; want <= 10; want++ {
+		select {
+		case data := <-connection.send:
+			var frame testFrame
+			err := json.Unmarshal(data, &frame)
+			if err != nil {
+				t.Fatalf("decode %s: %v", data, err)
+			}
+			if frame.Payload != want {
+				t.Fatalf("received %d, want %d", frame.Payload, want)
+			}
+			if frame.Sequence == "" || frame.Subscription != notificationsSubscription {
+				t.Errorf("frame %d is not tagged: %s", want, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d was not received", want)
+		}
+	}
+
+	select {
+	case data := <-connection.send:
+		t.Errorf("unexpected frame after the live messages: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// benchmarkFanOut connects users that each receive one notification per iteration. channelOf is the channel a user's
+// notifications are published on, connections drop the messages of other users like the shared channel required.
+func benchmarkFanOut(b *testing.B, users int, channelOf func(userID string) string) {
+	ctx := context.Background()
+
+	server := miniredis.NewMiniRedis()
+	err := server.Start()
+	if err != nil {
+		b.Fatalf("start redis: %v", err)
+	}
+	defer server.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer rdb.Close()
+
+	var delivered sync.WaitGroup
+	var running sync.WaitGroup
+	payloads := make([][]byte, users)
+	pubSubs := make([]*redis.PubSub, users)
+
+	for i := 0; i < users; i++ {
+		userID := "user-" + strconv.Itoa(i)
+		payloads[i], _ = json.Marshal(WebSocketPayload{Operation: "INSERT", Type: "friend_request", UserID: userID})
+
+		pubSub := rdb.Subscribe(ctx, channelOf(userID))
+		pubSubs[i] = pubSub
+		_, err := pubSub.Receive(ctx)
+		if err != nil {
+			b.Fatalf("subscribe: %v", err)
+		}
+
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for message := range pubSub.Channel() {
+				var payload WebSocketPayload
+				if json.Unmarshal([]byte(message.Payload), &payload) != nil || payload.UserID != userID {
+					continue
+				}
+				delivered.Done()
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		delivered.Add(users)
+		for i := 0; i < users; i++ {
+			err := rdb.Publish(ctx, channelOf("user-"+strconv.Itoa(i)), payloads[i]).Err()
+			if err != nil {
+				b.Fatalf("publish: %v", err)
+			}
+		}
+		delivered.Wait()
+	}
+	b.StopTimer()
+
+	for _, pubSub := range pubSubs {
+		pubSub.Close()
+	}
+	running.Wait()
+}
+
+// BenchmarkNotificationFanOut compares every connection reading the shared notifications channel with connections
+// only reading their user's channel
+func BenchmarkNotificationFanOut(b *testing.B) {
+	for _, users := range []int{10, 50} {
+		b.Run("shared/"+strconv.Itoa(users), func(b *testing.B) {
+			benchmarkFanOut(b, users, func(string) string { return notificationsSubscription })
+		})
+		b.Run("per-user/"+strconv.Itoa(users), func(b *testing.B) {
+			benchmarkFanOut(b, users, UserChannel)
+		})
+	}
+}
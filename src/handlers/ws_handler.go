@@ -3,12 +3,13 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 	m "last_weekend_services/src/models"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
@@ -19,138 +20,433 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1048,
 }
 
-type ConnectionState struct {
-	Conn   *websocket.Conn
-	Active bool
-}
+// AlbumAccessDeniedCloseCode is sent when a user subscribes to, or loses access to, an album they cannot see
+const AlbumAccessDeniedCloseCode = 4403
+
+const (
+	notificationsSubscription = "notifications"
+	albumSubscriptionPrefix   = "album:"
+	wsAcksKeyPrefix           = "ws:acks:"
+	wsAcksTTL                 = 7 * 24 * time.Hour
+	typingKeyPrefix           = "typing:"
+	// A user's typing on an image is broadcast at most once per interval, clients show it for a little longer
+	typingInterval = 3 * time.Second
+)
 
 type WebSocketPayload struct {
-	Operation string      `json:"operation"`
-	Type      string      `json:"type"`
-	UserID    string      `json:"user_id"`
-	AlbumID   string      `json:"album_ID"`
-	Payload   interface{} `json:"payload"`
+	Operation    string      `json:"operation"`
+	Type         string      `json:"type"`
+	UserID       string      `json:"user_id"`
+	AlbumID      string      `json:"album_ID"`
+	EventID      string      `json:"event_id,omitempty"`
+	Sequence     string      `json:"sequence,omitempty"`
+	Subscription string      `json:"subscription,omitempty"`
+	Payload      interface{} `json:"payload"`
+}
+
+// WebSocketCommand is sent by the client over an open connection
+type WebSocketCommand struct {
+	Command   string `json:"command"` // subscribe, unsubscribe, ping, ack, typing
+	AlbumID   string `json:"album_id"`
+	ImageID   string `json:"image_id"`
+	EventID   string `json:"event_id"`
+	LastSeen  string `json:"last_seen"`  // Sequence of the last frame received, the frames after it are replayed
+	RequestID string `json:"request_id"` // Echoed back in the reply
 }
 
-func WebSocketEndpointHandler(connPool *m.PGPool, rdb *redis.Client, ctx context.Context) http.Handler {
+type WebSocketError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func WebSocketEndpointHandler(connPool *m.PGPool, rdb *redis.Client, registry *ConnectionRegistry, ctx context.Context) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
 		if !ok {
 			log.Printf("Failed to get validated claims")
 			return
 		}
+
+		revoked, err := sessionRevoked(ctx, connPool, claims.RegisteredClaims.Subject, claims.RegisteredClaims.IssuedAt)
+		if err != nil {
+			log.Printf("Unable to check the session: %v", err)
+			WriteResponseWithCode(w, http.StatusInternalServerError, "Unable to check the session")
+			return
+		}
+		if revoked {
+			WriteResponseWithCode(w, http.StatusUnauthorized, "Session was signed out")
+			return
+		}
+
 		switch r.URL.Path {
 		case "/ws":
-			WebSocket(w, r, connPool, rdb, ctx, claims.RegisteredClaims.Subject, "notifications")
+			WebSocket(w, r, connPool, rdb, registry, ctx, claims.RegisteredClaims.Subject, "")
 		case "/ws/album":
+			// Kept for clients that still open a socket per album, the album is subscribed to on connect
 			var channel string = r.URL.Query().Get("channel")
-			WebSocket(w, r, connPool, rdb, ctx, claims.RegisteredClaims.Subject, channel)
+			if channel == "" {
+				WriteResponseWithCode(w, http.StatusBadRequest, "channel is required")
+				return
+			}
+			WebSocket(w, r, connPool, rdb, registry, ctx, claims.RegisteredClaims.Subject, channel)
 		}
 	})
 }
 
-func WebSocket(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, ctx context.Context, auth0UID string, channel string) {
-	var uid string
+// WebSocket upgrades the request and serves the connection. Without an albumID the connection starts out subscribed
+// to the user's notifications, with one it only follows that album and is closed if the user cannot see it.
+func WebSocket(w http.ResponseWriter, r *http.Request, connPool *m.PGPool, rdb *redis.Client, registry *ConnectionRegistry, ctx context.Context, auth0UID string, albumID string) {
+	// Upgrade replies to the client itself when it fails
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "failed to upgrade websocket: %s", err)
+		log.Printf("Failed to upgrade websocket: %v", err)
 		return
 	}
 
-	uidQuery := `SELECT user_id FROM users WHERE auth_zero_id = $1`
-	err = connPool.Pool.QueryRow(ctx, uidQuery, auth0UID).Scan(&uid)
+	// The request is hijacked from here on, errors can only be sent as close frames
+	connection, err := newUserConnection(ctx, connPool, rdb, conn, auth0UID)
 	if err != nil {
-		WriteErrorToWriter(w, "Error: Unable to lookup requesting user")
 		log.Printf("Unable to lookup requesting user: %v", err)
+		sendCloseFrame(conn, websocket.CloseInternalServerErr, "unable to lookup requesting user")
+		conn.Close()
 		return
 	}
+	uid := connection.UserID
+	lastSeen := r.URL.Query().Get("last_seen")
 
-	var newConnection = ConnectionState{Conn: conn, Active: true}
+	if albumID == "" {
+		err = connection.subscribe(ctx, UserChannel(uid), notificationsSubscription)
+		if err == nil {
+			err = connection.replay(ctx, UserChannel(uid), notificationsSubscription, lastSeen)
+		}
+	} else {
+		var hasAccess bool
+
+		hasAccess, err = connection.subscribeAuthorizedAlbum(ctx, connPool, albumID)
+		if err == nil && !hasAccess {
+			sendCloseFrame(conn, AlbumAccessDeniedCloseCode, "album access denied")
+			connection.pubSub.Close()
+			conn.Close()
+			return
+		}
+		if err == nil {
+			err = connection.replay(ctx, albumID, albumSubscriptionPrefix+albumID, lastSeen)
+		}
+	}
+	if err != nil {
+		log.Printf("Unable to subscribe websocket: %v", err)
+		sendCloseFrame(conn, websocket.CloseInternalServerErr, "unable to subscribe")
+		connection.pubSub.Close()
+		conn.Close()
+		return
+	}
+
+	err = registry.Register(ctx, connection)
+	if err != nil {
+		// The connection still works, it is only missing from the counts and cannot be closed from other nodes
+		log.Printf("Unable to register websocket: %v", err)
+	}
+	defer registry.Unregister(ctx, connection)
 
 	//log.Printf("Listening via %v WebSocket...", channel)
 
-	quit := make(chan int)
-	go newConnection.ListenAndWrite(ctx, conn, rdb, uid, quit, channel)
-	go newConnection.CheckConnectionStatus(ctx, conn, quit)
+	connection.Serve(ctx, connPool, rdb)
+}
+
+// newUserConnection creates the state of a connection for the requesting user, conn is nil for event streams
+func newUserConnection(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, conn *websocket.Conn, auth0UID string) (*ConnectionState, error) {
+	var uid, firstName, lastName string
+
+	uidQuery := `SELECT user_id, first_name, last_name FROM users WHERE auth_zero_id = $1`
+	err := connPool.Pool.QueryRow(ctx, uidQuery, auth0UID).Scan(&uid, &firstName, &lastName)
+	if err != nil {
+		return nil, err
+	}
 
+	connection := NewConnectionState(conn, rdb, ctx, uid, auth0UID)
+	connection.FirstName = firstName
+	connection.LastName = lastName
+	return connection, nil
 }
 
-func (connectionState *ConnectionState) ListenAndWrite(ctx context.Context, conn *websocket.Conn, rdb *redis.Client, uid string, quit chan int, channel string) {
-	pubSub := rdb.Subscribe(ctx, channel)
-	for connectionState.Active == true {
-		notificationChannel := pubSub.Channel(redis.WithChannelSize(250))
+func (connectionState *ConnectionState) handleCommand(ctx context.Context, connPool *m.PGPool, rdb *redis.Client, command WebSocketCommand) {
+	switch command.Command {
+	case "ping":
+		connectionState.writeReply(command, "PONG", "", nil)
+	case "subscribe":
+		subscription := albumSubscriptionPrefix + command.AlbumID
 
-		select {
-		case message := <-notificationChannel:
+		// Messages are already being forwarded, the album's are held back until its replay is sent
+		connectionState.beginReplay(command.AlbumID)
 
-			err := sendWebSocketNotification(conn, message, uid, channel)
-			if err != nil {
-				log.Printf("ListenAndWriteError: %v", err)
-				return
-			}
-		case <-quit:
-			connectionState.Active = false
+		hasAccess, err := connectionState.subscribeAuthorizedAlbum(ctx, connPool, command.AlbumID)
+		if err != nil {
+			connectionState.finishReplay(command.AlbumID, "")
+			log.Printf("Unable to subscribe to album: %v", err)
+			connectionState.writeError(command, subscription, http.StatusInternalServerError, "album could not be subscribed to")
+			return
 		}
+		if !hasAccess {
+			connectionState.finishReplay(command.AlbumID, "")
+			connectionState.writeError(command, subscription, AlbumAccessDeniedCloseCode, "album access denied")
+			return
+		}
+
+		connectionState.writeReply(command, "SUBSCRIBED", subscription, nil)
+
+		err = connectionState.replay(ctx, command.AlbumID, subscription, command.LastSeen)
+		if err != nil {
+			log.Printf("Unable to replay album events: %v", err)
+		}
+	case "unsubscribe":
+		subscription := albumSubscriptionPrefix + command.AlbumID
+
+		err := connectionState.unsubscribeAlbum(ctx, command.AlbumID)
+		if err != nil {
+			log.Printf("Unable to unsubscribe from album: %v", err)
+			connectionState.writeError(command, subscription, http.StatusInternalServerError, "album could not be unsubscribed from")
+			return
+		}
+
+		connectionState.writeReply(command, "UNSUBSCRIBED", subscription, nil)
+	case "ack":
+		// The last acknowledged event of every subscription is kept so a reconnecting client knows where it left off
+		subscription := notificationsSubscription
+		if command.AlbumID != "" {
+			subscription = albumSubscriptionPrefix + command.AlbumID
+		}
+
+		err := rdb.HSet(ctx, wsAcksKeyPrefix+connectionState.UserID, subscription, command.EventID).Err()
+		if err == nil {
+			err = rdb.Expire(ctx, wsAcksKeyPrefix+connectionState.UserID, wsAcksTTL).Err()
+		}
+		if err != nil {
+			log.Printf("Unable to store websocket ack: %v", err)
+			connectionState.writeError(command, subscription, http.StatusInternalServerError, "ack could not be stored")
+			return
+		}
+
+		connectionState.writeReply(command, "ACKED", subscription, nil)
+	case "typing":
+		// Typing is sent while the user types, it is not acknowledged unless it fails
+		subscription := albumSubscriptionPrefix + command.AlbumID
+
+		if _, ok := connectionState.subscription(command.AlbumID); !ok {
+			connectionState.writeError(command, subscription, http.StatusBadRequest, "album is not subscribed to")
+			return
+		}
+		if command.ImageID == "" {
+			connectionState.writeError(command, subscription, http.StatusBadRequest, "image_id is required")
+			return
+		}
+
+		err := connectionState.broadcastTyping(ctx, rdb, command.AlbumID, command.ImageID)
+		if err != nil {
+			log.Printf("Unable to broadcast typing: %v", err)
+			connectionState.writeError(command, subscription, http.StatusInternalServerError, "typing could not be sent")
+		}
+	default:
+		connectionState.writeError(command, "", http.StatusBadRequest, "unknown command")
+	}
+}
+
+// broadcastTyping tells the viewers of the album that the user is commenting on the image. The key expiring is the
+// rate limit, it is shared by all of the user's connections.
+func (connectionState *ConnectionState) broadcastTyping(ctx context.Context, rdb *redis.Client, albumID string, imageID string) error {
+	typingKey := typingKeyPrefix + albumID + ":" + imageID + ":" + connectionState.UserID
+
+	first, err := rdb.SetNX(ctx, typingKey, 1, typingInterval).Result()
+	if err != nil || !first {
+		return err
 	}
 
-	//log.Printf("The %v websocket is closing..", channel)
-	err := pubSub.Close()
+	return PublishLiveWebSocketPayload(ctx, rdb, albumID, WebSocketPayload{
+		Operation: "TYPING",
+		Type:      "comment",
+		UserID:    connectionState.UserID,
+		AlbumID:   albumID,
+		Payload: m.TypingIndicator{
+			UserID:    connectionState.UserID,
+			FirstName: connectionState.FirstName,
+			LastName:  connectionState.LastName,
+			ImageID:   imageID,
+		},
+	})
+}
+
+// isOwnTyping reports whether the message is the typing indicator of the user, which is not sent back to them. Only
+// messages that can be one are decoded.
+func isOwnTyping(payload string, userID string) bool {
+	if !strings.Contains(payload, `"TYPING"`) {
+		return false
+	}
+
+	var typing struct {
+		Operation string `json:"operation"`
+		UserID    string `json:"user_id"`
+	}
+	err := json.Unmarshal([]byte(payload), &typing)
+	return err == nil && typing.Operation == "TYPING" && typing.UserID == userID
+}
+
+func (connectionState *ConnectionState) subscribe(ctx context.Context, channel string, subscription string) error {
+	err := connectionState.pubSub.Subscribe(ctx, channel)
 	if err != nil {
-		log.Printf("Error closing redis channel: %v with error: %v", channel, err)
-		return
+		return err
 	}
-	err = conn.Close()
+
+	connectionState.subMu.Lock()
+	connectionState.subscriptions[channel] = subscription
+	connectionState.subMu.Unlock()
+	return nil
+}
+
+func (connectionState *ConnectionState) subscribeAlbum(ctx context.Context, albumID string) error {
+	err := connectionState.subscribe(ctx, albumID, albumSubscriptionPrefix+albumID)
 	if err != nil {
-		log.Printf("Error closing websocket: %v", err)
-		return
+		return err
 	}
-	return
+
+	err = connectionState.subscribe(ctx, AlbumAccessChannel(albumID), albumSubscriptionPrefix+albumID)
+	if err != nil {
+		return err
+	}
+
+	err = connectionState.joinAlbumPresence(ctx, albumID)
+	if err != nil {
+		// Presence is best effort, the album updates still reach the connection
+		log.Printf("Unable to join album presence: %v", err)
+	}
+	return nil
 }
 
-func (connectionState *ConnectionState) CheckConnectionStatus(ctx context.Context, conn *websocket.Conn, quit chan int) {
+// subscribeAuthorizedAlbum subscribes to the album if the user can see it
+func (connectionState *ConnectionState) subscribeAuthorizedAlbum(ctx context.Context, connPool *m.PGPool, albumID string) (bool, error) {
+	hasAccess, err := userHasAlbumAccess(ctx, connPool, albumID, connectionState.AuthZeroID)
+	if err != nil || !hasAccess {
+		return false, err
+	}
 
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				log.Printf("Expected Closure: %v", err)
-				quit <- 0
-				return
-			}
-			log.Printf("WebSocket Error: %v", err)
-			quit <- 0
-			return
+	return true, connectionState.subscribeAlbum(ctx, albumID)
+}
+
+func (connectionState *ConnectionState) unsubscribeAlbum(ctx context.Context, albumID string) error {
+	err := connectionState.leaveAlbumPresence(ctx, albumID)
+	if err != nil {
+		log.Printf("Unable to leave album presence: %v", err)
+	}
+
+	connectionState.subMu.Lock()
+	delete(connectionState.subscriptions, albumID)
+	delete(connectionState.subscriptions, AlbumAccessChannel(albumID))
+	delete(connectionState.replayedUpTo, albumID)
+	delete(connectionState.replayBuffers, albumID)
+	connectionState.subMu.Unlock()
+
+	return connectionState.pubSub.Unsubscribe(ctx, albumID, AlbumAccessChannel(albumID))
+}
+
+// albumIDs lists the albums the connection is subscribed to
+func (connectionState *ConnectionState) albumIDs() []string {
+	connectionState.subMu.Lock()
+	defer connectionState.subMu.Unlock()
+
+	var albumIDs []string
+	for channel, subscription := range connectionState.subscriptions {
+		if subscription == albumSubscriptionPrefix+channel {
+			albumIDs = append(albumIDs, channel)
 		}
 	}
+	return albumIDs
 }
 
-func sendWebSocketNotification(conn *websocket.Conn, message *redis.Message, uid string, channel string) error {
-	var wsPayload WebSocketPayload
-	err := json.Unmarshal([]byte(message.Payload), &wsPayload)
+func (connectionState *ConnectionState) subscription(channel string) (string, bool) {
+	connectionState.subMu.Lock()
+	defer connectionState.subMu.Unlock()
+
+	subscription, ok := connectionState.subscriptions[channel]
+	return subscription, ok
+}
+
+// recheckAlbumAccess drops the album subscription if the user can no longer see the album. Connections opened on
+// /ws/album only follow that album, so they are closed instead.
+func (connectionState *ConnectionState) recheckAlbumAccess(ctx context.Context, connPool *m.PGPool, albumID string) {
+	hasAccess, err := userHasAlbumAccess(ctx, connPool, albumID, connectionState.AuthZeroID)
 	if err != nil {
-		return err
+		// Keep the subscription, the next change to the album triggers another check
+		log.Printf("Unable to check album access: %v", err)
+		return
+	}
+	if hasAccess {
+		return
 	}
 
-	switch channel {
-	case "notifications":
-		if wsPayload.UserID == uid {
-			err = conn.WriteMessage(websocket.TextMessage, []byte(message.Payload))
-			if err != nil {
-				log.Printf("sendWebSocketNotification: %v", err)
-				return err
-			}
-		}
-		return nil
-	case wsPayload.AlbumID:
-		if wsPayload.AlbumID == channel {
-			err = conn.WriteMessage(websocket.TextMessage, []byte(message.Payload))
-			if err != nil {
-				log.Printf("sendWebSocketNotification: %v", err)
-				return err
-			}
-		}
-		return nil
+	err = connectionState.unsubscribeAlbum(ctx, albumID)
+	if err != nil {
+		log.Printf("Unable to unsubscribe from album: %v", err)
 	}
-	return nil
+
+	connectionState.subMu.Lock()
+	remaining := len(connectionState.subscriptions)
+	connectionState.subMu.Unlock()
+
+	if remaining == 0 {
+		connectionState.Shutdown(AlbumAccessDeniedCloseCode, "album access revoked")
+		return
+	}
+
+	connectionState.writeReply(WebSocketCommand{}, "UNSUBSCRIBED", albumSubscriptionPrefix+albumID,
+		WebSocketError{Code: AlbumAccessDeniedCloseCode, Message: "album access revoked"})
+}
+
+func (connectionState *ConnectionState) writeReply(command WebSocketCommand, operation string, subscription string, payload interface{}) {
+	reply := WebSocketPayload{
+		Operation:    operation,
+		Type:         "command",
+		UserID:       connectionState.UserID,
+		AlbumID:      command.AlbumID,
+		EventID:      command.RequestID,
+		Subscription: subscription,
+		Payload:      payload,
+	}
+
+	jsonReply, err := json.Marshal(reply)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	err = connectionState.Enqueue(jsonReply)
+	if err != nil {
+		log.Printf("Unable to write websocket reply: %v", err)
+	}
+}
+
+func (connectionState *ConnectionState) writeError(command WebSocketCommand, subscription string, code int, message string) {
+	connectionState.writeReply(command, "ERROR", subscription, WebSocketError{Code: code, Message: message})
+}
+
+// AlbumAccessChannel is the Redis channel that tells live album connections to re-check the user's access
+func AlbumAccessChannel(albumID string) string {
+	return albumSubscriptionPrefix + albumID + ":access"
+}
+
+func albumIDFromAccessChannel(channel string) (string, bool) {
+	if !strings.HasPrefix(channel, albumSubscriptionPrefix) || !strings.HasSuffix(channel, ":access") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(channel, albumSubscriptionPrefix), ":access"), true
+}
+
+// sendCloseFrame tells the client why the connection is about to be closed
+func sendCloseFrame(conn *websocket.Conn, code int, reason string) {
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	if err != nil {
+		log.Printf("Error sending close frame: %v", err)
+	}
+}
+
+// UserChannel is the Redis channel that the notifications of a single user are published on
+func UserChannel(userID string) string {
+	return "user:" + userID
 }
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	m "last_weekend_services/src/models"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Nodes scored by their last heartbeat
+	wsNodesKey = "ws:nodes"
+	// Hash of the connections a node holds, connection ID to user ID
+	wsNodeKeyPrefix = "ws:node:"
+	// Hash of the connections a user has, connection ID to node ID
+	wsUserConnectionsKeyPrefix = "ws:user:"
+	// Every node listens here for connections it has to close
+	wsControlChannel = "ws:control"
+
+	wsNodeHeartbeat = 15 * time.Second
+	// A node that misses this many heartbeats is gone and its connections are removed from the registry
+	wsNodeTimeout = 4 * wsNodeHeartbeat
+)
+
+// ForceDisconnectCloseCode is sent when a user's connections are closed by DisconnectUser
+const ForceDisconnectCloseCode = 4401
+
+// ConnectionRegistry records in Redis which node holds which user's connections, so connections can be counted and
+// closed across nodes. Every node has one, created in main.
+type ConnectionRegistry struct {
+	NodeID string
+
+	rdb         *redis.Client
+	mu          sync.Mutex
+	connections map[string]*ConnectionState
+}
+
+type controlMessage struct {
+	Command string `json:"command"` // disconnect
+	UserID  string `json:"user_id"`
+	Reason  string `json:"reason"`
+}
+
+func NewConnectionRegistry(rdb *redis.Client) *ConnectionRegistry {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "node"
+	}
+
+	return &ConnectionRegistry{
+		NodeID:      hostname + "-" + uuid.NewString()[:8],
+		rdb:         rdb,
+		connections: make(map[string]*ConnectionState),
+	}
+}
+
+func (registry *ConnectionRegistry) Register(ctx context.Context, connectionState *ConnectionState) error {
+	registry.mu.Lock()
+	registry.connections[connectionState.ID] = connectionState
+	registry.mu.Unlock()
+
+	pipe := registry.rdb.TxPipeline()
+	pipe.HSet(ctx, wsNodeKeyPrefix+registry.NodeID, connectionState.ID, connectionState.UserID)
+	pipe.HSet(ctx, wsUserConnectionsKeyPrefix+connectionState.UserID, connectionState.ID, registry.NodeID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (registry *ConnectionRegistry) Unregister(ctx context.Context, connectionState *ConnectionState) {
+	registry.mu.Lock()
+	delete(registry.connections, connectionState.ID)
+	registry.mu.Unlock()
+
+	pipe := registry.rdb.TxPipeline()
+	pipe.HDel(ctx, wsNodeKeyPrefix+registry.NodeID, connectionState.ID)
+	pipe.HDel(ctx, wsUserConnectionsKeyPrefix+connectionState.UserID, connectionState.ID)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		log.Printf("Unable to unregister connection %v: %v", connectionState.ID, err)
+	}
+}
+
+// Run sends the node's heartbeat, removes the nodes that stopped sending theirs and closes the connections other nodes
+// ask it to. The node is removed from the registry when the context is cancelled.
+func (registry *ConnectionRegistry) Run(ctx context.Context) {
+	control := registry.rdb.Subscribe(ctx, wsControlChannel)
+	defer control.Close()
+	messages := control.Channel()
+
+	ticker := time.NewTicker(wsNodeHeartbeat)
+	defer ticker.Stop()
+
+	registry.heartbeat(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The request context is gone, the node is removed with a fresh one
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := removeNode(cleanupCtx, registry.rdb, registry.NodeID)
+			if err != nil {
+				log.Printf("Unable to remove node %v from the registry: %v", registry.NodeID, err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			registry.heartbeat(ctx)
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var command controlMessage
+			err := json.Unmarshal([]byte(message.Payload), &command)
+			if err != nil {
+				log.Printf("Unable to read control message: %v", err)
+				continue
+			}
+
+			if command.Command == "disconnect" {
+				registry.disconnectLocal(command.UserID, command.Reason)
+			}
+		}
+	}
+}
+
+func (registry *ConnectionRegistry) heartbeat(ctx context.Context) {
+	err := registry.rdb.ZAdd(ctx, wsNodesKey, redis.Z{Score: float64(time.Now().Unix()), Member: registry.NodeID}).Err()
+	if err != nil {
+		log.Printf("Unable to send node heartbeat: %v", err)
+		return
+	}
+
+	cutoff := strconv.FormatInt(time.Now().Add(-wsNodeTimeout).Unix(), 10)
+
+	deadNodes, err := registry.rdb.ZRangeByScore(ctx, wsNodesKey, &redis.ZRangeBy{Min: "-inf", Max: "(" + cutoff}).Result()
+	if err != nil {
+		log.Printf("Unable to find dead nodes: %v", err)
+		return
+	}
+
+	for _, nodeID := range deadNodes {
+		err = removeNode(ctx, registry.rdb, nodeID)
+		if err != nil {
+			log.Printf("Unable to remove dead node %v: %v", nodeID, err)
+		}
+	}
+}
+
+func (registry *ConnectionRegistry) disconnectLocal(userID string, reason string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, connectionState := range registry.connections {
+		if connectionState.UserID == userID {
+			connectionState.Shutdown(ForceDisconnectCloseCode, reason)
+		}
+	}
+}
+
+// removeNode deletes a node and its connections from the registry
+func removeNode(ctx context.Context, rdb *redis.Client, nodeID string) error {
+	connections, err := rdb.HGetAll(ctx, wsNodeKeyPrefix+nodeID).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := rdb.TxPipeline()
+	for connectionID, userID := range connections {
+		pipe.HDel(ctx, wsUserConnectionsKeyPrefix+userID, connectionID)
+	}
+	pipe.Del(ctx, wsNodeKeyPrefix+nodeID)
+	pipe.ZRem(ctx, wsNodesKey, nodeID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DisconnectUser closes every WebSocket and event stream of the user on all nodes, e.g. when the user signs out
+// everywhere
+func DisconnectUser(ctx context.Context, rdb *redis.Client, userID string, reason string) error {
+	jsonCommand, err := json.Marshal(controlMessage{Command: "disconnect", UserID: userID, Reason: reason})
+	if err != nil {
+		return err
+	}
+
+	return rdb.Publish(ctx, wsControlChannel, jsonCommand).Err()
+}
+
+// QueryConnectionStats counts the connections of every live node
+func QueryConnectionStats(ctx context.Context, rdb *redis.Client) (m.ConnectionStats, error) {
+	stats := m.ConnectionStats{Nodes: []m.NodeConnections{}}
+
+	cutoff := strconv.FormatInt(time.Now().Add(-wsNodeTimeout).Unix(), 10)
+
+	nodes, err := rdb.ZRangeByScoreWithScores(ctx, wsNodesKey, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err != nil {
+		return stats, err
+	}
+
+	for _, node := range nodes {
+		nodeID := node.Member.(string)
+
+		connections, err := rdb.HGetAll(ctx, wsNodeKeyPrefix+nodeID).Result()
+		if err != nil {
+			return stats, err
+		}
+
+		users := make(map[string]struct{})
+		for _, userID := range connections {
+			users[userID] = struct{}{}
+		}
+
+		stats.Nodes = append(stats.Nodes, m.NodeConnections{
+			NodeID:        nodeID,
+			Connections:   len(connections),
+			Users:         len(users),
+			LastHeartbeat: time.Unix(int64(node.Score), 0).UTC(),
+		})
+		stats.Connections += len(connections)
+	}
+
+	return stats, nil
+}
+
+func QueryUserConnections(ctx context.Context, rdb *redis.Client, userID string) ([]m.UserConnection, error) {
+	connections, err := rdb.HGetAll(ctx, wsUserConnectionsKeyPrefix+userID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	userConnections := []m.UserConnection{}
+	for connectionID, nodeID := range connections {
+		userConnections = append(userConnections, m.UserConnection{ConnectionID: connectionID, NodeID: nodeID})
+	}
+	return userConnections, nil
+}
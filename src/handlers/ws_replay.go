@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	wsStreamKeyPrefix = "stream:"
+	// Entries kept per channel, trimming is approximate so Redis can drop whole nodes
+	wsStreamMaxLen = 500
+	// Streams of channels nobody publishes to anymore expire on their own
+	wsStreamTTL = 24 * time.Hour
+	// Missed events sent on reconnect, a client that missed more has to reload instead. Kept below wsSendQueueSize so
+	// a replay cannot overflow the send queue.
+	wsReplayLimit = 100
+)
+
+// PublishWebSocketPayload appends the payload to the stream of the channel and publishes it to the live connections.
+// The ID of the stream entry is the payload's sequence, clients send the last one they saw when they reconnect.
+func PublishWebSocketPayload(ctx context.Context, rdb *redis.Client, channel string, payload WebSocketPayload) error {
+	jsonPayload, err := json.MarshalIndent(payload, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	streamKey := wsStreamKeyPrefix + channel
+
+	sequence, err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: wsStreamMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{"payload": jsonPayload},
+	}).Result()
+	if err != nil {
+		return err
+	}
+
+	err = rdb.Expire(ctx, streamKey, wsStreamTTL).Err()
+	if err != nil {
+		log.Printf("Unable to set expiry of %v: %v", streamKey, err)
+	}
+
+	return rdb.Publish(ctx, channel, tagFrame(jsonPayload, "sequence", sequence)).Err()
+}
+
+// PublishLiveWebSocketPayload only reaches the connections that are open right now, it is used for signals that are
+// stale by the time a client reconnects, like presence and typing.
+func PublishLiveWebSocketPayload(ctx context.Context, rdb *redis.Client, channel string, payload WebSocketPayload) error {
+	jsonPayload, err := json.MarshalIndent(payload, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return rdb.Publish(ctx, channel, jsonPayload).Err()
+}
+
+// liveFrame is a message of a subscribed channel, payload is what was published and data the frame sent to the client
+type liveFrame struct {
+	payload string
+	data    []byte
+}
+
+// beginReplay holds back the live messages of the channel until finishReplay, so none of them overtakes the replay. It
+// is called before the channel is subscribed to.
+func (connectionState *ConnectionState) beginReplay(channel string) {
+	connectionState.subMu.Lock()
+	connectionState.replayBuffers[channel] = []liveFrame{}
+	connectionState.subMu.Unlock()
+}
+
+// replay sends the entries of the channel's stream that come after lastSeen, then the live messages that arrived in
+// the meantime. Live messages that were already replayed are skipped, also the ones forwardMessages sees afterwards.
+func (connectionState *ConnectionState) replay(ctx context.Context, channel string, subscription string, lastSeen string) error {
+	var replayedUpTo string
+	defer func() { connectionState.finishReplay(channel, replayedUpTo) }()
+
+	if lastSeen == "" {
+		return nil
+	}
+
+	entries, err := connectionState.rdb.XRangeN(ctx, wsStreamKeyPrefix+channel, "("+lastSeen, "+", wsReplayLimit+1).Result()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) > wsReplayLimit {
+		// Too much was missed to replay, the client reloads the subscription instead
+		connectionState.writeReply(WebSocketCommand{}, "RESYNC", subscription, nil)
+		return nil
+	}
+
+	for _, entry := range entries {
+		payload, ok := entry.Values["payload"].(string)
+		if !ok {
+			continue
+		}
+
+		frame := tagFrame(tagFrame([]byte(payload), "sequence", entry.ID), "subscription", subscription)
+
+		err = connectionState.Enqueue(frame)
+		if err != nil {
+			return err
+		}
+		replayedUpTo = entry.ID
+	}
+
+	return nil
+}
+
+// finishReplay records the last replayed sequence and sends the live messages held back during the replay that it did
+// not already send. They are sent under the lock, so forwardMessages cannot slip a newer message in between.
+func (connectionState *ConnectionState) finishReplay(channel string, replayedUpTo string) {
+	connectionState.subMu.Lock()
+	defer connectionState.subMu.Unlock()
+
+	buffered, replaying := connectionState.replayBuffers[channel]
+	if !replaying {
+		return
+	}
+	delete(connectionState.replayBuffers, channel)
+
+	if replayedUpTo != "" {
+		connectionState.replayedUpTo[channel] = replayedUpTo
+	}
+
+	for _, frame := range buffered {
+		if connectionState.alreadyReplayed(channel, frame.payload) {
+			continue
+		}
+
+		err := connectionState.Enqueue(frame.data)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// passLive reports whether a live message should be sent now. Messages of a channel being replayed are held back
+// until the replay is done, and messages the replay already sent are dropped.
+func (connectionState *ConnectionState) passLive(channel string, frame liveFrame) bool {
+	connectionState.subMu.Lock()
+	defer connectionState.subMu.Unlock()
+
+	if buffered, replaying := connectionState.replayBuffers[channel]; replaying {
+		connectionState.replayBuffers[channel] = append(buffered, frame)
+		return false
+	}
+
+	return !connectionState.alreadyReplayed(channel, frame.payload)
+}
+
+// alreadyReplayed reports whether a live message was already sent by a replay. Once a newer message arrives the
+// channel is caught up and messages are no longer decoded. subMu has to be held.
+func (connectionState *ConnectionState) alreadyReplayed(channel string, payload string) bool {
+	replayedUpTo, catchingUp := connectionState.replayedUpTo[channel]
+	if !catchingUp {
+		return false
+	}
+
+	var sequenced struct {
+		Sequence string `json:"sequence"`
+	}
+	err := json.Unmarshal([]byte(payload), &sequenced)
+	if err != nil || sequenced.Sequence == "" {
+		return false
+	}
+
+	if compareStreamIDs(sequenced.Sequence, replayedUpTo) <= 0 {
+		return true
+	}
+
+	delete(connectionState.replayedUpTo, channel)
+	return false
+}
+
+// compareStreamIDs orders two Redis stream IDs of the form <milliseconds>-<sequence>
+func compareStreamIDs(a string, b string) int {
+	aTime, aSeq := splitStreamID(a)
+	bTime, bSeq := splitStreamID(b)
+
+	switch {
+	case aTime != bTime:
+		if aTime < bTime {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func splitStreamID(id string) (uint64, uint64) {
+	timePart, seqPart, _ := strings.Cut(id, "-")
+
+	ms, _ := strconv.ParseUint(timePart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
+
+// tagFrame adds a string field to a WebSocketPayload without decoding it, payloads are always JSON objects
+func tagFrame(payload []byte, key string, value string) []byte {
+	if len(payload) < 2 || payload[0] != '{' {
+		return payload
+	}
+
+	jsonKey, _ := json.Marshal(key)
+	jsonValue, _ := json.Marshal(value)
+
+	tagged := make([]byte, 0, len(payload)+len(jsonKey)+len(jsonValue)+3)
+	tagged = append(tagged, '{')
+	tagged = append(tagged, jsonKey...)
+	tagged = append(tagged, ':')
+	tagged = append(tagged, jsonValue...)
+	if len(bytes.TrimSpace(payload[1:])) > 1 {
+		tagged = append(tagged, ',')
+	}
+	return append(tagged, payload[1:]...)
+}
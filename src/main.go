@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
+	"last_weekend_services/src/email"
+	"last_weekend_services/src/events"
 	h "last_weekend_services/src/handlers"
 	i "last_weekend_services/src/inits"
 	"last_weekend_services/src/middleware"
+	"last_weekend_services/src/push"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 )
 
 func main() {
@@ -58,6 +62,25 @@ func main() {
 	storageBucket := os.Getenv("STORAGE_BUCKET")
 	stagingBucket := os.Getenv("STAGING_BUCKET")
 
+	// Push Config Vals
+	pushProvider := os.Getenv("PUSH_PROVIDER")
+	apnsKeyPath := os.Getenv("APNS_KEY_PATH")
+	apnsKeyID := os.Getenv("APNS_KEY_ID")
+	apnsTeamID := os.Getenv("APNS_TEAM_ID")
+	apnsTopic := os.Getenv("APNS_TOPIC")
+	apnsProduction := os.Getenv("APNS_PRODUCTION") == "true"
+
+	// Email Config Vals
+	smtpHost := os.Getenv("SMTP_HOST")
+	smtpUser := os.Getenv("SMTP_USER")
+	smtpPassword := os.Getenv("SMTP_PASSWORD")
+	smtpFrom := os.Getenv("SMTP_FROM")
+	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		smtpPort = 587
+	}
+	appURL := os.Getenv("APP_URL")
+
 	// Postgres Initialization
 	connString := fmt.Sprintf("user=%v password=%v host=%v dbname=%v",
 		dbUser, dbPassword, unixSocketPath, dbName)
@@ -83,20 +106,80 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Initialize Firebase SDK
-	config := firebase.Config{
-		ProjectID: "lastweekend",
+	// Push Senders - PUSH_PROVIDER=fake records pushes instead of sending them, to run without push credentials
+	var pushSenders push.Senders
+	if pushProvider == "fake" {
+		fakeSender := push.NewFake()
+		pushSenders = push.Senders{push.FCM: fakeSender, push.APNs: fakeSender}
+	} else {
+		// Initialize Firebase SDK
+		config := firebase.Config{
+			ProjectID: "lastweekend",
+		}
+		app, err := firebase.NewApp(ctx, &config)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Initialize Firebase Messaging
+		messagingClient, err := app.Messaging(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pushSenders = push.Senders{push.FCM: push.NewFCMSender(messagingClient)}
+
+		// APNs device tokens are sent to directly when a key is configured
+		if apnsKeyPath != "" {
+			apnsKey, err := os.ReadFile(apnsKeyPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			apnsSender, err := push.NewAPNsSender(push.APNsConfig{
+				Key:        apnsKey,
+				KeyID:      apnsKeyID,
+				TeamID:     apnsTeamID,
+				Topic:      apnsTopic,
+				Production: apnsProduction,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			pushSenders[push.APNs] = apnsSender
+		}
 	}
-	app, err := firebase.NewApp(ctx, &config)
-	if err != nil {
-		log.Fatal(err)
+
+	// Email Sender - emails are off without an SMTP server, a local SMTP sink works for development
+	var emailSender email.Sender
+	if smtpHost != "" {
+		emailSender = email.NewSMTPSender(email.SMTPConfig{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: smtpUser,
+			Password: smtpPassword,
+			From:     smtpFrom,
+		})
 	}
 
-	// Initialize Firebase Messaging
-	messagingClient, err := app.Messaging(ctx)
-	if err != nil {
-		log.Fatal(err)
+	// Event Bus
+	eventBus := events.NewRedisBus(rdb)
+	h.RegisterEventSubscribers(eventBus, connPool, rdb, pushSenders, emailSender, appURL)
+
+	// WebSocket Connection Registry
+	connectionRegistry := h.NewConnectionRegistry(rdb)
+
+	// Background Jobs
+	go eventBus.Run(ctx)
+	go connectionRegistry.Run(ctx)
+	go h.AlbumTemplateScheduler(ctx, connPool, time.Minute)
+	go h.AlbumPurgeJob(ctx, connPool, *gcpStorage, storageBucket, time.Hour)
+	go h.AlbumRevealJob(ctx, connPool, time.Minute)
+	go h.FirebaseTokenCleanupJob(ctx, connPool, 24*time.Hour)
+	if emailSender != nil {
+		go h.WeeklyDigestJob(ctx, connPool, emailSender, appURL, time.Hour)
 	}
+	go h.OutboxDispatcher(ctx, connPool, rdb, eventBus, time.Second)
+	go h.AlbumPresenceSweeper(ctx, rdb, 30*time.Second)
 
 	//Server Starting String
 	host := "0.0.0.0"
@@ -105,38 +188,57 @@ func main() {
 	r := mux.NewRouter()
 
 	jwtMiddleware := middleware.EnsureValidToken(authDomain, authAudience)
+	adminScope := middleware.RequireScope("admin:connections")
+	adminEventsScope := middleware.RequireScope("admin:events")
 
 	//Route Register
 	r.HandleFunc("/", connPool.GETHandlerRoot)
 	r.HandleFunc("/.well-known/apple-app-site-association", h.AssociatedDomains)                                                         // Unprotected
-	r.Handle("/ws", jwtMiddleware(h.WebSocketEndpointHandler(connPool, rdb, ctx)))                                                       // Protected
-	r.Handle("/ws/album", jwtMiddleware(h.WebSocketEndpointHandler(connPool, rdb, ctx)))                                                 // Protected
+	r.Handle("/ws", jwtMiddleware(h.WebSocketEndpointHandler(connPool, rdb, connectionRegistry, ctx)))                                   // Protected
+	r.Handle("/ws/album", jwtMiddleware(h.WebSocketEndpointHandler(connPool, rdb, connectionRegistry, ctx)))                             // Protected
+	r.Handle("/events", jwtMiddleware(h.EventStreamEndpointHandler(connPool, rdb, connectionRegistry, ctx))).Methods("GET")              // Protected
 	r.Handle("/search", jwtMiddleware(h.SearchEndpointHandler(ctx, connPool))).Methods("GET")                                            // Protected
-	r.Handle("/feed", jwtMiddleware(h.FeedEndpointHandler(ctx, connPool))).Methods("GET")                                                // Protected
+	r.Handle("/feed", jwtMiddleware(h.FeedEndpointHandler(ctx, connPool, rdb))).Methods("GET")                                           // Protected
 	r.Handle("/image", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("GET") // Protected
 	r.Handle("/image/comment", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("GET", "POST", "PATCH", "DELETE")      // Protected
 	r.Handle("/image/comment/seen", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("PATCH")                          // Protected
 	r.Handle("/image/like", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("POST", "DELETE")                         // Protected
 	r.Handle("/image/upvote", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("POST", "DELETE")                       // Protected
-	r.Handle("/album", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("GET", "POST", "DELETE")
-	r.Handle("/album/visibility", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("PATCH")
-	r.Handle("/album/timeline", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("PATCH")              // Protected
-	r.Handle("/album/images", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("GET")                  // Protected
-	r.Handle("/album/revealed", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("GET", "POST")        // Protected
-	r.Handle("/album/guests", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("GET", "POST")          // Protected
-	r.Handle("/upload", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("GET")                             // Protected
-	r.Handle("/user", jwtMiddleware(h.UserEndpointHandler(connPool, ctx))).Methods("GET", "POST", "PATCH")                                                            // Protected
-	r.Handle("/user/id", jwtMiddleware(h.UserEndpointHandler(connPool, ctx))).Methods("GET")                                                                          // Protected
-	r.Handle("/user/album", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, messagingClient, *gcpStorage, storageBucket))).Methods("GET", "PATCH", "DELETE") // Protected
-	r.Handle("/user/album/image", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("GET", "POST")                                                   // Protected
-	r.Handle("/user/recap", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("POST")                                                                // Protected
+	r.Handle("/album", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET", "POST", "DELETE")
+	r.Handle("/album/visibility", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("PATCH")
+	r.Handle("/album/timeline", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("PATCH")              // Protected
+	r.Handle("/album/images", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET")                  // Protected
+	r.Handle("/album/presence", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET")                // Protected
+	r.Handle("/album/mute", jwtMiddleware(h.PreferencesEndpointHandler(ctx, connPool))).Methods("POST", "DELETE")                                                 // Protected
+	r.Handle("/album/revealed", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET", "POST")        // Protected
+	r.Handle("/album/guests", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET", "POST")          // Protected
+	r.Handle("/album/archive", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("PATCH")               // Protected
+	r.Handle("/album/restore", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("POST")                // Protected
+	r.Handle("/album/invite", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("POST")                 // Protected
+	r.Handle("/album/template", jwtMiddleware(h.AlbumTemplateEndpointHandler(ctx, connPool, rdb, pushSenders))).Methods("GET", "POST", "PATCH", "DELETE")         // Protected
+	r.Handle("/album/template/run", jwtMiddleware(h.AlbumTemplateEndpointHandler(ctx, connPool, rdb, pushSenders))).Methods("POST")                               // Protected
+	r.Handle("/upload", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("GET")                         // Protected
+	r.Handle("/user", jwtMiddleware(h.UserEndpointHandler(connPool, ctx))).Methods("GET", "POST", "PATCH")                                                        // Protected
+	r.Handle("/user/id", jwtMiddleware(h.UserEndpointHandler(connPool, ctx))).Methods("GET")                                                                      // Protected
+	r.Handle("/user/sessions", jwtMiddleware(h.SessionEndpointHandler(ctx, connPool, rdb))).Methods("DELETE")                                                     // Protected
+	r.Handle("/user/album", jwtMiddleware(h.AlbumEndpointHandler(connPool, rdb, ctx, pushSenders, *gcpStorage, storageBucket))).Methods("GET", "PATCH", "DELETE") // Protected
+	r.Handle("/user/album/image", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("GET", "POST")                                               // Protected
+	r.Handle("/user/recap", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("POST")                                                            // Protected
 	r.Handle("/user/image", jwtMiddleware(h.ImageEndpointHandler(connPool, rdb, ctx))).Methods("GET", "POST", "PATCH")
-	r.Handle("/user/image", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("DELETE")      // Protected
-	r.Handle("/user/friend", jwtMiddleware(h.FriendEndpointHandler(ctx, connPool, rdb))).Methods("GET", "DELETE")                                     // Protected
-	r.Handle("/friend-request", jwtMiddleware(h.FriendRequestHandler(ctx, connPool, rdb, messagingClient))).Methods("POST", "PUT", "DELETE", "PATCH") // Protected
-	r.Handle("/album-invite", jwtMiddleware(h.AlbumRequestHandler(ctx, connPool, rdb))).Methods("PUT", "DELETE", "PATCH")                             // Protected
-	r.Handle("/notifications", jwtMiddleware(h.NotificationsEndpointHandler(ctx, connPool, rdb))).Methods("GET", "PATCH")                             // Protected
-	r.Handle("/fcm", jwtMiddleware(h.FirebaseHandlers(connPool, ctx))).Methods("PUT")
+	r.Handle("/user/image", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("DELETE")  // Protected
+	r.Handle("/user/friend", jwtMiddleware(h.FriendEndpointHandler(ctx, connPool, rdb))).Methods("GET", "DELETE")                                 // Protected
+	r.Handle("/user/friend/group", jwtMiddleware(h.FriendGroupEndpointHandler(ctx, connPool))).Methods("GET", "POST", "PATCH", "DELETE")          // Protected
+	r.Handle("/friend-request", jwtMiddleware(h.FriendRequestHandler(ctx, connPool, rdb, pushSenders))).Methods("POST", "PUT", "DELETE", "PATCH") // Protected
+	r.Handle("/album-invite", jwtMiddleware(h.AlbumRequestHandler(ctx, connPool, rdb))).Methods("PUT", "DELETE", "PATCH")                         // Protected
+	r.Handle("/notifications", jwtMiddleware(h.NotificationsEndpointHandler(ctx, connPool, rdb))).Methods("GET", "PATCH")                         // Protected
+	r.Handle("/notifications/legacy", jwtMiddleware(h.NotificationsEndpointHandler(ctx, connPool, rdb))).Methods("GET")                           // Protected
+	r.Handle("/notifications/summary", jwtMiddleware(h.NotificationsEndpointHandler(ctx, connPool, rdb))).Methods("GET")                          // Protected
+	r.Handle("/user/notifications/preferences", jwtMiddleware(h.PreferencesEndpointHandler(ctx, connPool))).Methods("GET", "PATCH")               // Protected
+	r.Handle("/notifications/read", jwtMiddleware(h.NotificationsEndpointHandler(ctx, connPool, rdb))).Methods("PATCH")                           // Protected
+	r.Handle("/fcm", jwtMiddleware(h.FirebaseHandlers(connPool, ctx))).Methods("PUT", "DELETE")
+	r.Handle("/admin/connections", jwtMiddleware(adminScope(h.AdminEndpointHandler(ctx, rdb)))).Methods("GET")                        // Protected, admin
+	r.Handle("/admin/connections/user", jwtMiddleware(adminScope(h.AdminEndpointHandler(ctx, rdb)))).Methods("GET", "DELETE")         // Protected, admin
+	r.Handle("/admin/events/dead-letters/requeue", jwtMiddleware(adminEventsScope(h.AdminEndpointHandler(ctx, rdb)))).Methods("POST") // Protected, admin
 	//r.Handle("/resize", jwtMiddleware(h.ContentEndpointHandler(ctx, connPool, *gcpStorage, storageBucket, stagingBucket))).Methods("POST")
 
 	//Start Server
@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/auth0/go-jwt-middleware/v2"
@@ -65,3 +66,37 @@ func EnsureValidToken(auth0Domain string, expectedAudience string) func(next htt
 		return middleware.CheckJWT(next)
 	}
 }
+
+// HasScope checks whether our claims have a specific scope.
+func (c CustomClaims) HasScope(expectedScope string) bool {
+	for _, scope := range strings.Split(c.Scope, " ") {
+		if scope == expectedScope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireScope is a middleware that rejects tokens without the scope, it has to be wrapped by EnsureValidToken.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+			if !ok {
+				log.Printf("Failed to get validated claims")
+				return
+			}
+
+			customClaims, ok := claims.CustomClaims.(*CustomClaims)
+			if !ok || !customClaims.HasScope(scope) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"Insufficient scope."}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
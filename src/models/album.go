@@ -6,18 +6,28 @@ import (
 )
 
 type Album struct {
-	AlbumID      string    `json:"album_id"`
-	AlbumName    string    `json:"album_name"`
-	AlbumOwner   string    `json:"album_owner"`
-	OwnerFirst   string    `json:"owner_first"`
-	OwnerLast    string    `json:"owner_last"`
-	AlbumCoverID string    `json:"album_cover_id"`
-	CreatedAt    time.Time `json:"created_at"`
-	RevealedAt   time.Time `json:"revealed_at"`
-	Visibility   string    `json:"visibility"`
-	InviteList   []Guest   `json:"invite_list"`
-	Images       []Image   `json:"images"`
-	Phase        string    `json:"phase"`
+	AlbumID      string     `json:"album_id"`
+	AlbumName    string     `json:"album_name"`
+	AlbumOwner   string     `json:"album_owner"`
+	OwnerFirst   string     `json:"owner_first"`
+	OwnerLast    string     `json:"owner_last"`
+	AlbumCoverID string     `json:"album_cover_id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevealedAt   time.Time  `json:"revealed_at"`
+	Visibility   string     `json:"visibility"`
+	InviteList   []Guest    `json:"invite_list"`
+	InviteGroups []string   `json:"invite_groups,omitempty"`
+	Images       []Image    `json:"images"`
+	Phase        string     `json:"phase"`
+	Archived     bool       `json:"archived"`
+	Score        *FeedScore `json:"score,omitempty"`
+}
+
+// BulkAlbumInvite invites guests and the members of friend groups to an album in one request
+type BulkAlbumInvite struct {
+	AlbumID  string   `json:"album_id"`
+	GuestIDs []string `json:"guest_ids"`
+	GroupIDs []string `json:"group_ids"`
 }
 
 func (album *Album) PhaseCalculation() error {
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AlbumTemplate struct {
+	TemplateID        string     `json:"template_id"`
+	TemplateOwner     string     `json:"template_owner"`
+	NamePattern       string     `json:"name_pattern"`
+	Visibility        string     `json:"visibility"`
+	GuestIDs          []string   `json:"guest_ids"`
+	GroupIDs          []string   `json:"group_ids"`
+	RevealOffsetHours int        `json:"reveal_offset_hours"`
+	Recurrence        string     `json:"recurrence"` // none, daily, weekly, biweekly, monthly
+	NextRunAt         *time.Time `json:"next_run_at"`
+	LastAlbumID       *string    `json:"last_album_id"`
+	RunCount          int        `json:"run_count"`
+	Active            bool       `json:"active"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// RenderName fills in the placeholders of the name pattern for an album created at runAt. Supported placeholders are
+// {date} (Jan 2), {week} (ISO week number), {year} and {n} (the 1-based run number).
+func (template AlbumTemplate) RenderName(runAt time.Time, run int) string {
+	_, week := runAt.ISOWeek()
+
+	replacer := strings.NewReplacer(
+		"{date}", runAt.Format("Jan 2"),
+		"{week}", strconv.Itoa(week),
+		"{year}", strconv.Itoa(runAt.Year()),
+		"{n}", strconv.Itoa(run),
+	)
+
+	return replacer.Replace(template.NamePattern)
+}
+
+// NextRun returns the run following from based on the recurrence of the template
+func (template AlbumTemplate) NextRun(from time.Time) (time.Time, error) {
+	switch template.Recurrence {
+	case "daily":
+		return from.AddDate(0, 0, 1), nil
+	case "weekly":
+		return from.AddDate(0, 0, 7), nil
+	case "biweekly":
+		return from.AddDate(0, 0, 14), nil
+	case "monthly":
+		return from.AddDate(0, 1, 0), nil
+	case "none":
+		return time.Time{}, errors.New("template does not recur")
+	}
+
+	return time.Time{}, fmt.Errorf("unknown recurrence: %v", template.Recurrence)
+}
+
+// Album builds the album that a run of the template at runAt should create
+func (template AlbumTemplate) Album(runAt time.Time, run int) Album {
+	album := Album{
+		AlbumName:    template.RenderName(runAt, run),
+		RevealedAt:   runAt.Add(time.Duration(template.RevealOffsetHours) * time.Hour),
+		Visibility:   template.Visibility,
+		InviteGroups: template.GroupIDs,
+	}
+
+	for _, guestID := range template.GuestIDs {
+		album.InviteList = append(album.InviteList, Guest{ID: guestID})
+	}
+
+	return album
+}
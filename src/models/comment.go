@@ -29,3 +29,11 @@ type UpdateComment struct {
 	ID      string `json:"id"`
 	Comment string `json:"comment"`
 }
+
+// TypingIndicator is broadcast to the viewers of an album while a user writes a comment on one of its images
+type TypingIndicator struct {
+	UserID    string `json:"user_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	ImageID   string `json:"image_id"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// NodeConnections is the number of live WebSocket and event stream connections a node holds
+type NodeConnections struct {
+	NodeID        string    `json:"node_id"`
+	Connections   int       `json:"connections"`
+	Users         int       `json:"users"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+}
+
+type ConnectionStats struct {
+	Nodes       []NodeConnections `json:"nodes"`
+	Connections int               `json:"connections"`
+}
+
+type UserConnection struct {
+	ConnectionID string `json:"connection_id"`
+	NodeID       string `json:"node_id"`
+}
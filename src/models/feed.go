@@ -0,0 +1,11 @@
+package models
+
+// FeedScore is how a ranked feed album was scored, it is only returned when the feed is asked for with debug. Each
+// component is between 0 and 1 and Score is their weighted sum.
+type FeedScore struct {
+	Score      float64 `json:"score"`
+	Affinity   float64 `json:"affinity"`
+	Recency    float64 `json:"recency"`
+	Phase      float64 `json:"phase"`
+	Engagement float64 `json:"engagement"`
+}
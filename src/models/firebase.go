@@ -1,5 +1,7 @@
 package models
 
+// FirebaseNotification is a push to a user. Type is the push kind the title and body are rendered from, see the push
+// package, RequesterName is the actor and ContentName the album or other content it is about.
 type FirebaseNotification struct {
 	NotificationID string `json:"notification_id"`
 	ContentName    string `json:"content_name"`
@@ -7,4 +9,15 @@ type FirebaseNotification struct {
 	RequesterName  string `json:"requester_name"`
 	RecipientID    string `json:"recipient_id"`
 	Type           string `json:"type"`
+	// Summaries name a second actor and count the remaining actors and the photos
+	OtherName string `json:"other_name,omitempty"`
+	Others    int    `json:"others,omitempty"`
+	Count     int    `json:"count,omitempty"`
+	// Notifications with the same collapse key replace each other on the device
+	CollapseKey string `json:"collapse_key,omitempty"`
+	// Category is the event type the user's push preference is looked up by, AlbumID is checked against their mutes
+	Category string `json:"category,omitempty"`
+	AlbumID  string `json:"album_id,omitempty"`
+	// ImageID is sent along so the app can open the photo
+	ImageID string `json:"image_id,omitempty"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type FriendGroup struct {
+	GroupID   string    `json:"group_id"`
+	GroupName string    `json:"group_name"`
+	OwnerID   string    `json:"owner_id"`
+	MemberIDs []string  `json:"member_ids,omitempty"` // Only used when creating or updating a group
+	Members   []Friend  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"strconv"
 	"time"
 )
@@ -92,14 +93,62 @@ func (notification FriendRequestNotification) FirebaseToMap() map[string]string
 	}
 }
 
+// SummaryNotification groups the engagement on an album's photos of one type within a time window, e.g. "Ann, Bo
+// and 3 others liked 9 photos in Lake Trip". Actors are the most recent ones, ActorTotal counts all of them.
 type SummaryNotification struct {
-	NotificationType string    `json:"notification_type"`
-	NameOne          string    `json:"name_one"`
-	NameTwo          string    `json:"name_two"`
-	AlbumName        string    `json:"album_name"`
-	AlbumID          string    `json:"album_id"`
-	AlbumCoverID     string    `json:"album_cover_id"`
-	ReceivedAt       time.Time `json:"received_at"`
-	AlbumTypeTotal   int       `json:"album_type_total"`
-	RequestSeen      bool      `json:"request_seen"`
+	NotificationType string         `json:"notification_type"`
+	NameOne          string         `json:"name_one"`
+	NameTwo          string         `json:"name_two"`
+	Actors           []SummaryActor `json:"actors"`
+	ActorTotal       int            `json:"actor_total"`
+	AlbumName        string         `json:"album_name"`
+	AlbumID          string         `json:"album_id"`
+	AlbumCoverID     string         `json:"album_cover_id"`
+	WindowStart      time.Time      `json:"window_start"`
+	ReceivedAt       time.Time      `json:"received_at"`
+	AlbumTypeTotal   int            `json:"album_type_total"`
+	PhotoTotal       int            `json:"photo_total"`
+	RequestSeen      bool           `json:"request_seen"`
+}
+
+type SummaryActor struct {
+	UserID    string `json:"user_id"`
+	FirstName string `json:"first_name"`
+}
+
+// InboxNotification is a row of the unified notification inbox. Type is the event type that created it, Payload is
+// the notification of that event, e.g. an EngagementNotification for image.liked.
+type InboxNotification struct {
+	NotificationID string          `json:"notification_id"`
+	Type           string          `json:"type"`
+	ActorID        *string         `json:"actor_id"`
+	AlbumID        *string         `json:"album_id"`
+	SubjectID      string          `json:"subject_id"`
+	Payload        json.RawMessage `json:"payload"`
+	Read           bool            `json:"read"`
+	ReadAt         *time.Time      `json:"read_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type NotificationInbox struct {
+	Notifications []InboxNotification `json:"notifications"`
+	NextCursor    string              `json:"next_cursor,omitempty"`
+	UnreadCount   int                 `json:"unread_count"`
+	UnreadByType  map[string]int      `json:"unread_by_type"`
+}
+
+// MarkReadRequest selects the notifications to mark as read, the filters combine and an empty request marks every
+// notification
+type MarkReadRequest struct {
+	NotificationIDs []string   `json:"notification_ids,omitempty"`
+	Before          *time.Time `json:"before,omitempty"`
+	Type            string     `json:"type,omitempty"`
+	AlbumID         string     `json:"album_id,omitempty"`
+}
+
+type NotificationReadState struct {
+	Marked       int            `json:"marked"`
+	UnreadCount  int            `json:"unread_count"`
+	UnreadByType map[string]int `json:"unread_by_type"`
+	ReadAt       time.Time      `json:"read_at"`
 }
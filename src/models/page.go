@@ -0,0 +1,8 @@
+package models
+
+// Page is one page of a list endpoint. NextCursor is passed as cursor to get the next page and is empty on the last
+// page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
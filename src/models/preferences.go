@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+type NotificationPreference struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"` // in_app, push, email
+	Enabled bool   `json:"enabled"`
+}
+
+type AlbumMute struct {
+	AlbumID    string     `json:"album_id"`
+	AlbumName  string     `json:"album_name"`
+	MutedUntil *time.Time `json:"muted_until"`
+}
+
+// NotificationSettings are all of a user's notification preferences. Quiet hours are times of day like 22:00 in the
+// user's timezone, both are empty when quiet hours are off.
+type NotificationSettings struct {
+	Preferences     []NotificationPreference `json:"preferences"`
+	Timezone        string                   `json:"timezone"`
+	Locale          string                   `json:"locale"`
+	QuietHoursStart string                   `json:"quiet_hours_start"`
+	QuietHoursEnd   string                   `json:"quiet_hours_end"`
+	MutedAlbums     []AlbumMute              `json:"muted_albums"`
+}
+
+// NotificationSettingsUpdate changes the given preferences, fields that are left out are kept
+type NotificationSettingsUpdate struct {
+	Preferences     []NotificationPreference `json:"preferences"`
+	Timezone        *string                  `json:"timezone"`
+	Locale          *string                  `json:"locale"`
+	QuietHoursStart *string                  `json:"quiet_hours_start"`
+	QuietHoursEnd   *string                  `json:"quiet_hours_end"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// AlbumPresence is a user that currently has the album open
+type AlbumPresence struct {
+	ID         string    `json:"user_id"`
+	FirstName  string    `json:"first_name"`
+	LastName   string    `json:"last_name"`
+	LastActive time.Time `json:"last_active"`
+}
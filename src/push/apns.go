@@ -0,0 +1,202 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	apnsProductionHost  = "https://api.push.apple.com"
+	apnsDevelopmentHost = "https://api.sandbox.push.apple.com"
+
+	// APNs rejects provider tokens older than an hour and ones refreshed more often than every 20 minutes
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsConfig is the token-based authentication of an APNs key. Key is the contents of the .p8 file and Topic the
+// bundle ID of the app.
+type APNsConfig struct {
+	Key        []byte
+	KeyID      string
+	TeamID     string
+	Topic      string
+	Production bool
+}
+
+// APNsSender sends pushes straight to the Apple Push Notification service over HTTP/2
+type APNsSender struct {
+	config APNsConfig
+	key    *ecdsa.PrivateKey
+	host   string
+	client *http.Client
+
+	mu          sync.Mutex
+	bearer      string
+	bearerIssue time.Time
+}
+
+func NewAPNsSender(config APNsConfig) (*APNsSender, error) {
+	block, _ := pem.Decode(config.Key)
+	if block == nil {
+		return nil, errors.New("apns key is not PEM encoded")
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing apns key: %w", err)
+	}
+
+	key, ok := parsedKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns key is not an ECDSA key")
+	}
+
+	host := apnsDevelopmentHost
+	if config.Production {
+		host = apnsProductionHost
+	}
+
+	return &APNsSender{
+		config: config,
+		key:    key,
+		host:   host,
+		// TLS connections to APNs negotiate HTTP/2
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type apnsAlert struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type apnsAps struct {
+	Alert apnsAlert `json:"alert"`
+	Badge *int      `json:"badge,omitempty"`
+	Sound string    `json:"sound"`
+}
+
+type apnsResponse struct {
+	Reason string `json:"reason"`
+}
+
+// APNs sends one request per token, requests share the HTTP/2 connection
+func (sender *APNsSender) Send(ctx context.Context, message Message) ([]Result, error) {
+	payload := map[string]interface{}{
+		"aps": apnsAps{Alert: apnsAlert{Title: message.Title, Body: message.Body}, Badge: message.Badge, Sound: "default"},
+	}
+	for key, value := range message.Data {
+		payload[key] = value
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	bearer, err := sender.providerToken()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(message.Tokens))
+	for i, token := range message.Tokens {
+		results[i] = Result{Token: token, Err: sender.sendToDevice(ctx, bearer, token, message.CollapseKey, body)}
+	}
+	return results, nil
+}
+
+func (sender *APNsSender) sendToDevice(ctx context.Context, bearer string, token string, collapseKey string, body []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("authorization", "bearer "+bearer)
+	request.Header.Set("apns-topic", sender.config.Topic)
+	request.Header.Set("apns-push-type", "alert")
+	request.Header.Set("apns-priority", "10")
+	if collapseKey != "" {
+		request.Header.Set("apns-collapse-id", collapseKey)
+	}
+
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reason apnsResponse
+	json.NewDecoder(response.Body).Decode(&reason)
+
+	return apnsError(response.StatusCode, reason.Reason)
+}
+
+// apnsError wraps the status and reason APNs answered with in the push error they correspond to
+func apnsError(status int, reason string) error {
+	err := fmt.Errorf("apns %v: %v", status, reason)
+
+	switch {
+	case status == http.StatusGone, reason == "BadDeviceToken", reason == "DeviceTokenNotForTopic":
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	case status == http.StatusTooManyRequests, status >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	case status == http.StatusBadRequest:
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	return err
+}
+
+// providerToken returns the JWT APNs authenticates the requests with, signed again once it gets too old
+func (sender *APNsSender) providerToken() (string, error) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	if sender.bearer != "" && time.Since(sender.bearerIssue) < apnsTokenLifetime {
+		return sender.bearer, nil
+	}
+
+	issuedAt := time.Now()
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": sender.config.KeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{"iss": sender.config.TeamID, "iat": issuedAt.Unix()})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	r, s, err := ecdsa.Sign(rand.Reader, sender.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are r and s as fixed size big endian integers
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	sender.bearer = unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	sender.bearerIssue = issuedAt
+	return sender.bearer, nil
+}
@@ -0,0 +1,56 @@
+package push
+
+import (
+	"context"
+	"sync"
+)
+
+// Fake records the messages it is given instead of sending them, for tests and for running the service without push
+// credentials. Every token is accepted unless it was marked with Fail.
+type Fake struct {
+	mu       sync.Mutex
+	messages []Message
+	failures map[string]error
+}
+
+func NewFake() *Fake {
+	return &Fake{failures: make(map[string]error)}
+}
+
+func (fake *Fake) Send(ctx context.Context, message Message) ([]Result, error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.messages = append(fake.messages, message)
+
+	results := make([]Result, len(message.Tokens))
+	for i, token := range message.Tokens {
+		results[i] = Result{Token: token, Err: fake.failures[token]}
+	}
+	return results, nil
+}
+
+// Fail makes every later push to the token fail with the error, e.g. ErrInvalidToken
+func (fake *Fake) Fail(token string, err error) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.failures[token] = err
+}
+
+// Sent returns the messages sent so far in the order they were sent
+func (fake *Fake) Sent() []Message {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]Message(nil), fake.messages...)
+}
+
+// Reset forgets the sent messages and the failures
+func (fake *Fake) Reset() {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	fake.messages = nil
+	fake.failures = make(map[string]error)
+}
@@ -0,0 +1,62 @@
+package push
+
+import (
+	"context"
+	"fmt"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// FCMSender sends pushes through Firebase Cloud Messaging
+type FCMSender struct {
+	client *messaging.Client
+}
+
+func NewFCMSender(client *messaging.Client) *FCMSender {
+	return &FCMSender{client: client}
+}
+
+func (sender *FCMSender) Send(ctx context.Context, message Message) ([]Result, error) {
+	multicast := messaging.MulticastMessage{
+		Data:   message.Data,
+		Tokens: message.Tokens,
+		Notification: &messaging.Notification{
+			Title: message.Title,
+			Body:  message.Body,
+		},
+		APNS: &messaging.APNSConfig{
+			Payload: &messaging.APNSPayload{Aps: &messaging.Aps{Badge: message.Badge}},
+		},
+	}
+
+	if message.CollapseKey != "" {
+		multicast.Android = &messaging.AndroidConfig{CollapseKey: message.CollapseKey}
+		multicast.APNS.Headers = map[string]string{"apns-collapse-id": message.CollapseKey}
+	}
+
+	response, err := sender.client.SendEachForMulticast(ctx, &multicast)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, len(message.Tokens))
+	for i, response := range response.Responses {
+		results[i] = Result{Token: message.Tokens[i], Err: fcmError(response.Error)}
+	}
+	return results, nil
+}
+
+// fcmError wraps the FCM error of a token in the push error it corresponds to
+func fcmError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case messaging.IsUnregistered(err) || messaging.IsSenderIDMismatch(err):
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	case messaging.IsInvalidArgument(err):
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	case messaging.IsUnavailable(err) || messaging.IsInternal(err) || messaging.IsQuotaExceeded(err):
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return err
+}
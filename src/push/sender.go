@@ -0,0 +1,47 @@
+package push
+
+import (
+	"context"
+	"errors"
+)
+
+// Providers a device token can belong to, stored with the token in firebase_tokens
+const (
+	FCM  = "fcm"
+	APNs = "apns"
+)
+
+// Errors a Sender wraps its per-token failures in, so delivery can react to them the same way for every provider
+var (
+	// ErrInvalidToken means the token is no longer registered or was never valid and should be deleted
+	ErrInvalidToken = errors.New("invalid push token")
+	// ErrInvalidArgument means the provider rejected the message, the token may or may not be at fault
+	ErrInvalidArgument = errors.New("push rejected")
+	// ErrUnavailable means the provider failed transiently and the token can be tried again
+	ErrUnavailable = errors.New("push provider unavailable")
+)
+
+// Message is a rendered push to a set of device tokens of one provider
+type Message struct {
+	Tokens      []string
+	Title       string
+	Body        string
+	Data        map[string]string
+	Badge       *int
+	CollapseKey string
+}
+
+// Result is the outcome of a message for one of its tokens, Err is nil when the push was accepted
+type Result struct {
+	Token string
+	Err   error
+}
+
+// Sender delivers messages through a push provider. Send returns a result for every token of the message, the error
+// is only set when the whole message failed.
+type Sender interface {
+	Send(ctx context.Context, message Message) ([]Result, error)
+}
+
+// Senders maps a provider to the Sender for its tokens
+type Senders map[string]Sender
@@ -0,0 +1,177 @@
+package push
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of push notifications, the kind is the type in the data payload the apps route on
+const (
+	AlbumInvite           = "album-invite"
+	AlbumInviteAccepted   = "album-invite-accepted"
+	FriendRequest         = "friend-request"
+	FriendRequestAccepted = "friend-request-accepted"
+	EngagementSummary     = "engagement-summary"
+	ImageUploaded         = "image-uploaded"
+	AlbumRevealed         = "album-revealed"
+)
+
+// DefaultLocale is used for users without a locale and for locales without templates
+const DefaultLocale = "en"
+
+// Params fill the placeholders of a template. Actor is the user who caused the push, Other is the second actor of a
+// summary and Others the number of actors besides the two.
+type Params struct {
+	Actor  string
+	Other  string
+	Others int
+	Album  string
+	Count  int
+}
+
+// Template is the title and body of a push. Placeholders are written {actor}, {other}, {others}, {album} and
+// {count}. BodyPair is used instead of Body when there is a second actor and BodyGroup when there are more.
+type Template struct {
+	Title     string
+	Body      string
+	BodyPair  string
+	BodyGroup string
+}
+
+// templates holds every kind per locale, a locale has to define all kinds of DefaultLocale
+var templates = map[string]map[string]Template{
+	"en": {
+		AlbumInvite: {
+			Title: "Accept invite to {album}",
+			Body:  "{actor} sent you an album invite.",
+		},
+		AlbumInviteAccepted: {
+			Title: "{album}",
+			Body:  "{actor} joined the album.",
+		},
+		FriendRequest: {
+			Title: "New Friend Request!",
+			Body:  "{actor} sent you a friend request.",
+		},
+		FriendRequestAccepted: {
+			Title: "You have a new friend!",
+			Body:  "{actor} accepted your friend request.",
+		},
+		ImageUploaded: {
+			Title: "{album}",
+			Body:  "{actor} added a photo.",
+		},
+		AlbumRevealed: {
+			Title: "{album} is revealed!",
+			Body:  "See everyone's photos now.",
+		},
+		SummaryKind("image.liked"): {
+			Title:     "{album}",
+			Body:      "{actor} liked {count} of your photos.",
+			BodyPair:  "{actor} and {other} liked {count} of your photos.",
+			BodyGroup: "{actor}, {other} and {others} others liked {count} of your photos.",
+		},
+		SummaryKind("image.upvoted"): {
+			Title:     "{album}",
+			Body:      "{actor} upvoted {count} of your photos.",
+			BodyPair:  "{actor} and {other} upvoted {count} of your photos.",
+			BodyGroup: "{actor}, {other} and {others} others upvoted {count} of your photos.",
+		},
+		SummaryKind("comment.added"): {
+			Title:     "{album}",
+			Body:      "{actor} commented on {count} of your photos.",
+			BodyPair:  "{actor} and {other} commented on {count} of your photos.",
+			BodyGroup: "{actor}, {other} and {others} others commented on {count} of your photos.",
+		},
+	},
+	"es": {
+		AlbumInvite: {
+			Title: "Acepta la invitación a {album}",
+			Body:  "{actor} te invitó a un álbum.",
+		},
+		AlbumInviteAccepted: {
+			Title: "{album}",
+			Body:  "{actor} se unió al álbum.",
+		},
+		FriendRequest: {
+			Title: "¡Nueva solicitud de amistad!",
+			Body:  "{actor} te envió una solicitud de amistad.",
+		},
+		FriendRequestAccepted: {
+			Title: "¡Tienes un nuevo amigo!",
+			Body:  "{actor} aceptó tu solicitud de amistad.",
+		},
+		ImageUploaded: {
+			Title: "{album}",
+			Body:  "{actor} agregó una foto.",
+		},
+		AlbumRevealed: {
+			Title: "¡{album} ya se reveló!",
+			Body:  "Mira las fotos de todos ahora.",
+		},
+		SummaryKind("image.liked"): {
+			Title:     "{album}",
+			Body:      "A {actor} le gustaron {count} de tus fotos.",
+			BodyPair:  "A {actor} y {other} les gustaron {count} de tus fotos.",
+			BodyGroup: "A {actor}, {other} y {others} más les gustaron {count} de tus fotos.",
+		},
+		SummaryKind("image.upvoted"): {
+			Title:     "{album}",
+			Body:      "{actor} votó por {count} de tus fotos.",
+			BodyPair:  "{actor} y {other} votaron por {count} de tus fotos.",
+			BodyGroup: "{actor}, {other} y {others} más votaron por {count} de tus fotos.",
+		},
+		SummaryKind("comment.added"): {
+			Title:     "{album}",
+			Body:      "{actor} comentó {count} de tus fotos.",
+			BodyPair:  "{actor} y {other} comentaron {count} de tus fotos.",
+			BodyGroup: "{actor}, {other} y {others} más comentaron {count} de tus fotos.",
+		},
+	},
+}
+
+// SummaryKind is the kind of an engagement summary of the event type, e.g. image.liked
+func SummaryKind(eventType string) string {
+	return EngagementSummary + ":" + eventType
+}
+
+// Render fills in the template of the kind in the locale. Locales are matched by their language, so es-MX uses the
+// es templates, and anything without templates is rendered in DefaultLocale.
+func Render(locale string, kind string, params Params) (title string, body string, err error) {
+	localeTemplates, ok := templates[language(locale)]
+	if !ok {
+		localeTemplates = templates[DefaultLocale]
+	}
+
+	template, ok := localeTemplates[kind]
+	if !ok {
+		return "", "", fmt.Errorf("no push template for %v", kind)
+	}
+
+	body = template.Body
+	switch {
+	case params.Others > 0 && template.BodyGroup != "":
+		body = template.BodyGroup
+	case params.Other != "" && template.BodyPair != "":
+		body = template.BodyPair
+	}
+
+	replacer := strings.NewReplacer(
+		"{actor}", params.Actor,
+		"{other}", params.Other,
+		"{others}", strconv.Itoa(params.Others),
+		"{album}", params.Album,
+		"{count}", strconv.Itoa(params.Count),
+	)
+
+	return replacer.Replace(template.Title), replacer.Replace(body), nil
+}
+
+func language(locale string) string {
+	locale = strings.ToLower(locale)
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		return locale[:i]
+	}
+	return locale
+}